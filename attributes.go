@@ -0,0 +1,135 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+)
+
+type queryAttributesKey struct{}
+
+// WithQueryAttributes returns a copy of ctx that attaches attrs as query
+// attributes to the statements run with it. Query attributes are visible to
+// server plugins, and to SQL through mysql_query_attribute_string(). Their
+// values may be of any type that database/sql accepts as an argument.
+//
+// Query attributes need MySQL 8.0.23 or later. They are dropped on servers
+// that do not support them.
+func WithQueryAttributes(ctx context.Context, attrs map[string]any) context.Context {
+	return context.WithValue(ctx, queryAttributesKey{}, attrs)
+}
+
+// queryAttribute is a single query attribute.
+type queryAttribute struct {
+	name  string
+	value drv.Value
+}
+
+// queryAttributes returns the query attributes in ctx, ordered by name.
+func queryAttributes(ctx context.Context) ([]queryAttribute, error) {
+	attrMap, _ := ctx.Value(queryAttributesKey{}).(map[string]any)
+	if len(attrMap) == 0 {
+		return nil, nil
+	}
+
+	attrs := make([]queryAttribute, 0, len(attrMap))
+	for name, v := range attrMap {
+		value, err := drv.DefaultParameterConverter.ConvertValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("query attribute %q: %v", name, err)
+		}
+		attrs = append(attrs, queryAttribute{name: name, value: value})
+	}
+
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].name < attrs[j].name })
+	return attrs, nil
+}
+
+// appendQueryAttributes returns params followed by the values of attrs, and
+// the names to send for them, which are empty for params.
+func appendQueryAttributes(params []drv.Value, attrs []queryAttribute) ([]drv.Value, []string) {
+	values := make([]drv.Value, len(params), len(params)+len(attrs))
+	copy(values, params)
+	names := make([]string, len(params), len(params)+len(attrs))
+
+	for _, attr := range attrs {
+		values = append(values, attr.value)
+		names = append(names, attr.name)
+	}
+	return values, names
+}
+
+// sendQueryCommand sends query in a COM_QUERY, along with attrs if the server
+// supports query attributes.
+func (c *conn) sendQueryCommand(query string, attrs []queryAttribute) error {
+	if c.clientFlags&flagQueryAttributes == 0 {
+		err := c.sendCommandString(comQuery, query)
+		if err != nil {
+			return err
+		}
+		c.querySent()
+		return nil
+	}
+
+	values, names := appendQueryAttributes(nil, attrs)
+
+	// The command byte is followed by the number of attributes, and the
+	// number of sets of them, which is always 1.
+	n, _ := c.WriteLengthEncodedInt(globalCountingWriter, uint64(len(values)))
+	size := 1 + int64(n) + 1 + int64(len(query))
+	if len(values) > 0 {
+		paramsSize, err := c.binaryParamsSize(values, names)
+		if err != nil {
+			return err
+		}
+		size += paramsSize
+	}
+
+	err := c.checkPacketSize(size)
+	if err != nil {
+		return err
+	}
+
+	c.resetSeq()
+
+	c.BeginPacket(size)
+
+	c.scratch[0] = comQuery
+	_, err = c.Write(c.scratch[:1])
+	if err != nil {
+		return err
+	}
+
+	_, err = c.WriteLengthEncodedInt(c, uint64(len(values)))
+	if err != nil {
+		return err
+	}
+
+	c.scratch[0] = 1
+	_, err = c.Write(c.scratch[:1])
+	if err != nil {
+		return err
+	}
+
+	if len(values) > 0 {
+		err = c.writeBinaryParams(values, names)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(c, query)
+	if err != nil {
+		return err
+	}
+
+	err = c.EndPacket(FLUSH)
+	if err != nil {
+		return err
+	}
+
+	c.querySent()
+	return nil
+}
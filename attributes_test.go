@@ -0,0 +1,82 @@
+package gms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+func TestQueryAttributes(t *testing.T) {
+	tests := []struct {
+		name      string
+		supported bool
+		want      []map[string]any
+	}{
+		{
+			name:      "supported",
+			supported: true,
+			want: []map[string]any{
+				{"request_id": "r-17", "shard": int64(4)},
+				nil,
+			},
+		},
+		{
+			name:      "unsupported",
+			supported: false,
+			want:      []map[string]any{nil, nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := gmstest.NewUnstartedServer()
+			s.QueryAttributes = test.supported
+			startTestServer(t, s)
+			s.AddResult("INSERT INTO t VALUES (1)", gmstest.Result{AffectedRows: 1})
+			s.AddResult("INSERT INTO t VALUES (2)", gmstest.Result{AffectedRows: 1})
+
+			sqlConn := openTestConn(t, s.Addr, func(cfg *Config) {
+				cfg.MaxAllowedPacket = 1 << 20
+			})
+			s.Statements()
+
+			ctx := WithQueryAttributes(context.Background(), map[string]any{
+				"request_id": "r-17",
+				"shard":      4,
+			})
+			_, err := sqlConn.ExecContext(ctx, "INSERT INTO t VALUES (1)")
+			if err != nil {
+				t.Fatalf("ExecContext error: %v", err)
+			}
+			_, err = sqlConn.ExecContext(context.Background(), "INSERT INTO t VALUES (2)")
+			if err != nil {
+				t.Fatalf("ExecContext error: %v", err)
+			}
+
+			var queries []string
+			var attributes []map[string]any
+			for _, st := range s.Statements() {
+				queries = append(queries, st.Query)
+				attributes = append(attributes, st.Attributes)
+			}
+
+			wantQueries := []string{"INSERT INTO t VALUES (1)", "INSERT INTO t VALUES (2)"}
+			if !reflect.DeepEqual(queries, wantQueries) {
+				t.Errorf("queries = %q, want %q", queries, wantQueries)
+			}
+			if !reflect.DeepEqual(attributes, test.want) {
+				t.Errorf("attributes = %v, want %v", attributes, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryAttributesBadValue(t *testing.T) {
+	ctx := WithQueryAttributes(context.Background(), map[string]any{"bad": struct{}{}})
+	_, err := queryAttributes(ctx)
+	if err == nil {
+		t.Errorf("queryAttributes succeeded with an unsupported value")
+	}
+}
@@ -0,0 +1,426 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	drv "database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// BulkMode is the way a BulkInserter sends rows to the server.
+type BulkMode string
+
+const (
+	// BulkInsert sends each batch of rows as a multi-row INSERT prepared
+	// statement.
+	BulkInsert BulkMode = "insert"
+
+	// BulkLoadData streams each batch of rows as a CSV file, with LOAD DATA
+	// LOCAL INFILE. The connection must have been opened with
+	// AllowLocalInfile.
+	BulkLoadData BulkMode = "loaddata"
+)
+
+// maxPlaceholders is the most placeholders a prepared statement may have.
+const maxPlaceholders = math.MaxUint16
+
+// defaultLoadDataBatchSize is the size of the CSV files that BulkLoadData
+// sends, unless BulkOptions.MaxBatchBytes says otherwise.
+const defaultLoadDataBatchSize = 16 << 20
+
+// BulkOptions configures a BulkInserter.
+type BulkOptions struct {
+	// The way rows are sent. Defaults to BulkInsert.
+	Mode BulkMode
+
+	// The most rows to send in a batch, or zero for no limit beyond
+	// MaxBatchBytes.
+	MaxBatchRows int
+
+	// The most bytes of row data to send in a batch. With BulkInsert, it
+	// defaults to, and may not exceed, the server's max_allowed_packet.
+	// With BulkLoadData, it defaults to 16MB.
+	MaxBatchBytes int
+}
+
+// BulkResult is the outcome of sending one batch of rows.
+type BulkResult struct {
+	// The number of rows in the batch.
+	Rows int
+
+	// The number of rows that the server reported as affected.
+	RowsAffected int64
+
+	// The error that the batch failed with, if any.
+	Err error
+}
+
+// BulkInserter inserts many rows into a table, in batches that are each sent
+// as a single statement. It is not safe for concurrent use.
+//
+//	b, err := gms.NewBulkInserter(ctx, sqlConn, "events", []string{"id", "name"}, gms.BulkOptions{})
+//	...
+//	for _, e := range events {
+//		if err := b.Add(ctx, e.ID, e.Name); err != nil {
+//			...
+//		}
+//	}
+//	err = b.Close(ctx)
+type BulkInserter struct {
+	conn    *sql.Conn
+	table   string
+	columns []string
+	opts    BulkOptions
+
+	// Settings of the connection that affect how rows are encoded.
+	loc                *time.Location
+	noBackslashEscapes bool
+
+	// The rows of the current batch, and their estimated size.
+	rows [][]drv.Value
+	size int
+
+	// The last INSERT statement prepared, and the number of rows it inserts.
+	stmt     *sql.Stmt
+	stmtRows int
+
+	results []BulkResult
+}
+
+// NewBulkInserter returns a BulkInserter that inserts rows into the given
+// columns of table, on sqlConn, which must be a connection opened by this
+// driver.
+func NewBulkInserter(ctx context.Context, sqlConn *sql.Conn, table string, columns []string, opts BulkOptions) (*BulkInserter, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("no columns to insert into")
+	} else if len(columns) > maxPlaceholders {
+		return nil, fmt.Errorf("too many columns: %d", len(columns))
+	}
+
+	if opts.Mode == "" {
+		opts.Mode = BulkInsert
+	}
+	if opts.MaxBatchRows < 0 || opts.MaxBatchBytes < 0 {
+		return nil, fmt.Errorf("invalid BulkOptions: %+v", opts)
+	}
+
+	b := &BulkInserter{
+		conn:    sqlConn,
+		table:   table,
+		columns: columns,
+	}
+
+	err := sqlConn.Raw(func(driverConn any) error {
+		var c *conn
+		switch dc := driverConn.(type) {
+		case *conn:
+			c = dc
+		case *splitConn:
+			c = dc.primary
+		default:
+			return fmt.Errorf("BulkInserter needs a gms connection, not %T", driverConn)
+		}
+
+		b.loc = c.cfg.Loc
+		b.noBackslashEscapes = c.status&statusNoBackslashEscapes != 0
+
+		switch opts.Mode {
+		case BulkInsert:
+			if c.maxAllowedPacket > 0 && (opts.MaxBatchBytes == 0 || int64(opts.MaxBatchBytes) > c.maxAllowedPacket) {
+				opts.MaxBatchBytes = int(c.maxAllowedPacket)
+			}
+		case BulkLoadData:
+			if !c.cfg.AllowLocalInfile {
+				return errors.New("BulkLoadData needs a connection opened with AllowLocalInfile")
+			}
+			if opts.MaxBatchBytes == 0 {
+				opts.MaxBatchBytes = defaultLoadDataBatchSize
+			}
+		default:
+			return fmt.Errorf("unknown BulkMode: %q", opts.Mode)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.opts = opts
+	return b, nil
+}
+
+// Add adds a row, with a value for each column, to the current batch. The
+// values may be of any type that database/sql accepts as an argument. If the
+// row does not fit in the current batch, the batch is sent first, and its error
+// returned, if any; the row is added to the next batch regardless.
+func (b *BulkInserter) Add(ctx context.Context, values ...any) error {
+	if len(values) != len(b.columns) {
+		return fmt.Errorf("row has %d values, want %d", len(values), len(b.columns))
+	}
+
+	row := make([]drv.Value, len(values))
+	rowSize := 0
+	for i, v := range values {
+		value, err := drv.DefaultParameterConverter.ConvertValue(v)
+		if err != nil {
+			return fmt.Errorf("column %q: %v", b.columns[i], err)
+		}
+		if t, ok := value.(time.Time); ok {
+			value = t.In(b.loc)
+		}
+		row[i] = value
+		rowSize += b.valueSize(value)
+	}
+
+	var err error
+	if len(b.rows) > 0 && !b.fits(rowSize) {
+		err = b.Flush(ctx)
+	}
+
+	b.rows = append(b.rows, row)
+	b.size += rowSize
+	return err
+}
+
+// fits returns true if a row of rowSize bytes may be added to the current
+// batch.
+func (b *BulkInserter) fits(rowSize int) bool {
+	if b.opts.MaxBatchRows > 0 && len(b.rows) >= b.opts.MaxBatchRows {
+		return false
+	}
+	if b.opts.Mode == BulkInsert && (len(b.rows)+1)*len(b.columns) > maxPlaceholders {
+		return false
+	}
+	return b.batchSize(len(b.rows)+1, b.size+rowSize) <= b.opts.MaxBatchBytes
+}
+
+// batchSize returns the size of the largest packet sent for a batch of rows
+// whose values take size bytes.
+func (b *BulkInserter) batchSize(rows, size int) int {
+	if b.opts.Mode == BulkLoadData {
+		return size
+	}
+
+	// The COM_STMT_PREPARE holds the query, which grows by a group of
+	// placeholders per row. The COM_STMT_EXECUTE holds a NULL bitmap, the
+	// types and the values.
+	params := rows * len(b.columns)
+	prepareSize := 1 + len(b.insertPrefix()) + rows*(2*len(b.columns)+2)
+	executeSize := 11 + (params+7)/8 + 1 + 2*params + size
+	if prepareSize > executeSize {
+		return prepareSize
+	}
+	return executeSize
+}
+
+// valueSize returns the number of bytes that v takes when sent to the server.
+func (b *BulkInserter) valueSize(v drv.Value) int {
+	if b.opts.Mode == BulkLoadData {
+		buf, _ := b.appendCSVValue(nil, v)
+		return len(buf) + 1
+	}
+
+	switch v := v.(type) {
+	case int64, float64:
+		return 8
+	case bool:
+		return 1
+	case []byte:
+		return 9 + len(v)
+	case string:
+		return 9 + len(v)
+	case time.Time:
+		return 12
+	}
+	return 0
+}
+
+// Flush sends the current batch, if it has any rows, and returns its error.
+func (b *BulkInserter) Flush(ctx context.Context) error {
+	if len(b.rows) == 0 {
+		return nil
+	}
+
+	var result BulkResult
+	switch b.opts.Mode {
+	case BulkInsert:
+		result = b.sendInsert(ctx)
+	case BulkLoadData:
+		result = b.sendLoadData(ctx)
+	}
+
+	b.results = append(b.results, result)
+	b.rows = b.rows[:0]
+	b.size = 0
+	return result.Err
+}
+
+// Results returns the results of the batches sent so far, in order.
+func (b *BulkInserter) Results() []BulkResult {
+	return b.results
+}
+
+// Close sends the current batch, if it has any rows, and releases the
+// prepared statement. It returns the error of the last batch.
+func (b *BulkInserter) Close(ctx context.Context) error {
+	err := b.Flush(ctx)
+	if b.stmt != nil {
+		closeErr := b.stmt.Close()
+		if err == nil {
+			err = closeErr
+		}
+		b.stmt = nil
+	}
+	return err
+}
+
+// insertPrefix returns the start of the INSERT statement, up to its VALUES.
+func (b *BulkInserter) insertPrefix() string {
+	return "INSERT INTO " + quoteTableName(b.table) + " (" + quoteColumnNames(b.columns) + ") VALUES "
+}
+
+// sendInsert sends the current batch as a multi-row INSERT, reusing the last
+// prepared statement if it inserts the same number of rows.
+func (b *BulkInserter) sendInsert(ctx context.Context) BulkResult {
+	result := BulkResult{Rows: len(b.rows)}
+
+	if b.stmt == nil || b.stmtRows != len(b.rows) {
+		if b.stmt != nil {
+			b.stmt.Close()
+			b.stmt = nil
+		}
+
+		group := "(" + strings.Repeat("?,", len(b.columns)-1) + "?)"
+		query := b.insertPrefix() + strings.Repeat(group+",", len(b.rows)-1) + group
+
+		b.stmt, result.Err = b.conn.PrepareContext(ctx, query)
+		if result.Err != nil {
+			return result
+		}
+		b.stmtRows = len(b.rows)
+	}
+
+	args := make([]any, 0, len(b.rows)*len(b.columns))
+	for _, row := range b.rows {
+		for _, v := range row {
+			args = append(args, v)
+		}
+	}
+
+	res, err := b.stmt.ExecContext(ctx, args...)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.RowsAffected, result.Err = res.RowsAffected()
+	return result
+}
+
+// bulkReaderID numbers the reader handlers registered by sendLoadData.
+var bulkReaderID int64
+
+// sendLoadData sends the current batch as a CSV file, through a reader handler
+// registered for the duration of the LOAD DATA LOCAL INFILE statement.
+func (b *BulkInserter) sendLoadData(ctx context.Context) BulkResult {
+	result := BulkResult{Rows: len(b.rows)}
+
+	var buf bytes.Buffer
+	buf.Grow(b.size)
+	for _, row := range b.rows {
+		line := buf.AvailableBuffer()
+		for i, v := range row {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line, result.Err = b.appendCSVValue(line, v)
+			if result.Err != nil {
+				return result
+			}
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	name := "gms-bulk-" + strconv.FormatInt(atomic.AddInt64(&bulkReaderID, 1), 10)
+	RegisterReaderHandler(name, func() io.Reader { return bytes.NewReader(buf.Bytes()) })
+	defer DeregisterReaderHandler(name)
+
+	query := "LOAD DATA LOCAL INFILE '" + readerHandlerPrefix + name + "' INTO TABLE " + quoteTableName(b.table) +
+		` CHARACTER SET binary FIELDS TERMINATED BY ',' ENCLOSED BY '"' ESCAPED BY '\\' LINES TERMINATED BY '\n'` +
+		" (" + quoteColumnNames(b.columns) + ")"
+	if b.noBackslashEscapes {
+		query = strings.Replace(query, `ESCAPED BY '\\'`, `ESCAPED BY '\'`, 1)
+	}
+
+	res, err := b.conn.ExecContext(ctx, query)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.RowsAffected, result.Err = res.RowsAffected()
+	return result
+}
+
+// appendCSVValue appends v to buf as a field of the CSV files that
+// sendLoadData sends.
+func (b *BulkInserter) appendCSVValue(buf []byte, v drv.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, `\N`...), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("can't load %v, MySQL has no such value", v)
+		}
+		return strconv.AppendFloat(buf, v, 'e', -1, 64), nil
+	case bool:
+		if v {
+			return append(buf, '1'), nil
+		}
+		return append(buf, '0'), nil
+	case []byte:
+		buf = append(buf, '"')
+		buf = appendEscaped(buf, string(v), false)
+		return append(buf, '"'), nil
+	case string:
+		buf = append(buf, '"')
+		buf = appendEscaped(buf, v, false)
+		return append(buf, '"'), nil
+	case time.Time:
+		return v.AppendFormat(buf, "2006-01-02 15:04:05.999999"), nil
+	}
+
+	return nil, fmt.Errorf("Can't convert type: %T", v)
+}
+
+// quoteTableName quotes a table name, which may be qualified with the name of
+// its database.
+func quoteTableName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteColumnNames quotes each of names, and joins them with commas.
+func quoteColumnNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdentifier(name)
+	}
+	return strings.Join(quoted, ",")
+}
+
+// quoteIdentifier quotes name with backticks.
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
@@ -0,0 +1,142 @@
+package gms
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+// newBulkTestServer starts a server that answers every statement with an OK
+// packet, reporting a row affected for each parenthesized group of
+// placeholders in it.
+func newBulkTestServer(t *testing.T) *gmstest.Server {
+	s := newTestServer(t)
+	s.HandleDefault(func(query string, args []any) gmstest.Result {
+		return gmstest.Result{AffectedRows: uint64(strings.Count(query, "(?"))}
+	})
+	return s
+}
+
+func TestBulkInsert(t *testing.T) {
+	s := newBulkTestServer(t)
+	sqlConn := openTestConn(t, s.Addr, nil)
+	ctx := context.Background()
+	s.Statements()
+
+	b, err := NewBulkInserter(ctx, sqlConn, "db.events", []string{"id", "name"}, BulkOptions{MaxBatchRows: 2})
+	if err != nil {
+		t.Fatalf("NewBulkInserter error: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		err = b.Add(ctx, i, "event")
+		if err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+	}
+	err = b.Close(ctx)
+	if err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	var got []string
+	for _, st := range s.Statements() {
+		if !st.Prepared {
+			t.Errorf("statement %q wasn't prepared", st.Query)
+		}
+		got = append(got, st.Query)
+	}
+	want := []string{
+		"INSERT INTO `db`.`events` (`id`,`name`) VALUES (?,?),(?,?)",
+		"INSERT INTO `db`.`events` (`id`,`name`) VALUES (?,?),(?,?)",
+		"INSERT INTO `db`.`events` (`id`,`name`) VALUES (?,?)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+
+	wantResults := []BulkResult{{Rows: 2, RowsAffected: 2}, {Rows: 2, RowsAffected: 2}, {Rows: 1, RowsAffected: 1}}
+	if got := b.Results(); !reflect.DeepEqual(got, wantResults) {
+		t.Errorf("Results() = %+v, want %+v", got, wantResults)
+	}
+}
+
+func TestBulkInsertBatchBytes(t *testing.T) {
+	s := newBulkTestServer(t)
+	sqlConn := openTestConn(t, s.Addr, nil)
+	ctx := context.Background()
+
+	b, err := NewBulkInserter(ctx, sqlConn, "t", []string{"a"}, BulkOptions{MaxBatchBytes: 100})
+	if err != nil {
+		t.Fatalf("NewBulkInserter error: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		err = b.Add(ctx, int64(i))
+		if err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+		if size := b.batchSize(len(b.rows), b.size); size > 100 && len(b.rows) > 1 {
+			t.Fatalf("batch of %d rows takes %d bytes, more than 100", len(b.rows), size)
+		}
+	}
+	err = b.Close(ctx)
+	if err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	total := 0
+	for _, result := range b.Results() {
+		total += result.Rows
+	}
+	if total != 20 || len(b.Results()) < 2 {
+		t.Errorf("Results() = %+v, want 20 rows in several batches", b.Results())
+	}
+}
+
+func TestBulkLoadData(t *testing.T) {
+	s := newBulkTestServer(t)
+	ctx := context.Background()
+
+	_, err := NewBulkInserter(ctx, openTestConn(t, s.Addr, nil), "t", []string{"a"}, BulkOptions{Mode: BulkLoadData})
+	if err == nil {
+		t.Errorf("NewBulkInserter succeeded with BulkLoadData on a connection without AllowLocalInfile")
+	}
+
+	sqlConn := openTestConn(t, s.Addr, func(cfg *Config) { cfg.AllowLocalInfile = true })
+	b, err := NewBulkInserter(ctx, sqlConn, "t", []string{"id", "name", "at", "note"}, BulkOptions{Mode: BulkLoadData})
+	if err != nil {
+		t.Fatalf("NewBulkInserter error: %v", err)
+	}
+
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	err = b.Add(ctx, 1, `say "hi"`, at, nil)
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	err = b.Add(ctx, 2, "a,b\\c", at, []byte("x"))
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	s.Statements()
+
+	err = b.Close(ctx)
+	if err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	statements := s.Statements()
+	wantQuery := "LOAD DATA LOCAL INFILE 'Reader::gms-bulk-"
+	if len(statements) != 1 || !strings.HasPrefix(statements[0].Query, wantQuery) {
+		t.Fatalf("statements = %+v, want a LOAD DATA LOCAL INFILE from a reader", statements)
+	}
+
+	want := "1,\"say \\\"hi\\\"\",2024-03-01 12:30:00,\\N\n2,\"a,b\\\\c\",2024-03-01 12:30:00,\"x\"\n"
+	if got := string(statements[0].Infile); got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,327 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Indicator may be given as a parameter value to ExecBulk and ExecBulkUnits,
+// in place of an actual value, to tell the server what to do with the
+// parameter.
+type Indicator byte
+
+const (
+	// IndicatorNull sends NULL, the same as a nil value.
+	IndicatorNull Indicator = 1
+
+	// IndicatorDefault sends the default value of the column that the
+	// parameter is inserted into.
+	IndicatorDefault Indicator = 2
+
+	// IndicatorIgnore leaves the column that the parameter is assigned to
+	// unchanged, in an UPDATE, or sets it to its default value, in an
+	// INSERT.
+	IndicatorIgnore Indicator = 3
+)
+
+var (
+	errBulkUnsupported            = errors.New("server does not support COM_STMT_BULK_EXECUTE")
+	errBulkUnitResultsUnsupported = errors.New("server does not support unit results for COM_STMT_BULK_EXECUTE")
+)
+
+// ExecBulk executes the statement once for each row of parameters, all in a
+// single COM_STMT_BULK_EXECUTE, and returns the total number of rows affected.
+// The last insert id is the one generated for the first row. Only MariaDB
+// 10.2 and later support this, and all the rows must fit in one packet.
+//
+// The values in each column must all be of the same type, apart from nil, and
+// Indicator values. The statement is reached through sql.Conn.Raw:
+//
+//	err := sqlConn.Raw(func(driverConn any) error {
+//		s, err := driverConn.(driver.Conn).Prepare("INSERT INTO t VALUES (?, ?)")
+//		...
+//		defer s.Close()
+//		result, err := s.(interface {
+//			ExecBulk(context.Context, [][]any) (driver.Result, error)
+//		}).ExecBulk(ctx, rows)
+//		...
+//	})
+func (s *stmt) ExecBulk(ctx context.Context, rows [][]any) (drv.Result, error) {
+	c := s.c
+	h := c.startHook(s.sqlStr, bulkArgs(rows))
+	c.startQueryLog(s.sqlStr, bulkArgs(rows))
+
+	err := s.sendBulk(ctx, rows, false)
+	if err != nil {
+		c.finishExecLog(ctx, nil, err)
+		h.finishExec(ctx, nil, err)
+		return nil, err
+	}
+
+	result, err := c.readExecResult()
+	c.finishExecLog(ctx, result, err)
+	h.finishExec(ctx, result, err)
+	return result, err
+}
+
+// ExecBulkUnits is like ExecBulk, except that it returns the result of each
+// row separately. Only MariaDB 11.5 and later support this.
+func (s *stmt) ExecBulkUnits(ctx context.Context, rows [][]any) ([]drv.Result, error) {
+	if s.c.clientMariaDBFlags&mariadbFlagBulkUnitResults == 0 {
+		return nil, errBulkUnitResultsUnsupported
+	}
+
+	c := s.c
+	h := c.startHook(s.sqlStr, bulkArgs(rows))
+	c.startQueryLog(s.sqlStr, bulkArgs(rows))
+
+	err := s.sendBulk(ctx, rows, true)
+	if err != nil {
+		c.finishExecLog(ctx, nil, err)
+		h.finishExec(ctx, nil, err)
+		return nil, err
+	}
+
+	results, err := c.readBulkUnitResults()
+	c.finishExecLog(ctx, unitResults(results), err)
+	h.finishExec(ctx, unitResults(results), err)
+	return results, err
+}
+
+// bulkArgs returns the number of arguments in rows.
+func bulkArgs(rows [][]any) int {
+	n := 0
+	for _, row := range rows {
+		n += len(row)
+	}
+	return n
+}
+
+// unitResults is the result of all the rows of a bulk execution, added up.
+type unitResults []drv.Result
+
+func (u unitResults) LastInsertId() (int64, error) {
+	return 0, errors.New("no single last insert id for a bulk execution")
+}
+
+func (u unitResults) RowsAffected() (int64, error) {
+	var total int64
+	for _, result := range u {
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// sendBulk sends a COM_STMT_BULK_EXECUTE for the statement, with rows of
+// parameters.
+func (s *stmt) sendBulk(ctx context.Context, rows [][]any, unitResults bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c := s.c
+	if c.clientMariaDBFlags&mariadbFlagStmtBulkOperations == 0 {
+		return errBulkUnsupported
+	}
+	if len(rows) == 0 {
+		return errors.New("no rows to execute")
+	}
+
+	err := s.reprepare()
+	if err != nil {
+		return err
+	}
+
+	// First, we convert the values, work out the type of each column, and
+	// compute the size of the packet we will need.
+	numParams := len(s.inputFields)
+	types := make([]fieldType, numParams)
+	for i := range types {
+		types[i] = fieldTypeNULL
+	}
+
+	size := int64(1) + // command byte
+		4 + // statement id
+		2 + // flags
+		int64(numParams)*2 // types
+
+	values := make([][]drv.Value, len(rows))
+	for i, row := range rows {
+		if len(row) != numParams {
+			return errors.New("field count mismatch")
+		}
+
+		values[i] = make([]drv.Value, numParams)
+		for j, v := range row {
+			// Each value is preceded by its indicator.
+			size++
+
+			if indicator, ok := v.(Indicator); ok {
+				values[i][j] = indicator
+				continue
+			}
+
+			value, err := drv.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				return fmt.Errorf("row %d, parameter %d: %v", i, j, err)
+			}
+			values[i][j] = value
+			if value == nil {
+				continue
+			}
+
+			n, ftype, err := c.WriteObj(globalCountingWriter, value)
+			if err != nil {
+				return err
+			}
+			size += int64(n)
+
+			if types[j] == fieldTypeNULL {
+				types[j] = ftype
+			} else if types[j] != ftype {
+				return fmt.Errorf("row %d, parameter %d: a %T, unlike the values of earlier rows", i, j, value)
+			}
+		}
+	}
+
+	err = c.checkPacketSize(size)
+	if err != nil {
+		return err
+	}
+
+	c.resetSeq()
+
+	c.BeginPacket(size)
+
+	flags := bulkFlagSendTypes
+	if unitResults {
+		flags |= bulkFlagSendUnitResults
+	}
+
+	c.scratch[0] = comStmtBulkExecute
+	binary.LittleEndian.PutUint32(c.scratch[1:5], s.id)
+	binary.LittleEndian.PutUint16(c.scratch[5:7], flags)
+	_, err = c.Write(c.scratch[:7])
+	if err != nil {
+		return err
+	}
+
+	for _, ftype := range types {
+		c.scratch[0] = byte(ftype)
+		c.scratch[1] = 0
+		_, err = c.Write(c.scratch[:2])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, row := range values {
+		for _, value := range row {
+			switch v := value.(type) {
+			case Indicator:
+				c.scratch[0] = byte(v)
+			case nil:
+				c.scratch[0] = byte(IndicatorNull)
+			default:
+				c.scratch[0] = 0
+			}
+			_, err = c.Write(c.scratch[:1])
+			if err != nil {
+				return err
+			}
+
+			if c.scratch[0] != 0 {
+				continue
+			}
+
+			_, _, err = c.WriteObj(c, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = c.EndPacket(FLUSH)
+	if err != nil {
+		return err
+	}
+
+	c.querySent()
+	return nil
+}
+
+// readBulkUnitResults reads the response to a COM_STMT_BULK_EXECUTE that asked
+// for unit results, which is a result set with the id generated for, and the
+// number of rows affected by, each row of parameters.
+func (c *conn) readBulkUnitResults() ([]drv.Result, error) {
+	err := c.AdvancePacket()
+	if err != nil {
+		return nil, err
+	}
+
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	if c.scratch[0] == 0xff {
+		return nil, c.ErrorFromErrPacket()
+	} else if c.scratch[0] == 0x00 {
+		return nil, errors.New("server sent no unit results for COM_STMT_BULK_EXECUTE")
+	}
+
+	numColumns, _, err := c.readColumnCount()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]outputFieldData, numColumns)
+	idIdx, affectedIdx := -1, -1
+	for i := range fields {
+		err = c.ReadFieldDefinition(&fields[i].field)
+		if err != nil {
+			return nil, err
+		}
+
+		switch strings.ToLower(fields[i].name) {
+		case "id":
+			idIdx = i
+		case "affected_rows":
+			affectedIdx = i
+		}
+	}
+	if idIdx < 0 || affectedIdx < 0 {
+		return nil, fmt.Errorf("unexpected columns in unit results: %q", (&resultIter{fields: fields}).Columns())
+	}
+
+	err = c.readEndOfDefinitions()
+	if err != nil {
+		return nil, err
+	}
+
+	iter := &resultIter{c: c, fields: fields}
+	dest := make([]drv.Value, numColumns)
+
+	var ret []drv.Result
+	for {
+		err = iter.Next(dest)
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		id, _ := dest[idIdx].(int64)
+		affected, _ := dest[affectedIdx].(int64)
+		ret = append(ret, results{affectedRows: affected, lastInsertId: id})
+	}
+}
@@ -0,0 +1,117 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+// bulkExecer is the interface of the statements that support
+// COM_STMT_BULK_EXECUTE.
+type bulkExecer interface {
+	ExecBulk(context.Context, [][]any) (drv.Result, error)
+	ExecBulkUnits(context.Context, [][]any) ([]drv.Result, error)
+}
+
+// withBulkStmt prepares query on a connection to s, and calls f with it.
+func withBulkStmt(t *testing.T, s *gmstest.Server, query string, f func(bulkExecer)) {
+	sqlConn := openTestConn(t, s.Addr, nil)
+
+	err := sqlConn.Raw(func(driverConn any) error {
+		s, err := driverConn.(drv.Conn).Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+
+		f(s.(bulkExecer))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Raw error: %v", err)
+	}
+}
+
+func TestExecBulk(t *testing.T) {
+	s := gmstest.NewUnstartedServer()
+	s.MariaDB = true
+	s.Version = "5.5.5-11.5.2-MariaDB"
+	startTestServer(t, s)
+	ctx := context.Background()
+
+	// Each row affects one row, and is given the id of its first parameter
+	// plus 99.
+	s.Handle("INSERT INTO t VALUES (?, ?)", func(args []any) gmstest.Result {
+		if args == nil {
+			return gmstest.Result{}
+		}
+		return gmstest.Result{AffectedRows: 1, LastInsertID: uint64(99 + args[0].(int64))}
+	})
+
+	rows := [][]any{
+		{1, "a"},
+		{2, nil},
+		{3, IndicatorDefault},
+		{4, IndicatorIgnore},
+	}
+
+	withBulkStmt(t, s, "INSERT INTO t VALUES (?, ?)", func(st bulkExecer) {
+		s.Statements()
+
+		result, err := st.ExecBulk(ctx, rows)
+		if err != nil {
+			t.Fatalf("ExecBulk error: %v", err)
+		}
+		if n, _ := result.RowsAffected(); n != 4 {
+			t.Errorf("RowsAffected() = %d, want 4", n)
+		}
+		if id, _ := result.LastInsertId(); id != 100 {
+			t.Errorf("LastInsertId() = %d, want 100", id)
+		}
+
+		var got [][]any
+		for _, st := range s.Statements() {
+			got = append(got, st.Args)
+		}
+		want := [][]any{{int64(1), "a"}, {int64(2), nil}, {int64(3), gmstest.Default}, {int64(4), gmstest.Ignore}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("rows = %v, want %v", got, want)
+		}
+
+		units, err := st.ExecBulkUnits(ctx, rows[:2])
+		if err != nil {
+			t.Fatalf("ExecBulkUnits error: %v", err)
+		}
+		if len(units) != 2 {
+			t.Fatalf("ExecBulkUnits returned %d results, want 2", len(units))
+		}
+		for i, unit := range units {
+			n, _ := unit.RowsAffected()
+			id, _ := unit.LastInsertId()
+			if n != 1 || id != int64(100+i) {
+				t.Errorf("result %d = (%d rows, id %d), want (1 row, id %d)", i, n, id, 100+i)
+			}
+		}
+
+		_, err = st.ExecBulk(ctx, [][]any{{1, "a"}, {"b", "c"}})
+		if err == nil {
+			t.Errorf("ExecBulk succeeded with values of different types in a column")
+		}
+	})
+}
+
+func TestExecBulkUnsupported(t *testing.T) {
+	s := newTestServer(t)
+	s.AddResult("INSERT INTO t VALUES (?)", gmstest.Result{AffectedRows: 1})
+
+	withBulkStmt(t, s, "INSERT INTO t VALUES (?)", func(st bulkExecer) {
+		_, err := st.ExecBulk(context.Background(), [][]any{{1}})
+		if !errors.Is(err, errBulkUnsupported) {
+			t.Errorf("ExecBulk error = %v, want %v", err, errBulkUnsupported)
+		}
+	})
+}
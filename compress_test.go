@@ -0,0 +1,79 @@
+package gms
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+func TestCompressedSession(t *testing.T) {
+	algorithms := []string{"zlib"}
+	if wire.ZstdAvailable {
+		algorithms = append(algorithms, "zstd")
+	}
+
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			s := gmstest.NewUnstartedServer()
+			s.Compress = true
+			startTestServer(t, s)
+
+			// The rows are large enough to be compressed, and the statement
+			// is too.
+			value := strings.Repeat("compressible ", 1000)
+			s.Handle("SELECT ?", func(args []any) gmstest.Result {
+				return gmstest.Result{
+					Columns: []gmstest.Column{{Name: "value"}},
+					Rows:    [][]any{args, args},
+				}
+			})
+			s.AddResult("INSERT INTO t VALUES ('"+value+"')", gmstest.Result{AffectedRows: 1})
+
+			sqlConn := openTestConn(t, s.Addr, func(cfg *Config) {
+				cfg.Compress = algorithm
+			})
+
+			_, err := sqlConn.ExecContext(context.Background(), "INSERT INTO t VALUES ('"+value+"')")
+			if err != nil {
+				t.Fatalf("Exec error: %v", err)
+			}
+
+			rows, err := sqlConn.QueryContext(context.Background(), "SELECT ?", value)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			n := 0
+			for rows.Next() {
+				var got string
+				err = rows.Scan(&got)
+				if err != nil {
+					t.Fatalf("Scan error: %v", err)
+				}
+				if got != value {
+					t.Errorf("got a value of %d bytes, want the %d sent", len(got), len(value))
+				}
+				n++
+			}
+			if err := rows.Err(); err != nil {
+				t.Errorf("iteration error: %v", err)
+			}
+			rows.Close()
+			if n != 2 {
+				t.Errorf("got %d rows, want 2", n)
+			}
+
+			err = sqlConn.Raw(func(driverConn any) error {
+				if driverConn.(*conn).compress == nil {
+					t.Errorf("the compressed protocol is not in use")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Raw error: %v", err)
+			}
+		})
+	}
+}
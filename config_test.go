@@ -0,0 +1,135 @@
+package gms
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want Config
+	}{
+		{
+			"tcp://root:@localhost:3306?db=test&timeout=1s",
+			Config{Net: "tcp", Addr: "localhost:3306", User: "root", DBName: "test", Timeout: time.Second, Loc: time.UTC, HostStrategy: HostSequential},
+		},
+		{
+			"tcp://us%40er:p%40ss:word@db.example.com?readTimeout=2s&writeTimeout=3s&maxAllowedPacket=1024&interpolateParams=true",
+			Config{Net: "tcp", Addr: "db.example.com:3306", User: "us@er", Passwd: "p@ss:word", ReadTimeout: 2 * time.Second, WriteTimeout: 3 * time.Second, MaxAllowedPacket: 1024, InterpolateParams: true, Loc: time.UTC, HostStrategy: HostSequential},
+		},
+		{
+			"tcp://localhost?var.time_zone=%27%2B00:00%27&var.sql_mode=%27ANSI%27&initSQL=SET+NAMES+utf8mb4&initSQL=DO+1&resetSession=true",
+			Config{Net: "tcp", Addr: "localhost:3306", Loc: time.UTC, HostStrategy: HostSequential, Params: map[string]string{"time_zone": "'+00:00'", "sql_mode": "'ANSI'"}, InitSQL: []string{"SET NAMES utf8mb4", "DO 1"}, ResetSession: true},
+		},
+		{
+			"tcp://localhost?charset=latin1&collation=latin1_bin",
+			Config{Net: "tcp", Addr: "localhost:3306", Loc: time.UTC, HostStrategy: HostSequential, Charset: "latin1", Collation: "latin1_bin"},
+		},
+		{
+			"tcp://h1:3307,h2,h3:3308?hostStrategy=roundrobin&hostBackoff=5s&requirePrimary=true",
+			Config{Net: "tcp", Addr: "h1:3307,h2:3306,h3:3308", Loc: time.UTC, HostStrategy: HostRoundRobin, HostBackoff: 5 * time.Second, RequirePrimary: true},
+		},
+		{
+			"tcp://localhost?compress=zstd&compressLevel=3&compressMinSize=128",
+			Config{Net: "tcp", Addr: "localhost:3306", Loc: time.UTC, HostStrategy: HostSequential, Compress: "zstd", CompressLevel: 3, CompressMinSize: 128},
+		},
+		{
+			"tcp://localhost?attr.program_name=billing&attr.team=payments&allowLocalInfile=true",
+			Config{Net: "tcp", Addr: "localhost:3306", Loc: time.UTC, HostStrategy: HostSequential, AllowLocalInfile: true, ConnectAttrs: map[string]string{"program_name": "billing", "team": "payments"}},
+		},
+		{
+			"tcp://localhost?slowQueryThreshold=250ms&querySampleRate=0.01",
+			Config{Net: "tcp", Addr: "localhost:3306", Loc: time.UTC, HostStrategy: HostSequential, SlowQueryThreshold: 250 * time.Millisecond, QuerySampleRate: 0.01},
+		},
+		{
+			"unix://root@/var/run/mysqld/mysqld.sock?tls=false",
+			Config{Net: "unix", Addr: "/var/run/mysqld/mysqld.sock", User: "root", TLSName: "false", Loc: time.UTC, HostStrategy: HostSequential},
+		},
+	}
+
+	for _, test := range tests {
+		cfg, err := ParseDSN(test.dsn)
+		if err != nil {
+			t.Errorf("ParseDSN(%q) error: %v", test.dsn, err)
+			continue
+		}
+		if !reflect.DeepEqual(*cfg, test.want) {
+			t.Errorf("ParseDSN(%q) = %+v, want %+v", test.dsn, *cfg, test.want)
+		}
+
+		cfg2, err := ParseDSN(cfg.FormatDSN())
+		if err != nil {
+			t.Errorf("ParseDSN(%q) error: %v", cfg.FormatDSN(), err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg2, cfg) {
+			t.Errorf("ParseDSN(%q) = %+v, want %+v", cfg.FormatDSN(), *cfg2, *cfg)
+		}
+	}
+}
+
+func TestParseDSNErrors(t *testing.T) {
+	tests := []string{
+		"udp://localhost:3306",
+		"tcp://localhost:3306?dbname=test",
+		"tcp://localhost:3306?timeout=soon",
+		"tcp://localhost:3306?db=a&db=b",
+		"tcp://localhost:3306?tls=unregistered",
+		"tcp://localhost:3306/test",
+		"unix://root@",
+		"tcp://localhost:3306?var.time%20zone=1",
+		"tcp://localhost:3306?var.time_zone=",
+		"tcp://localhost:3306?charset=klingon",
+		"tcp://h1,,h2",
+		"tcp://localhost:3306?hostStrategy=fastest",
+		"tcp://localhost:3306?collation=klingon_ci",
+		"tcp://localhost:3306?charset=latin1&collation=utf8mb4_bin",
+		"tcp://localhost:3306?compress=lz4",
+		"tcp://localhost:3306?attr.=x",
+		"tcp://localhost:3306?querySampleRate=1.5",
+		"tcp://localhost:3306?slowQueryThreshold=-1s",
+	}
+
+	for _, dsn := range tests {
+		if cfg, err := ParseDSN(dsn); err == nil {
+			t.Errorf("ParseDSN(%q) = %+v, want error", dsn, *cfg)
+		}
+	}
+
+	_, err := ParseDSN("tcp://localhost:3306?dbname=test")
+	if _, ok := err.(*UnknownParamError); !ok {
+		t.Errorf("ParseDSN with unknown parameter: got error %v, want *UnknownParamError", err)
+	}
+}
+
+func TestConfigCollation(t *testing.T) {
+	tests := []struct {
+		charset, collation string
+		wantName           string
+		wantID             uint16
+	}{
+		{"", "", "utf8mb4_general_ci", 45},
+		{"latin1", "", "latin1_swedish_ci", 8},
+		{"", "utf8mb4_0900_ai_ci", "utf8mb4_0900_ai_ci", 255},
+		{"utf8mb4", "utf8mb4_ja_0900_as_cs", "utf8mb4_ja_0900_as_cs", 303},
+		{"utf8mb3", "utf8_bin", "utf8_bin", 83},
+		{"binary", "", "binary", 63},
+	}
+
+	for _, test := range tests {
+		cfg := NewConfig()
+		cfg.Charset = test.charset
+		cfg.Collation = test.collation
+
+		name, id, err := cfg.collation()
+		if err != nil {
+			t.Errorf("collation for (%q, %q) error: %v", test.charset, test.collation, err)
+			continue
+		}
+		if name != test.wantName || id != test.wantID {
+			t.Errorf("collation for (%q, %q) = (%q, %d), want (%q, %d)", test.charset, test.collation, name, id, test.wantName, test.wantID)
+		}
+	}
+}
@@ -3,39 +3,44 @@ package gms
 import (
 	"bufio"
 	"bytes"
-	"crypto/sha1"
+	"context"
+	"crypto/tls"
+	"database/sql"
 	drv "database/sql/driver"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/balasanjay/gms/internal/wire"
 )
 
 type conn struct {
+	// The settings this connection was opened with.
+	cfg *Config
+
 	// Original connection.
 	rwc io.ReadWriteCloser
 
-	// Buffered writer, wrapping rwc.
-	bw *bufio.Writer
-
-	// The size of the packet being written to bw. Call BeginPacket to set
-	// this size.
-	curPacketSizeRemaining int64
-	writeCap               int64
+	// If non-nil, the compressed protocol is in use, and bw and br wrap this
+	// instead of rwc.
+	compress *wire.CompressConn
 
-	// Buffered reader, wrapping rwc.
+	// Buffered writer and reader, wrapping rwc.
+	bw *bufio.Writer
 	br *bufio.Reader
 
-	// Limited reader, for reading packets.
-	lr io.LimitedReader
-
-	// If true, then the next packet should be transparently merged into this
-	// one.
-	mergeNextPacket bool
+	// Splits the data written into packets, and merges the packets read, on
+	// top of bw and br.
+	framer *framer
 
-	// The maximum size of a packet received from (sent to) the server.
-	maxRecvPacketSize uint32
-	maxSendPacketSize uint32
+	// The largest packet that the server accepts, from the Config, or else
+	// from the server's max_allowed_packet. Zero means no limit.
+	maxAllowedPacket int64
 
 	// A scratch space buffer
 	reuseBuf *bytes.Buffer
@@ -43,17 +48,55 @@ type conn struct {
 	// Capabilities flags for this connection
 	serverFlags connectionFlag
 
-	// The charset sent by the server during the initial handshake
+	// The capabilities we asked for in the handshake, out of serverFlags.
+	clientFlags connectionFlag
+
+	// The MariaDB extended capabilities that the server supports, and those
+	// we asked for.
+	serverMariaDBFlags mariadbFlag
+	clientMariaDBFlags mariadbFlag
+
+	// What the server told us about itself in its greeting.
+	serverInfo ServerInfo
+
+	// The collation id sent to the server in the handshake response, which
+	// determines the connection's character set.
 	charset byte
 
-	// The current sequence id.
-	seqId uint8
+	// The character set that the server decodes queries in, if session
+	// tracking has reported a change to it since the handshake.
+	clientCharset string
+
+	// If true, a statement may have changed the character set, without
+	// session tracking to report what it was changed to.
+	charsetChanged bool
+
+	// The server status flags, and the number of warnings, as of the last OK
+	// or EOF packet.
+	status   serverStatus
+	warnings uint16
+
+	// The session state changes reported by the last OK packet, and the last
+	// GTIDs reported by any OK packet, when CLIENT_SESSION_TRACK is in use.
+	session  SessionState
+	lastGTID string
+
+	// The query being timed for the Config's slow query log, if any.
+	qlog *queryLog
+
+	// If true, a failed write left the connection in an unknown state, and
+	// it must not be used again.
+	bad bool
+
+	// The number of times the session has been reset with
+	// COM_RESET_CONNECTION, which frees the statements prepared before.
+	resets int
 
 	// Temporary writing area for many functions to avoid allocating.
 	scratch [512]byte
 }
 
-func newConn(rwc io.ReadWriteCloser) *conn {
+func newConn(rwc io.ReadWriteCloser, cfg *Config) *conn {
 	// TODO(sanjay): tune these
 	const (
 		defaultWriteBufSize = 16384
@@ -62,396 +105,651 @@ func newConn(rwc io.ReadWriteCloser) *conn {
 
 	c := &conn{}
 
+	c.cfg = cfg
 	c.rwc = rwc
 
 	c.bw = bufio.NewWriterSize(c.rwc, defaultWriteBufSize)
-
-	c.curPacketSizeRemaining = 0
-	c.writeCap = 0
-
 	c.br = bufio.NewReaderSize(c.rwc, defaultReadBufSize)
+	c.framer = newFramer(c.br, c.bw)
+	if cfg.Tracer != nil {
+		c.framer.trace = newPacketTrace(cfg.Tracer)
+	}
 
-	c.lr.N = 0
-	c.lr.R = c.br
-
-	c.mergeNextPacket = false
-
-	// This are only the initial values, the starting handshake might update it.
-	c.maxRecvPacketSize = (1 << 24) - 1
-	c.maxSendPacketSize = (1 << 24) - 1
+	c.maxAllowedPacket = int64(cfg.MaxAllowedPacket)
 
 	c.reuseBuf = bytes.NewBuffer(nil)
 
 	return c
 }
 
+// AdvancePacket discards the rest of the current packet, and starts reading the
+// next one.
 func (c *conn) AdvancePacket() error {
-	err := c.AdvanceToEOF()
-	if err != nil {
-		return err
-	}
-
-	err = c.readPacketHeader()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return c.framer.NextPacket()
 }
 
+// AdvanceToEOF discards the rest of the current packet.
 func (c *conn) AdvanceToEOF() error {
-	return c.CopyN(globalCountingWriter, c, c.lr.N)
+	return c.framer.Discard()
 }
 
+// Read reads from the current packet, and returns io.EOF at its end.
 func (c *conn) Read(buf []byte) (int, error) {
-	// No data remaining on this packet, and we don't have to merge the next
-	// packet.
-	if c.lr.N <= 0 && !c.mergeNextPacket {
-		return 0, io.EOF
-	}
-
-	// No data remaining on this packet, and we have to merge the next packet.
-	// So we merge the next packet header, and carry on.
-	if c.lr.N <= 0 && c.mergeNextPacket {
-		// readPacketHeader guarantees that the next packet has size greater
-		// than 0, so we can safely fall-through to our regular reading code.
-		err := c.readPacketHeader()
-		if err != nil {
-			return 0, err
-		}
-	}
+	return c.framer.Read(buf)
+}
+
+// PacketTooLargeError is returned when a statement would need a packet larger
+// than the server accepts. Nothing is sent to the server, so the connection
+// can still be used.
+type PacketTooLargeError struct {
+	Size, Max int64
+}
+
+func (e *PacketTooLargeError) Error() string {
+	return fmt.Sprintf("packet of %d bytes exceeds max_allowed_packet of %d bytes", e.Size, e.Max)
+}
 
-	// Some data remaining on current packet
-	n, err := c.lr.Read(buf)
-	if err == io.EOF && c.lr.N > 0 {
-		return n, io.ErrUnexpectedEOF
-	} else if err == io.EOF && c.mergeNextPacket {
-		return n, nil
+// checkPacketSize returns an error if a packet of size bytes is larger than the
+// server accepts.
+func (c *conn) checkPacketSize(size int64) error {
+	if c.maxAllowedPacket > 0 && size > c.maxAllowedPacket {
+		return &PacketTooLargeError{Size: size, Max: c.maxAllowedPacket}
 	}
-	return n, err
+	return nil
 }
 
 // BeginPacket sets up the conn to write a packet with size bytes.
 func (c *conn) BeginPacket(size int64) {
-	if c.curPacketSizeRemaining != 0 || size == 0 || c.writeCap != 0 {
-		panic(fmt.Sprintf("internal error, miscalculated packet size, still %v bytes on previous packet", c.curPacketSizeRemaining))
-	}
-
-	c.curPacketSizeRemaining = size
+	c.framer.BeginPacket(size)
 }
 
 func (c *conn) EndPacket(flush flushPolicy) error {
-	if c.curPacketSizeRemaining != 0 || c.writeCap != 0 {
-		panic(fmt.Sprintf("internal error, miscalculated packet size, still %v bytes on previous packet", c.curPacketSizeRemaining))
+	err := c.framer.EndPacket(flush)
+	if err != nil || !flush {
+		return err
 	}
 
-	if !flush {
-		return nil
+	// The server numbers its reply starting from the sequence id of the
+	// compressed packets we sent, rather than of the packets inside them.
+	if c.compress != nil {
+		c.framer.nextExpectedSeq = c.compress.Seq
 	}
-	return c.bw.Flush()
+	return nil
 }
 
+// Write writes to the current packet, which must have room for b.
 func (c *conn) Write(b []byte) (int, error) {
-	if int64(len(b)) > c.curPacketSizeRemaining {
-		panic("internal error, write larger than calculated packet size")
-	}
+	return c.framer.Write(b)
+}
 
-	var buf [4]byte
-	written := 0
+func (c *conn) handshake() error {
+	defer c.framer.trace.endHandshake()
 
-	for len(b) > 0 {
-		if c.writeCap == 0 {
-			newWriteCap := int64(c.maxSendPacketSize)
-			if c.curPacketSizeRemaining < newWriteCap {
-				newWriteCap = c.curPacketSizeRemaining
-			}
+	challenge, err := c.readGreeting()
+	if err != nil {
+		return fmt.Errorf("reading server greeting: %w", err)
+	}
 
-			buf[0] = byte(newWriteCap)
-			buf[1] = byte(newWriteCap >> 8)
-			buf[2] = byte(newWriteCap >> 16)
-			buf[3] = c.seqId
-			c.seqId++
+	var (
+		username = c.cfg.User
+		password = c.cfg.Passwd
+		db       = c.cfg.DBName
+	)
 
-			n, err := c.bw.Write(buf[:4])
-			// fmt.Printf("Sent header with size=%v, seq=%v\n", newWriteCap, c.seqId-1)
+	// These are the capabilities this prototype supports
+	clientFlags := flagProtocol41 |
+		flagSecureConn |
+		flagLongPassword |
+		flagTransactions
 
-			if err != nil {
-				return written, err
-			} else if n != 4 {
-				err = io.ErrShortWrite
-				return written, err
-			}
+	if len(db) > 0 {
+		clientFlags |= flagConnectWithDB
+	}
 
-			c.writeCap = newWriteCap
-			continue
-		}
+	// Result sets end with an OK packet instead of an EOF packet, which
+	// carries more of the server's state.
+	if c.serverInfo.Supports(FeatureDeprecateEOF) {
+		clientFlags |= flagDeprecateEOF
+	}
 
-		nextSendSize := c.writeCap
-		if bsize := int64(len(b)); bsize < nextSendSize {
-			nextSendSize = bsize
-		}
+	// Query attributes can be sent with each statement.
+	if c.serverInfo.Supports(FeatureQueryAttributes) {
+		clientFlags |= flagQueryAttributes
+	}
 
-		n, err := c.bw.Write(b[:nextSendSize])
-		// fmt.Printf("Sent bytes=[%x]\n", b[:nextSendSize])
-		b = b[n:]
-		c.curPacketSizeRemaining -= int64(n)
-		c.writeCap -= int64(n)
-		written += n
+	// OK packets report changes to the session state, such as the GTIDs of
+	// committed transactions, if the server is set up to track them.
+	if c.serverInfo.Supports(FeatureSessionTrack) {
+		clientFlags |= flagSessionTrack
+	}
+
+	// The server may only ask for local files if we let it.
+	if c.cfg.AllowLocalInfile {
+		clientFlags |= flagLocalFiles
+	}
 
+	// Connection attributes are only sent if the server can record them.
+	var connectAttrs []byte
+	if c.serverFlags&flagConnectAttrs != 0 {
+		clientFlags |= flagConnectAttrs
+		connectAttrs, err = c.cfg.connectAttrs()
 		if err != nil {
-			return written, err
+			return err
 		}
 	}
 
-	return written, nil
-}
+	// Compression is only used if the server supports the algorithm we ask
+	// for, and zstd only in builds with the zstd build tag.
+	switch c.cfg.Compress {
+	case "zlib":
+		clientFlags |= c.serverFlags & flagCompress
+	case "zstd":
+		if wire.ZstdAvailable {
+			clientFlags |= c.serverFlags & flagZstdCompression
+		}
+	}
 
-// readPacket header reads the next 4 bytes from the connection, and configures
-// the readers in the connection correctly. Note that it should only be called
-// at packet boundaries, or at the beginning of a connection.
-func (c *conn) readPacketHeader() error {
-	var (
-		err       error
-		packetLen uint32
-		nextSeq   uint8
-		buf       [4]byte
-	)
+	// MariaDB's extended capabilities take the place of CLIENT_MYSQL, which
+	// we must not set if we ask for any of them.
+	clientMariaDBFlags := c.serverMariaDBFlags & (mariadbFlagStmtBulkOperations |
+		mariadbFlagBulkUnitResults |
+		mariadbFlagCacheMetadata |
+		mariadbFlagExtendedMetadata)
+	if clientMariaDBFlags != 0 {
+		clientFlags &^= flagLongPassword
+	}
 
-	err = readExactly(c.br, buf[:4])
+	// The handshake only has room for collation ids up to 255. For a larger
+	// id, we start with the character set's default collation, and switch
+	// with SET NAMES when initializing the session.
+	collationName, collationID, err := c.cfg.collation()
 	if err != nil {
 		return err
 	}
+	if collationID > 255 {
+		collationID = collations[defaultCollations[collationCharset(collationName)]]
+	}
+	c.charset = byte(collationID)
+
+	// The response starts with the capability flags, the maximum packet
+	// size, which we leave unspecified, the collation, and 23 reserved bytes,
+	// the last 4 of which are MariaDB's extended capability flags.
+	var prefix [32]byte
+	binary.LittleEndian.PutUint32(prefix[0:4], uint32(clientFlags))
+	prefix[8] = c.charset
+	binary.LittleEndian.PutUint32(prefix[28:32], uint32(clientMariaDBFlags))
+
+	if c.cfg.TLS != nil {
+		// The SSL request packet is the same as the first 32 bytes of the
+		// handshake response, with flagSSL set. After sending it, we upgrade
+		// the connection to TLS, and send the whole response over that.
+		if c.serverFlags&flagSSL == 0 {
+			return errors.New("server does not support TLS")
+		}
 
-	// Read packet length
-	packetLen = uint32(buf[0]) |
-		uint32(buf[1])<<8 |
-		uint32(buf[2])<<16
+		clientFlags |= flagSSL
+		binary.LittleEndian.PutUint32(prefix[0:4], uint32(clientFlags))
 
-	// Read sequence number
-	nextSeq = buf[3]
+		err = c.upgradeTLS(prefix[:])
+		if err != nil {
+			return err
+		}
+	}
 
-	// fmt.Printf("Read packet with sequence number %v\n", nextSeq)
+	c.clientFlags = clientFlags
+	c.clientMariaDBFlags = clientMariaDBFlags
 
-	if packetLen == 0 {
-		// BUG(sanjay): this is actually OK if we are merging two packets...
-		return errors.New("unexpected 0-length packet")
-	} else if nextSeq != c.seqId {
-		return fmt.Errorf("Expecting sequence id %v, got %v.", c.seqId, nextSeq)
+	var authResponse []byte
+	if len(password) > 0 {
+		if len(challenge) < 20 {
+			return errors.New("server sent too short an authentication challenge")
+		}
+		authResponse = wire.ScramblePassword(challenge, password)
 	}
 
-	c.seqId++
+	size := int64(len(prefix)) + int64(len(username)) + 1 + 1 + int64(len(authResponse))
+	if len(db) > 0 {
+		size += int64(len(db)) + 1
+	}
+	if clientFlags&flagConnectAttrs != 0 {
+		n, _ := c.WriteLengthEncodedInt(globalCountingWriter, uint64(len(connectAttrs)))
+		size += int64(n) + int64(len(connectAttrs))
+	}
+	if clientFlags&flagZstdCompression != 0 {
+		size++
+	}
 
-	c.lr.N = int64(packetLen)
-	c.mergeNextPacket = (packetLen >= c.maxRecvPacketSize)
-	return nil
-}
+	c.BeginPacket(size)
 
-var (
-	zero = bytes.Repeat([]byte{0}, 32)
-)
+	_, err = c.Write(prefix[:])
+	if err != nil {
+		return err
+	}
 
-func (c *conn) handshake(username, password, db string) error {
-	// TODO(sanjay): this currently buffers in memory. Switch to calculating
-	// size and streaming it instead.
+	err = c.writeNullTerminated(username)
+	if err != nil {
+		return err
+	}
 
-	err := c.AdvancePacket()
+	c.scratch[0] = byte(len(authResponse))
+	_, err = c.Write(c.scratch[:1])
 	if err != nil {
 		return err
 	}
 
-	c.reuseBuf.Reset()
+	_, err = c.Write(authResponse)
+	if err != nil {
+		return err
+	}
 
-	_, err = io.Copy(c.reuseBuf, c)
+	if len(db) > 0 {
+		err = c.writeNullTerminated(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if clientFlags&flagConnectAttrs != 0 {
+		_, err = c.WriteLengthEncodedInt(c, uint64(len(connectAttrs)))
+		if err != nil {
+			return err
+		}
+
+		_, err = c.Write(connectAttrs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if clientFlags&flagZstdCompression != 0 {
+		c.scratch[0] = byte(wire.ZstdLevel(c.cfg.CompressLevel))
+		_, err = c.Write(c.scratch[:1])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.EndPacket(FLUSH)
 	if err != nil {
-		panic(fmt.Errorf("io.Copy error: %v", err))
 		return err
 	}
 
-	buf := c.reuseBuf.Bytes()
+	err = c.AdvancePacket()
+	if err != nil {
+		return err
+	}
 
-	// First, we have the protocol version.
-	if buf[0] != 0xa {
-		return fmt.Errorf("Unexpected protocol version: %x", buf[0])
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return err
 	}
 
-	// Next, we have the server version as a NULL-terminated string. We simply
-	// skip this section.
-	afterVers := bytes.IndexByte(buf[1:], 0x0) + 1
-	buf = buf[afterVers:]
+	if c.scratch[0] == 0xff {
+		return c.ErrorFromErrPacket()
+	} else if c.scratch[0] != 0 {
+		// TODO(sanjay): support authentication switch requests, and
+		// plugins other than mysql_native_password.
+		return errors.New("auth failed")
+	}
 
-	// Next, we have the connection id as a uint32. We skip this section.
-	buf = buf[4:]
+	if clientFlags&(flagCompress|flagZstdCompression) != 0 {
+		return c.startCompression(clientFlags)
+	}
+	return nil
+}
 
-	var (
-		passwdChallenge [20]byte
-		passwdLen       = 0
-	)
+// writeNullTerminated writes s, followed by a NUL, to the current packet.
+func (c *conn) writeNullTerminated(s string) error {
+	_, err := io.WriteString(c, s)
+	if err != nil {
+		return err
+	}
 
-	// Next, we have the first 8 bytes of the password challenge data.
-	copy(passwdChallenge[:8], buf[:8])
-	buf = buf[8:]
-	passwdLen += 8
+	c.scratch[0] = 0
+	_, err = c.Write(c.scratch[:1])
+	return err
+}
 
-	// Next, we have a one-byte pad
-	buf = buf[1:]
+// startCompression switches the connection to the compressed protocol, with
+// the algorithm negotiated in clientFlags. It must be called right after the
+// handshake, before anything else has been sent or received.
+func (c *conn) startCompression(clientFlags connectionFlag) error {
+	// The rest of the OK packet is already buffered in br, which we are
+	// about to reset.
+	err := c.AdvanceToEOF()
+	if err != nil {
+		return err
+	}
 
-	// Next, we have the 2 byte capability flag in little-endian format.
-	serverFlag := connectionFlag(uint16(buf[0]) | uint16(buf[1])<<8)
-	if serverFlag&flagProtocol41 != flagProtocol41 {
-		return errors.New("Server does not support 4.1 wire protocol")
+	algorithm := "zlib"
+	if clientFlags&flagZstdCompression != 0 {
+		algorithm = "zstd"
 	}
-	c.serverFlags = serverFlag
 
-	if len(buf) > 0 {
-		// Read the character set, so we can echo it later
-		c.charset = buf[0]
-		buf = buf[1:]
+	c.compress, err = wire.NewCompressConn(c.rwc, algorithm, c.cfg.CompressLevel, c.cfg.CompressMinSize)
+	if err != nil {
+		return err
+	}
 
-		// Ignore the server status.
-		buf = buf[2:]
+	c.bw.Reset(c.compress)
+	c.br.Reset(c.compress)
+	return nil
+}
 
-		// TODO(sanjay): Disabled this for compatibility, revisit this issue?
-		// Read the other 2-byte capability flag
-		// c.serverFlags |= connectionFlag(uint16(buf[0])|uint16(buf[1])<<8) << 16
-		buf = buf[2:]
+// resetSeq resets the sequence ids at the start of a command.
+func (c *conn) resetSeq() {
+	c.framer.nextExpectedSeq = 0
+	if c.compress != nil {
+		c.compress.Seq = 0
+	}
+}
 
-		// Skip 1 byte that shows the length of auth-plugin-data. We do not
-		// support this feature.
-		buf = buf[1:]
+// upgradeTLS sends sslRequest, and then performs a TLS handshake over the
+// connection. All further reads and writes are encrypted.
+func (c *conn) upgradeTLS(sslRequest []byte) error {
+	nc, ok := c.rwc.(net.Conn)
+	if !ok {
+		return errors.New("TLS requires a net.Conn")
+	}
 
-		// Skip 10 reserved bytes
-		buf = buf[10:]
+	c.BeginPacket(int64(len(sslRequest)))
 
-		// TODO(sanjay): before we assume the password is here, should we be
-		// checking (serverFlags & flagSecureConnection)?
+	_, err := c.Write(sslRequest)
+	if err != nil {
+		return err
+	}
 
-		// Read 12 bytes of password challenge
-		// Next, we have the first 8 bytes of the password challenge data.
-		copy(passwdChallenge[8:], buf[:12])
-		buf = buf[12:]
-		passwdLen += 12
+	err = c.EndPacket(FLUSH)
+	if err != nil {
+		return err
 	}
 
-	// NOTE(sanjay): reuseBuf is an in-memory buffer, so we don't check write
-	// errors in this next section.
+	tlsConn := tls.Client(nc, c.cfg.TLS)
+	err = tlsConn.Handshake()
+	if err != nil {
+		return err
+	}
 
-	// Now that we've read the server's half of the handshake, let's write our
-	// half to the reuseBuf.
-	c.reuseBuf.Reset()
+	c.rwc = tlsConn
+	c.bw.Reset(tlsConn)
+	c.br.Reset(tlsConn)
+	return nil
+}
 
-	// These are the capabilities this prototype supports
-	clientFlags := flagProtocol41 |
-		flagSecureConn |
-		flagLongPassword |
-		flagTransactions
+// isReadOnly returns true if the server is read-only, that is, if either
+// @@read_only or @@super_read_only is set. Servers that don't have
+// @@super_read_only, such as MariaDB, only have @@read_only checked.
+func (c *conn) isReadOnly(ctx context.Context) (bool, error) {
+	rows, err := c.queryContext(ctx, "SELECT @@global.read_only, @@global.super_read_only", nil)
+	if serr, ok := err.(*serverError); ok && serr.errorCode == errCodeUnknownSystemVariable {
+		rows, err = c.queryContext(ctx, "SELECT @@global.read_only", nil)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
 
-	if len(db) > 0 {
-		clientFlags |= flagConnectWithDB
+	dest := make([]drv.Value, len(rows.Columns()))
+	err = rows.Next(dest)
+	if err != nil {
+		return false, err
 	}
 
-	binary.Write(c.reuseBuf, binary.LittleEndian, uint32(clientFlags))
-	binary.Write(c.reuseBuf, binary.LittleEndian, uint32(0))
-	binary.Write(c.reuseBuf, binary.LittleEndian, uint8(c.charset))
-	c.reuseBuf.Write(zero[0:23])
-	fmt.Fprintf(c.reuseBuf, "%s\x00", username)
+	readOnly := false
+	for _, v := range dest {
+		switch v := v.(type) {
+		case int64:
+			readOnly = readOnly || v != 0
+		case []byte:
+			readOnly = readOnly || string(v) == "1" || string(v) == "ON"
+		}
+	}
+	return readOnly, nil
+}
 
-	if len(password) > 0 {
-		// Do some password magic here
-		hash := sha1.New()
-		hash.Write([]byte(password))
-		hash.Sum(c.scratch[:20])
+// readMaxAllowedPacket sets the connection's packet size limit to the server's
+// max_allowed_packet.
+func (c *conn) readMaxAllowedPacket(ctx context.Context) error {
+	rows, err := c.queryContext(ctx, "SELECT @@max_allowed_packet", nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-		// c.scratch[0:20] == SHA1(password)
+	dest := make([]drv.Value, 1)
+	err = rows.Next(dest)
+	if err != nil {
+		return err
+	}
 
-		hash.Reset()
-		hash.Write(c.scratch[:20])
-		hash.Sum(c.scratch[20:40])
+	switch v := dest[0].(type) {
+	case int64:
+		c.maxAllowedPacket = v
+	case []byte:
+		c.maxAllowedPacket, err = strconv.ParseInt(string(v), 10, 64)
+	default:
+		err = fmt.Errorf("unexpected max_allowed_packet value: %v", v)
+	}
+	return err
+}
 
-		// c.scratch[0:20] == SHA1(password)
-		// c.scratch[20:40] == SHA1(SHA1(password))
+// initSession sets the session system variables, and runs the init statements,
+// from the connection's Config. It is run after the handshake, and after every
+// session reset.
+func (c *conn) initSession(ctx context.Context) error {
+	collationName, collationID, err := c.cfg.collation()
+	if err != nil {
+		return err
+	}
 
-		hash.Reset()
-		hash.Write(passwdChallenge[:passwdLen])
-		hash.Write(c.scratch[20:40])
-		hash.Sum(c.scratch[20:40])
+	if collationID > 255 {
+		query := fmt.Sprintf("SET NAMES %s COLLATE %s", collationCharset(collationName), collationName)
+		_, err = c.execContext(ctx, query, nil)
+		if err != nil {
+			return err
+		}
+	}
 
-		// c.scratch[0:20] = SHA1(password)
-		// c.scratch[20:40] = SHA1(challenge + SHA1(SHA1(password)))
+	if len(c.cfg.Params) > 0 {
+		names := make([]string, 0, len(c.cfg.Params))
+		for name := range c.cfg.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
 
-		for i := 0; i < 20; i++ {
-			c.scratch[i+20] ^= c.scratch[i]
+		var query strings.Builder
+		query.WriteString("SET ")
+		for i, name := range names {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "@@SESSION.%s = %s", name, c.cfg.Params[name])
 		}
 
-		fmt.Fprintf(c.reuseBuf, "%c", 20)
-		c.reuseBuf.Write(c.scratch[20:40])
-	} else {
-		fmt.Fprintf(c.reuseBuf, "%c", 0)
+		_, err := c.execContext(ctx, query.String(), nil)
+		if err != nil {
+			return err
+		}
 	}
 
-	if len(db) > 0 {
-		fmt.Fprintf(c.reuseBuf, "%s\x00", db)
+	for _, query := range c.cfg.InitSQL {
+		_, err := c.execContext(ctx, query, nil)
+		if err != nil {
+			return err
+		}
 	}
 
-	c.BeginPacket(int64(c.reuseBuf.Len()))
+	return nil
+}
 
-	_, err = c.Write(c.reuseBuf.Bytes())
-	c.reuseBuf.Reset()
-	if err != nil {
-		return err
+// ResetSession implements drv.SessionResetter. If the connection was opened
+// with ResetSession set, then the session is reset with COM_RESET_CONNECTION
+// before the connection is reused, and initialized again. A connection that a
+// failed write left unusable is reported as bad instead, so that it is closed.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.bad {
+		return drv.ErrBadConn
+	}
+	if !c.cfg.ResetSession {
+		return nil
 	}
 
-	err = c.EndPacket(FLUSH)
+	err := c.sendCommandString(comResetConnection, "")
 	if err != nil {
-		return err
+		return drv.ErrBadConn
 	}
+	c.resets++
+	c.clientCharset = ""
+	c.charsetChanged = false
 
-	err = c.AdvancePacket()
+	_, err = c.readExecResult()
 	if err != nil {
-		return err
+		return drv.ErrBadConn
 	}
 
-	err = readExactly(c, c.scratch[:1])
+	err = c.initSession(ctx)
 	if err != nil {
-		return err
+		return drv.ErrBadConn
 	}
 
-	if c.scratch[0] != 0 {
-		// TODO(sanjay): explain this and retrieve the rest of the info
-		// from the connection
-		return errors.New("auth failed")
-	}
 	return nil
 }
 
 func (c *conn) Begin() (drv.Tx, error) {
-	panic("unimplemented")
+	return c.BeginTx(context.Background(), drv.TxOptions{})
+}
+
+// BeginTx starts a transaction with START TRANSACTION. A non-default isolation
+// level is set for the transaction with SET TRANSACTION beforehand.
+func (c *conn) BeginTx(ctx context.Context, opts drv.TxOptions) (drv.Tx, error) {
+	h := c.startHook("", 0)
+	t, err := c.beginTx(ctx, opts)
+	h.finish(err, func(e HookEvent) { c.cfg.Hooks.Begin(ctx, e) })
+	return t, err
+}
+
+func (c *conn) beginTx(ctx context.Context, opts drv.TxOptions) (drv.Tx, error) {
+	if opts.Isolation != drv.IsolationLevel(sql.LevelDefault) {
+		level, ok := isolationLevels[sql.IsolationLevel(opts.Isolation)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported isolation level: %v", sql.IsolationLevel(opts.Isolation))
+		}
+
+		_, err := c.execContext(ctx, "SET TRANSACTION ISOLATION LEVEL "+level, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := "START TRANSACTION"
+	if opts.ReadOnly {
+		query += " READ ONLY"
+	}
+
+	_, err := c.execContext(ctx, query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx{c: c}, nil
+}
+
+var isolationLevels = map[sql.IsolationLevel]string{
+	sql.LevelReadUncommitted: "READ UNCOMMITTED",
+	sql.LevelReadCommitted:   "READ COMMITTED",
+	sql.LevelRepeatableRead:  "REPEATABLE READ",
+	sql.LevelSerializable:    "SERIALIZABLE",
+}
+
+type tx struct {
+	c *conn
+}
+
+func (t *tx) Commit() error {
+	c := t.c
+	h := c.startHook("", 0)
+	_, err := c.execContext(context.Background(), "COMMIT", nil)
+	h.finish(err, func(e HookEvent) { c.cfg.Hooks.Commit(context.Background(), e) })
+	t.c = nil
+	return err
+}
+
+func (t *tx) Rollback() error {
+	c := t.c
+	h := c.startHook("", 0)
+	_, err := c.execContext(context.Background(), "ROLLBACK", nil)
+	h.finish(err, func(e HookEvent) { c.cfg.Hooks.Rollback(context.Background(), e) })
+	t.c = nil
+	return err
 }
 
 func (c *conn) Close() error {
-	err := c.rwc.Close()
+	h := c.startHook("", 0)
+	err := c.close()
+	h.finish(err, func(e HookEvent) { c.cfg.Hooks.Close(context.Background(), e) })
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *conn) Prepare(sqlStr string) (drv.Stmt, error) {
-	c.seqId = 0
+// close closes the network connection, and releases the compression codec, if
+// there is one.
+func (c *conn) close() error {
+	if c.compress != nil {
+		c.compress.Close()
+	}
+	return c.rwc.Close()
+}
+
+// sendCommandString sends a command packet consisting of the command byte,
+// followed by arg. This is the format of COM_QUERY, COM_STMT_PREPARE and
+// COM_INIT_DB, among others.
+func (c *conn) sendCommandString(command byte, arg string) error {
+	err := c.checkPacketSize(1 + int64(len(arg)))
+	if err != nil {
+		return err
+	}
 
-	c.BeginPacket(1 + int64(len(sqlStr)))
+	c.resetSeq()
 
-	c.scratch[0] = comStmtPrepare
-	_, err := c.Write(c.scratch[:1])
+	c.BeginPacket(1 + int64(len(arg)))
+
+	c.scratch[0] = command
+	_, err = c.Write(c.scratch[:1])
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	_, err = io.WriteString(c, sqlStr)
+	_, err = io.WriteString(c, arg)
 	if err != nil {
+		return err
+	}
+
+	return c.EndPacket(FLUSH)
+}
+
+func (c *conn) Prepare(sqlStr string) (drv.Stmt, error) {
+	return c.PrepareContext(context.Background(), sqlStr)
+}
+
+// PrepareContext is like Prepare. The context is only checked before the
+// statement is sent, and passed on to the Config's Hooks.
+func (c *conn) PrepareContext(ctx context.Context, sqlStr string) (drv.Stmt, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	err = c.EndPacket(FLUSH)
+	h := c.startHook(sqlStr, 0)
+	s, err := c.prepare(sqlStr)
+	h.finish(err, func(e HookEvent) { c.cfg.Hooks.Prepare(ctx, e) })
+	return s, err
+}
+
+func (c *conn) prepare(sqlStr string) (drv.Stmt, error) {
+	c.noteCharsetChange(sqlStr)
+
+	err := c.sendCommandString(comStmtPrepare, sqlStr)
 	if err != nil {
 		return nil, err
 	}
@@ -466,14 +764,13 @@ func (c *conn) Prepare(sqlStr string) (drv.Stmt, error) {
 		return nil, err
 	}
 
-	if c.scratch[0] != 0 {
-
-		// TODO(sanjay): explain this and retrieve the rest of the info
-		// from the connection
-		return nil, errors.New("prepare failed")
+	if c.scratch[0] == 0xff {
+		return nil, c.ErrorFromErrPacket()
+	} else if c.scratch[0] != 0 {
+		return nil, fmt.Errorf("unexpected response to COM_STMT_PREPARE: 0x%02x", c.scratch[0])
 	}
 
-	s := &stmt{c: c}
+	s := &stmt{c: c, sqlStr: sqlStr, resets: c.resets}
 
 	err = readExactly(c, c.scratch[1:12])
 	if err != nil {
@@ -497,7 +794,7 @@ func (c *conn) Prepare(sqlStr string) (drv.Stmt, error) {
 	}
 
 	if numParams > 0 {
-		err = c.ReadEOFPacket()
+		err = c.readEndOfDefinitions()
 		if err != nil {
 			return nil, err
 		}
@@ -511,7 +808,7 @@ func (c *conn) Prepare(sqlStr string) (drv.Stmt, error) {
 	}
 
 	if numColumns > 0 {
-		err = c.ReadEOFPacket()
+		err = c.readEndOfDefinitions()
 		if err != nil {
 			return nil, err
 		}
@@ -520,6 +817,223 @@ func (c *conn) Prepare(sqlStr string) (drv.Stmt, error) {
 	return s, nil
 }
 
+// ExecContext executes query with a single COM_QUERY. If query has arguments,
+// then they are only interpolated if the connection was opened with
+// interpolateParams=true; otherwise, drv.ErrSkip is returned so that the query
+// is prepared instead.
+func (c *conn) ExecContext(ctx context.Context, query string, args []drv.NamedValue) (drv.Result, error) {
+	h := c.startHook(query, len(args))
+	c.startQueryLog(query, len(args))
+	result, err := c.execContext(ctx, query, args)
+	c.finishExecLog(ctx, result, err)
+	h.finishExec(ctx, result, err)
+	return result, err
+}
+
+func (c *conn) execContext(ctx context.Context, query string, args []drv.NamedValue) (drv.Result, error) {
+	query, err := c.queryText(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := queryAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.sendQueryCommand(query, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.readExecResult()
+}
+
+// readExecResult reads the response to a command whose result set, if any, is
+// of no interest, and returns the number of affected rows and the last insert
+// id.
+func (c *conn) readExecResult() (drv.Result, error) {
+	err := c.AdvancePacket()
+	if err != nil {
+		return nil, err
+	}
+
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	if c.scratch[0] == 0xff {
+		// This is an error packet
+		return nil, c.ErrorFromErrPacket()
+	} else if c.scratch[0] == 0xfb {
+		// This is a LOAD DATA LOCAL INFILE request.
+		return c.sendLocalInfile()
+	} else if c.scratch[0] != 0x00 {
+		// This query has result rows. The user is not interested in these, so
+		// we simply skip over them.
+		err = c.skipColumnDefinitions()
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.SkipPacketsUntilEOFPacket()
+		if err != nil {
+			return nil, err
+		}
+		return unknownResults(0), nil
+	}
+
+	return c.readOKPacket()
+}
+
+// QueryContext is like ExecContext, except that it returns the rows of the
+// result set, in the text protocol.
+func (c *conn) QueryContext(ctx context.Context, query string, args []drv.NamedValue) (drv.Rows, error) {
+	h := c.startHook(query, len(args))
+	c.startQueryLog(query, len(args))
+	rows, err := c.queryContext(ctx, query, args)
+	c.finishQueryLog(ctx, rows, err)
+	h.finishQuery(ctx, rows, err)
+	return rows, err
+}
+
+func (c *conn) queryContext(ctx context.Context, query string, args []drv.NamedValue) (drv.Rows, error) {
+	query, err := c.queryText(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := queryAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.sendQueryCommand(query, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.AdvancePacket()
+	if err != nil {
+		return nil, err
+	}
+
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	if c.scratch[0] == 0xff {
+		// This is an error packet
+		return nil, c.ErrorFromErrPacket()
+	} else if c.scratch[0] == 0xfb {
+		// This is a LOAD DATA LOCAL INFILE request, which has no rows.
+		_, err = c.sendLocalInfile()
+		if err != nil {
+			return nil, err
+		}
+
+		return &resultIter{atEOF: true, c: c, text: true, warnings: c.warnings}, nil
+	} else if c.scratch[0] == 0x00 {
+		// This is an OK packet, meaning no rows were there to be read.
+		_, err = c.readOKPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		return &resultIter{atEOF: true, c: c, text: true, warnings: c.warnings}, nil
+	}
+
+	// Otherwise, this packet holds the number of columns, and we have to read
+	// their definitions, since there is no statement that has them cached.
+	// Text result sets always come with their column definitions.
+	numColumns, _, err := c.readColumnCount()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]outputFieldData, numColumns)
+	for i := range fields {
+		err = c.ReadFieldDefinition(&fields[i].field)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = c.readEndOfDefinitions()
+	if err != nil {
+		return nil, err
+	}
+
+	return &resultIter{c: c, fields: fields, text: true}, nil
+}
+
+// queryText returns the text of the COM_QUERY to send for query and args.
+func (c *conn) queryText(ctx context.Context, query string, args []drv.NamedValue) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if len(args) == 0 {
+		c.noteCharsetChange(query)
+		return query, nil
+	}
+
+	if !c.cfg.InterpolateParams {
+		return "", drv.ErrSkip
+	}
+
+	return c.interpolateParams(query, args)
+}
+
+// readOKPacket reads the rest of an OK packet, assuming that its header byte
+// has already been read. The server status is recorded on the connection.
+func (c *conn) readOKPacket() (drv.Result, error) {
+	affRows, err := c.ReadLengthEncodedInt(c)
+	if err != nil {
+		return nil, err
+	}
+
+	lastInsertId, err := c.ReadLengthEncodedInt(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// The status flags are followed by the number of warnings.
+	err = readExactly(c, c.scratch[:4])
+	if err != nil {
+		return nil, err
+	}
+	c.status = serverStatus(binary.LittleEndian.Uint16(c.scratch[:2]))
+	c.warnings = binary.LittleEndian.Uint16(c.scratch[2:4])
+
+	// With session tracking, the human readable status message is followed
+	// by the changes to the session state.
+	c.session = SessionState{}
+	if c.clientFlags&flagSessionTrack != 0 && c.framer.Remaining() > 0 {
+		err = c.SkipLengthEncodedString()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.status&statusSessionStateChanged != 0 {
+			c.session, err = c.readSessionState()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Skip the human readable status message, if we haven't already.
+	err = c.AdvanceToEOF()
+	if err != nil {
+		return nil, err
+	}
+
+	return results{affectedRows: int64(affRows), lastInsertId: int64(lastInsertId), warnings: c.warnings, session: c.session}, nil
+}
+
 // Read the data form an error packet and make a Go error value.
 // This function assumes that you've already read the first packet of the
 // error packet.
@@ -533,21 +1047,24 @@ func (c *conn) ErrorFromErrPacket() error {
 	}
 	ret.errorCode = binary.LittleEndian.Uint16(c.scratch[:2])
 
-	// Skip the '#' character
-	err = readExactly(c, c.scratch[:1])
-	if err != nil {
+	// The SQL state follows a '#'. Errors sent before the handshake is done,
+	// such as for having too many connections, may not have one.
+	c.reuseBuf.Reset()
+	n, err := c.Read(c.scratch[:1])
+	if err != nil && err != io.EOF {
 		return err
 	}
-
-	// Store the SQL state
-	err = readExactly(c, ret.sqlState[:])
-	if err != nil {
-		return err
+	if n == 1 && c.scratch[0] == '#' && c.framer.Remaining() >= int64(len(ret.sqlState)) {
+		err = readExactly(c, ret.sqlState[:])
+		if err != nil {
+			return err
+		}
+	} else if n == 1 {
+		c.reuseBuf.WriteByte(c.scratch[0])
 	}
 
-	// Read the human readable message.
-	c.reuseBuf.Reset()
-	c.reuseBuf.Grow(int(c.lr.N))
+	// Read the human readable message, which is the rest of the packet.
+	c.reuseBuf.Grow(int(c.framer.Remaining()))
 	_, err = io.Copy(c.reuseBuf, c)
 	if err != nil {
 		return err
@@ -556,6 +1073,11 @@ func (c *conn) ErrorFromErrPacket() error {
 	return &ret
 }
 
+// The error codes of server errors that the driver handles itself.
+const (
+	errCodeUnknownSystemVariable = 1193
+)
+
 type serverError struct {
 	errorCode uint16
 	sqlState  [5]byte
@@ -585,15 +1107,18 @@ func (c *conn) ReadFieldDefinition(f *field) error {
 	}
 
 	// 	Table name
-	tableNameLen, err := c.ReadLengthEncodedInt(c)
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return err
+	}
+	tableNameLen, err := c.readValueLength(c.scratch[0], true)
 	if err != nil {
 		return err
 	}
 
 	c.reuseBuf.Reset()
 
-	c.reuseBuf.Grow(int(tableNameLen))
-	err = c.CopyN(c.reuseBuf, c, int64(tableNameLen))
+	err = c.readValueInto(tableNameLen)
 	if err != nil {
 		return err
 	}
@@ -605,7 +1130,11 @@ func (c *conn) ReadFieldDefinition(f *field) error {
 	}
 
 	// Column name
-	colNameLen, err := c.ReadLengthEncodedInt(c)
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return err
+	}
+	colNameLen, err := c.readValueLength(c.scratch[0], true)
 	if err != nil {
 		return err
 	}
@@ -614,8 +1143,7 @@ func (c *conn) ReadFieldDefinition(f *field) error {
 		_ = c.reuseBuf.WriteByte('.') // in-memory buffer
 	}
 
-	c.reuseBuf.Grow(int(colNameLen))
-	err = c.CopyN(c.reuseBuf, c, int64(colNameLen))
+	err = c.readValueInto(colNameLen)
 	if err != nil {
 		return err
 	}
@@ -628,17 +1156,65 @@ func (c *conn) ReadFieldDefinition(f *field) error {
 		return err
 	}
 
+	// With MariaDB's extended metadata, the name and format of the column's
+	// data type follow, if it is more specific than the wire type.
+	f.extTypeName, f.extFormat = "", ""
+	if c.clientMariaDBFlags&mariadbFlagExtendedMetadata != 0 {
+		err = c.readExtendedMetadata(f)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = readExactly(c, c.scratch[:11])
 	if err != nil {
 		return err
 	}
 
+	f.charset = binary.LittleEndian.Uint16(c.scratch[1:3])
 	f.ftype = fieldType(c.scratch[7])
 	f.flag = fieldFlag(binary.LittleEndian.Uint16(c.scratch[8:10]))
 
 	return nil
 }
 
+// readExtendedMetadata reads the extended metadata in a column definition,
+// which is a length-encoded list of entries. Each entry is a byte giving its
+// kind, followed by a length-encoded string.
+func (c *conn) readExtendedMetadata(f *field) error {
+	size, err := c.ReadLengthEncodedInt(c)
+	if err != nil {
+		return err
+	}
+	if size > uint64(c.framer.Remaining()) {
+		return errors.New("malformed extended metadata in column definition")
+	}
+
+	buf := make([]byte, size)
+	err = readExactly(c, buf)
+	if err != nil {
+		return err
+	}
+
+	for len(buf) > 0 {
+		kind := buf[0]
+
+		var value []byte
+		value, buf = lengthEncodedBytes(buf[1:])
+		if value == nil {
+			return errors.New("malformed extended metadata in column definition")
+		}
+
+		switch kind {
+		case 0:
+			f.extTypeName = string(value)
+		case 1:
+			f.extFormat = string(value)
+		}
+	}
+	return nil
+}
+
 func (c *conn) ReadEOFPacket() error {
 	err := c.AdvancePacket()
 	if err != nil {
@@ -650,13 +1226,115 @@ func (c *conn) ReadEOFPacket() error {
 		return err
 	}
 
-	if c.scratch[0] == 0xfe && c.lr.N <= 4 {
-		return nil
+	if c.atEOFPacket() {
+		return c.readEOFStatus()
 	}
 
 	return errors.New("Did not find EOF packet, where expected")
 }
 
+// readEndOfDefinitions reads the EOF packet that follows a list of column or
+// parameter definitions. There is none if CLIENT_DEPRECATE_EOF was negotiated.
+func (c *conn) readEndOfDefinitions() error {
+	if c.clientFlags&flagDeprecateEOF != 0 {
+		return nil
+	}
+	return c.ReadEOFPacket()
+}
+
+// readColumnCount reads the number of columns of a result set, assuming that
+// its first byte is in c.scratch[0]. It also returns whether the column
+// definitions follow, which they always do unless MariaDB's metadata caching
+// is in use, and the server knows that we have the definitions already.
+func (c *conn) readColumnCount() (uint64, bool, error) {
+	numColumns, err := c.readLengthEncodedIntRest(c, c.scratch[0])
+	if err != nil {
+		return 0, false, err
+	}
+	if numColumns > maxColumns {
+		return 0, false, fmt.Errorf("malformed packet: result set has %d columns", numColumns)
+	}
+
+	if c.clientMariaDBFlags&mariadbFlagCacheMetadata == 0 {
+		return numColumns, true, nil
+	}
+
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return 0, false, err
+	}
+	return numColumns, c.scratch[0] != 0, nil
+}
+
+// maxColumns is the most columns a result set may have. Prepared statements
+// report their number of columns in 16 bits, and MySQL allows no more than
+// 4096.
+const maxColumns = 1<<16 - 1
+
+// skipColumnDefinitions skips the column definitions of a result set,
+// assuming that the first byte of the column count is in c.scratch[0].
+func (c *conn) skipColumnDefinitions() error {
+	numColumns, metadataFollows, err := c.readColumnCount()
+	if err != nil {
+		return err
+	}
+
+	if !metadataFollows {
+		return nil
+	}
+
+	for i := uint64(0); i < numColumns; i++ {
+		err = c.AdvancePacket()
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.readEndOfDefinitions()
+}
+
+// atEOFPacket returns true if the current packet, whose first byte has been
+// read into c.scratch[0], marks the end of a result set. That is an EOF
+// packet, or with CLIENT_DEPRECATE_EOF, an OK packet with an EOF packet's
+// header byte. A row can start with the same byte, but only if its first value
+// is at least 16MB long.
+func (c *conn) atEOFPacket() bool {
+	if c.scratch[0] != 0xfe {
+		return false
+	}
+	if c.clientFlags&flagDeprecateEOF != 0 {
+		return !c.framer.mergeNextPacket
+	}
+	return c.framer.Remaining() <= 4
+}
+
+// readEOFStatus reads the rest of the packet that marks the end of a result
+// set, assuming that its header byte has already been read, and records the
+// server status and number of warnings on the connection.
+func (c *conn) readEOFStatus() error {
+	if c.clientFlags&flagDeprecateEOF != 0 {
+		_, err := c.readOKPacket()
+		return err
+	}
+
+	if c.framer.Remaining() < 4 {
+		// Pre-4.1 servers send an EOF packet without any status.
+		return c.AdvanceToEOF()
+	}
+
+	// The number of warnings precedes the status flags.
+	err := readExactly(c, c.scratch[:4])
+	if err != nil {
+		return err
+	}
+	c.warnings = binary.LittleEndian.Uint16(c.scratch[0:2])
+	c.status = serverStatus(binary.LittleEndian.Uint16(c.scratch[2:4]))
+
+	return c.AdvanceToEOF()
+}
+
+// SkipPacketsUntilEOFPacket skips the rows of a result set, up to and
+// including the packet that marks its end.
 func (c *conn) SkipPacketsUntilEOFPacket() error {
 	for {
 		err := c.AdvancePacket()
@@ -669,17 +1347,10 @@ func (c *conn) SkipPacketsUntilEOFPacket() error {
 			return err
 		}
 
-		if c.scratch[0] == 0xfe && c.lr.N <= 4 {
-			break
+		if c.atEOFPacket() {
+			return c.readEOFStatus()
 		}
 	}
-
-	err := c.AdvanceToEOF()
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 // This is a re-implementation of io.CopyN that uses c.scratch for its
@@ -715,7 +1386,9 @@ func (c *conn) CopyN(dst io.Writer, src io.Reader, n int64) error {
 }
 
 var (
-	_ drv.Conn = (*conn)(nil)
-	// _ drv.Execer  = (*conn)(nil) TODO(sanjay): implement this
-	// _ drv.Queryer = (*conn)(nil) TODO(sanjay): implement this
+	_ drv.Conn            = (*conn)(nil)
+	_ drv.ExecerContext   = (*conn)(nil)
+	_ drv.QueryerContext  = (*conn)(nil)
+	_ drv.SessionResetter = (*conn)(nil)
+	_ drv.ConnBeginTx     = (*conn)(nil)
 )
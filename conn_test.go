@@ -0,0 +1,95 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+func TestResetSessionReprepares(t *testing.T) {
+	s := newTestServer(t)
+	s.AddResult("INSERT INTO t VALUES (?)", gmstest.Result{AffectedRows: 1})
+
+	db := openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.ResetSession = true
+	})
+
+	ctx := context.Background()
+	sqlConn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn error: %v", err)
+	}
+	defer sqlConn.Close()
+
+	err = sqlConn.Raw(func(driverConn any) error {
+		c := driverConn.(*conn)
+		st, err := c.Prepare("INSERT INTO t VALUES (?)")
+		if err != nil {
+			t.Fatalf("Prepare error: %v", err)
+		}
+
+		// Resetting the session frees the statement on the server, so it is
+		// prepared again before it is executed.
+		for i := 0; i < 2; i++ {
+			if i > 0 {
+				err = c.ResetSession(ctx)
+				if err != nil {
+					t.Fatalf("ResetSession error: %v", err)
+				}
+			}
+
+			_, err = st.Exec([]drv.Value{int64(i)})
+			if err != nil {
+				t.Fatalf("Exec after %d resets error: %v", i, err)
+			}
+		}
+		return st.Close()
+	})
+	if err != nil {
+		t.Fatalf("Raw error: %v", err)
+	}
+
+	var got [][]any
+	for _, st := range s.Statements() {
+		if st.Prepared {
+			got = append(got, st.Args)
+		}
+	}
+	if want := [][]any{{int64(0)}, {int64(1)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got executions with arguments %v, want %v", got, want)
+	}
+}
+
+func TestConnectDeadlineWithReadTimeout(t *testing.T) {
+	// The listener accepts connections but never sends a greeting.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen error: %v", err)
+	}
+	defer l.Close()
+
+	cfg := NewConfig()
+	cfg.Addr = l.Addr().String()
+	cfg.ReadTimeout = time.Minute
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewConnector error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = connector.Connect(ctx)
+	if err == nil {
+		t.Fatal("Connect succeeded without a greeting")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Connect took %v, want it bounded by the context's deadline", elapsed)
+	}
+}
@@ -0,0 +1,93 @@
+package gms
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// maxConnectAttrsSize is the most bytes of connection attributes that may be
+// sent in the handshake response.
+const maxConnectAttrsSize = 64 * 1024
+
+const clientName = "gms"
+
+var (
+	defaultConnectAttrsOnce sync.Once
+	defaultConnectAttrsMap  map[string]string
+)
+
+// defaultConnectAttrs returns the connection attributes that are sent on every
+// connection, which describe the client and the process it runs in.
+func defaultConnectAttrs() map[string]string {
+	defaultConnectAttrsOnce.Do(func() {
+		defaultConnectAttrsMap = map[string]string{
+			"_client_name":    clientName,
+			"_client_version": clientVersion(),
+			"_os":             runtime.GOOS,
+			"_platform":       runtime.GOARCH,
+			"_pid":            strconv.Itoa(os.Getpid()),
+		}
+		if len(os.Args) > 0 {
+			defaultConnectAttrsMap["program_name"] = filepath.Base(os.Args[0])
+		}
+	})
+	return defaultConnectAttrsMap
+}
+
+// clientVersion returns the version of this module that the program was built
+// with, or "(devel)" if it is unknown.
+func clientVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	const modulePath = "github.com/balasanjay/gms"
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+// connectAttrs returns the encoded connection attributes to send for cfg,
+// which are the defaults overridden by cfg.ConnectAttrs. This is the body of
+// the length-encoded string that ends the handshake response.
+func (cfg *Config) connectAttrs() ([]byte, error) {
+	attrs := make(map[string]string, len(defaultConnectAttrs())+len(cfg.ConnectAttrs))
+	for name, value := range defaultConnectAttrs() {
+		attrs[name] = value
+	}
+	for name, value := range cfg.ConnectAttrs {
+		attrs[name] = value
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf []byte
+	for _, name := range names {
+		buf = wire.AppendLengthEncodedString(buf, name)
+		buf = wire.AppendLengthEncodedString(buf, attrs[name])
+	}
+
+	if len(buf) > maxConnectAttrsSize {
+		return nil, fmt.Errorf("connection attributes take %d bytes, more than the limit of %d", len(buf), maxConnectAttrsSize)
+	}
+	return buf, nil
+}
@@ -0,0 +1,51 @@
+package gms
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConnectAttrs(t *testing.T) {
+	s := newTestServer(t)
+
+	db := openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.ConnectAttrs = map[string]string{"program_name": "billing", "team": "payments"}
+	})
+
+	err := db.PingContext(context.Background())
+	if err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+
+	attrs := s.ConnectAttrs()
+	want := map[string]string{
+		"_client_name": clientName,
+		"_os":          runtime.GOOS,
+		"_platform":    runtime.GOARCH,
+		"_pid":         strconv.Itoa(os.Getpid()),
+		"program_name": "billing",
+		"team":         "payments",
+	}
+	for name, value := range want {
+		if attrs[name] != value {
+			t.Errorf("connection attribute %q = %q, want %q", name, attrs[name], value)
+		}
+	}
+	if attrs["_client_version"] == "" {
+		t.Errorf("connection attribute _client_version is missing")
+	}
+}
+
+func TestConnectAttrsLimit(t *testing.T) {
+	cfg := NewConfig()
+	cfg.ConnectAttrs = map[string]string{"big": strings.Repeat("x", maxConnectAttrsSize)}
+
+	_, err := NewConnector(cfg)
+	if err == nil || !strings.Contains(err.Error(), "connection attributes") {
+		t.Errorf("NewConnector error = %v, want an error about the size of the connection attributes", err)
+	}
+}
@@ -32,6 +32,35 @@ const (
 	flagSecureConn
 	flagMultiStatements
 	flagMultiResults
+	flagPSMultiResults
+	flagPluginAuth
+	flagConnectAttrs
+	flagPluginAuthLenEncData
+	flagCanHandleExpiredPasswords
+	flagSessionTrack
+	flagDeprecateEOF
+	flagOptionalResultsetMetadata
+	flagZstdCompression
+	flagQueryAttributes
+	flagMultiFactorAuth
+	flagCapabilityExtension
+	flagSSLVerifyServerCert
+	flagRememberOptions
+)
+
+// mariadbFlag is a MariaDB extended capability flag. MariaDB servers send
+// these in the last 4 of the reserved bytes of the greeting, in place of
+// CLIENT_LONG_PASSWORD, which they call CLIENT_MYSQL and leave unset. Clients
+// answer in the same place in the handshake response.
+type mariadbFlag uint32
+
+const (
+	mariadbFlagProgress mariadbFlag = 1 << iota
+	mariadbFlagCOMMulti
+	mariadbFlagStmtBulkOperations
+	mariadbFlagExtendedMetadata
+	mariadbFlagCacheMetadata
+	mariadbFlagBulkUnitResults
 )
 
 const (
@@ -63,6 +92,47 @@ const (
 	comStmtReset
 	comSetOption
 	comStmtFetch
+	comDaemon
+	comBinlogDumpGTID
+	comResetConnection
+)
+
+// comStmtBulkExecute is MariaDB's command for executing a prepared statement
+// with many sets of parameters.
+const comStmtBulkExecute byte = 0xfa
+
+// The flags of COM_STMT_BULK_EXECUTE.
+const (
+	bulkFlagSendUnitResults uint16 = 64
+	bulkFlagSendTypes       uint16 = 128
+)
+
+// The flags byte of COM_STMT_EXECUTE.
+const (
+	cursorTypeReadOnly byte = 1 << iota
+	cursorTypeForUpdate
+	cursorTypeScrollable
+	cursorParameterCountAvailable
+)
+
+type serverStatus uint16
+
+const (
+	statusInTrans serverStatus = 1 << iota
+	statusAutocommit
+	statusReserved
+	statusMoreResultsExist
+	statusNoGoodIndexUsed
+	statusNoIndexUsed
+	statusCursorExists
+	statusLastRowSent
+	statusDBDropped
+	statusNoBackslashEscapes
+	statusMetadataChanged
+	statusQueryWasSlow
+	statusPSOutParams
+	statusInTransReadOnly
+	statusSessionStateChanged
 )
 
 type fieldType byte
@@ -123,6 +193,8 @@ const (
 type results struct {
 	affectedRows int64
 	lastInsertId int64
+	warnings     uint16
+	session      SessionState
 }
 
 func (r results) LastInsertId() (int64, error) {
@@ -133,6 +205,17 @@ func (r results) RowsAffected() (int64, error) {
 	return r.affectedRows, nil
 }
 
+// SessionState returns the changes to the session reported by the statement.
+func (r results) SessionState() SessionState {
+	return r.session
+}
+
+// Warnings returns the number of warnings the statement raised, which SHOW
+// WARNINGS lists.
+func (r results) Warnings() uint16 {
+	return r.warnings
+}
+
 var (
 	errUnknownLastInsertId = errors.New("Server did not send last-insert-id")
 	errUnknownRowsAffected = errors.New("Server did not send number of rows affected")
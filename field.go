@@ -1,5 +1,7 @@
 package gms
 
+import "strings"
+
 type field struct {
 	// Stores the MySQL type of this field. For example, the VARCHAR type.
 	ftype fieldType
@@ -9,4 +11,103 @@ type field struct {
 
 	// Stores the MySQL field name for this field.
 	name string
+
+	// Stores the id of the collation of this field. For binary strings, and
+	// for non-string fields, this is the binary collation.
+	charset uint16
+
+	// The name and format of the field's data type, from MariaDB's extended
+	// metadata, if they are more specific than ftype. For example, a UUID
+	// field has the type name "uuid", and a JSON field, which is a LONGTEXT,
+	// has the format "json".
+	extTypeName string
+	extFormat   string
+}
+
+// isBinary returns true if this field holds binary data, rather than text.
+// Note that flagBinary can't be used to tell binary and text fields apart,
+// since it is also set for text fields with a binary collation.
+func (f *field) isBinary() bool {
+	return f.charset == binaryCollationID
+}
+
+// typeName returns the name of the type of this field, as used in SQL. Binary
+// string fields are told apart from text fields by their character set. The
+// names given by MariaDB's extended metadata take precedence.
+func (f *field) typeName() string {
+	if f.extTypeName != "" {
+		return strings.ToUpper(f.extTypeName)
+	} else if f.extFormat != "" {
+		return strings.ToUpper(f.extFormat)
+	}
+
+	switch f.ftype {
+	case fieldTypeDecimal, fieldTypeNewDecimal:
+		return "DECIMAL"
+	case fieldTypeTiny:
+		return "TINYINT"
+	case fieldTypeShort:
+		return "SMALLINT"
+	case fieldTypeLong:
+		return "INT"
+	case fieldTypeFloat:
+		return "FLOAT"
+	case fieldTypeDouble:
+		return "DOUBLE"
+	case fieldTypeNULL:
+		return "NULL"
+	case fieldTypeTimestamp:
+		return "TIMESTAMP"
+	case fieldTypeLongLong:
+		return "BIGINT"
+	case fieldTypeInt24:
+		return "MEDIUMINT"
+	case fieldTypeDate, fieldTypeNewDate:
+		return "DATE"
+	case fieldTypeTime:
+		return "TIME"
+	case fieldTypeDateTime:
+		return "DATETIME"
+	case fieldTypeYear:
+		return "YEAR"
+	case fieldTypeBit:
+		return "BIT"
+	case fieldTypeEnum:
+		return "ENUM"
+	case fieldTypeSet:
+		return "SET"
+	case fieldTypeGeometry:
+		return "GEOMETRY"
+	case fieldTypeVarChar, fieldTypeVarString:
+		if f.isBinary() {
+			return "VARBINARY"
+		}
+		return "VARCHAR"
+	case fieldTypeString:
+		if f.isBinary() {
+			return "BINARY"
+		}
+		return "CHAR"
+	case fieldTypeTinyBLOB:
+		if f.isBinary() {
+			return "TINYBLOB"
+		}
+		return "TINYTEXT"
+	case fieldTypeMediumBLOB:
+		if f.isBinary() {
+			return "MEDIUMBLOB"
+		}
+		return "MEDIUMTEXT"
+	case fieldTypeLongBLOB:
+		if f.isBinary() {
+			return "LONGBLOB"
+		}
+		return "LONGTEXT"
+	case fieldTypeBLOB:
+		if f.isBinary() {
+			return "BLOB"
+		}
+		return "TEXT"
+	}
+	return ""
 }
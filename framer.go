@@ -5,6 +5,11 @@ import (
 	"io"
 )
 
+// The largest payload of a single packet. A payload this large, or larger, is
+// split over several packets, and is followed by a shorter packet, which may be
+// empty, to mark its end.
+const maxPacketSize = (1 << 24) - 1
+
 type writeFlusher interface {
 	io.Writer
 	Flush() error
@@ -31,35 +36,98 @@ type framer struct {
 	// Common fields
 	scratch         [4]byte
 	nextExpectedSeq uint8
+
+	// Space for the data thrown away by Discard.
+	discardBuf [512]byte
+
+	// If non-nil, every packet sent and received is passed to a Tracer.
+	trace *packetTrace
+
+	// The number of bytes of packets, including their headers, sent and
+	// received so far.
+	bytesSent     int64
+	bytesReceived int64
+}
+
+func newFramer(reader io.Reader, writer writeFlusher) *framer {
+	return &framer{
+		reader:            reader,
+		maxRecvPacketSize: maxPacketSize,
+		writer:            writer,
+		maxSendPacketSize: maxPacketSize,
+		curWritePacketLen: -1,
+	}
+}
+
+// NextPacket discards the rest of the current packet, and starts reading the
+// next one.
+func (f *framer) NextPacket() error {
+	err := f.Discard()
+	if err != nil {
+		return err
+	}
+
+	return f.beginReadPacket()
+}
+
+// Discard reads and discards the rest of the current packet.
+func (f *framer) Discard() error {
+	for {
+		_, err := f.Read(f.discardBuf[:])
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Remaining returns the number of bytes left in the current packet, not
+// counting any packets that it will be merged with.
+func (f *framer) Remaining() int64 {
+	return int64(f.curPacketRemaining)
+}
+
+// beginReadPacket reads the header of the next packet, and sets up the framer
+// to read its payload.
+func (f *framer) beginReadPacket() error {
+	packetLen, seq, err := f.readPacketHeader()
+	if err != nil {
+		return err
+	}
+
+	if seq != f.nextExpectedSeq {
+		return fmt.Errorf("Expecting sequence id %v, got %v.", f.nextExpectedSeq, seq)
+	}
+	f.nextExpectedSeq++
+
+	f.curPacketRemaining = packetLen
+	f.mergeNextPacket = packetLen >= f.maxRecvPacketSize
+
+	if f.trace != nil {
+		f.trace.received.begin(seq, packetLen)
+		if packetLen == 0 {
+			f.trace.endReceived()
+		}
+	}
+	return nil
 }
 
 // Read fulfills the io.Reader contract.
 func (f *framer) Read(buf []byte) (int, error) {
 	// If we are done reading the current packet, but are supposed to merge the
-	// next one, then we do so.
-	if f.curPacketRemaining <= 0 && f.mergeNextPacket {
-		nextPacketLen, nextSeq, err := f.readPacketHeader()
+	// next one, then we do so. The next packet may be empty, if the payload
+	// was an exact multiple of the maximum packet size.
+	for f.curPacketRemaining <= 0 && f.mergeNextPacket {
+		err := f.beginReadPacket()
 		if err != nil {
 			return 0, err
 		}
-
-		if nextPacketLen < f.maxRecvPacketSize {
-			f.mergeNextPacket = false
-			f.curPacketRemaining = nextPacketLen
-		} else {
-			f.mergeNextPacket = true
-			f.curPacketRemaining = f.maxRecvPacketSize
-		}
-
-		if nextSeq != f.nextExpectedSeq {
-			return 0, fmt.Errorf("While reading packet header, was expecting sequence id %d, got %d.", f.nextExpectedSeq, nextSeq)
-		}
-		f.nextExpectedSeq++
 	}
 
 	// If we're done reading the current packet, and don't need to merge the
 	// next one, then we return EOF.
-	if f.curPacketRemaining <= 0 && !f.mergeNextPacket {
+	if f.curPacketRemaining <= 0 {
 		return 0, io.EOF
 	}
 
@@ -74,8 +142,15 @@ func (f *framer) Read(buf []byte) (int, error) {
 	rd, err := f.reader.Read(buf)
 	if rd > 0 {
 		f.curPacketRemaining -= uint32(rd)
+		f.bytesReceived += int64(rd)
+		if f.trace != nil {
+			f.trace.received.add(buf[:rd])
+			if f.curPacketRemaining == 0 {
+				f.trace.endReceived()
+			}
+		}
 	}
-	if err == io.EOF && f.curPacketRemaining > 0 {
+	if err == io.EOF && (f.curPacketRemaining > 0 || f.mergeNextPacket) {
 		err = io.ErrUnexpectedEOF
 	}
 	return rd, err
@@ -86,33 +161,23 @@ func (f *framer) Read(buf []byte) (int, error) {
 // If f.reader returns EOF before yielding 4 bytes, then io.ErrUnexpectedEOF is
 // returned as the error. Any other error is passed through verbatim.
 func (f *framer) readPacketHeader() (uint32, uint8, error) {
-	// First, we read 4 bytes.
-	buf := f.scratch[:]
-	for len(buf) > 0 {
-		n, err := f.reader.Read(buf)
-		if n > 0 {
-			buf = buf[n:]
-		}
-		if err == io.EOF {
-			if len(buf) > 0 {
-				return 0, 0, io.ErrUnexpectedEOF
-			} else {
-				break
-			}
-		} else if err != nil {
-			return 0, 0, err
-		}
+	err := readExactly(f.reader, f.scratch[:4])
+	if err != nil {
+		return 0, 0, err
 	}
+	f.bytesReceived += 4
 
 	// Extract packet length
-	packetLen := uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16
+	packetLen := uint32(f.scratch[0]) | uint32(f.scratch[1])<<8 | uint32(f.scratch[2])<<16
 
 	// Extract sequence number
-	nextSeq := buf[3]
+	nextSeq := f.scratch[3]
 
 	return packetLen, nextSeq, nil
 }
 
+// BeginPacket sets up the framer to write a packet with a payload of
+// packetSize bytes.
 func (f *framer) BeginPacket(packetSize int64) {
 	if f.curWritePacketLen != -1 {
 		panic("currently writing a packet, perhaps EndPacket was not called?")
@@ -120,11 +185,15 @@ func (f *framer) BeginPacket(packetSize int64) {
 	if f.availableWriteCap != 0 {
 		panic("internal error, availableWriteCap should be 0 before starting a new packet")
 	}
-	if packetSize <= 0 {
-		panic("trying to BeginPacket with a non-positive size")
+	if packetSize < 0 {
+		panic("trying to BeginPacket with a negative size")
 	}
 
 	f.curWritePacketLen = packetSize
+
+	// An empty packet is nothing but its header, which is written like the
+	// trailer of a large packet.
+	f.needsTrailer = packetSize == 0
 }
 
 func (f *framer) Write(buf []byte) (int, error) {
@@ -135,7 +204,7 @@ func (f *framer) Write(buf []byte) (int, error) {
 	}
 
 	written := 0
-	for len(buf) > 0 || f.needsTrailer {
+	for len(buf) > 0 || (f.needsTrailer && f.availableWriteCap == 0) {
 		// First, if we need to put a new packet header, we do that.
 		if f.availableWriteCap == 0 {
 			var newWriteCap uint32
@@ -160,16 +229,20 @@ func (f *framer) Write(buf []byte) (int, error) {
 			f.nextExpectedSeq++
 
 			n, err := f.writer.Write(f.scratch[:4])
-			if err == io.EOF && n < 4 {
-				return written, io.ErrUnexpectedEOF
-			} else if err == io.EOF {
-				// no-op, this could be OK if it is the trailer for the last
-				// packet
-			} else if err != nil {
+			if err != nil {
 				return written, err
+			} else if n != 4 {
+				return written, io.ErrShortWrite
 			}
+			f.bytesSent += 4
 
 			f.availableWriteCap = newWriteCap
+			if f.trace != nil {
+				f.trace.sent.begin(f.scratch[3], newWriteCap)
+				if newWriteCap == 0 {
+					f.trace.endSent()
+				}
+			}
 			continue
 		}
 
@@ -179,10 +252,17 @@ func (f *framer) Write(buf []byte) (int, error) {
 		}
 
 		n, err := f.writer.Write(buf[:nextSendSize])
+		if f.trace != nil {
+			f.trace.sent.add(buf[:n])
+		}
 		buf = buf[n:]
 		f.curWritePacketLen -= int64(n)
 		f.availableWriteCap -= uint32(n)
+		f.bytesSent += int64(n)
 		written += n
+		if f.trace != nil && n > 0 && f.availableWriteCap == 0 {
+			f.trace.endSent()
+		}
 		if err != nil {
 			return written, err
 		}
@@ -191,8 +271,10 @@ func (f *framer) Write(buf []byte) (int, error) {
 	return written, nil
 }
 
-func (f *framer) EndPacket(flush bool) error {
-	if f.curWritePacketLen != 0 {
+// EndPacket finishes the packet being written, which must have been written in
+// full, and flushes the underlying writer if flush is true.
+func (f *framer) EndPacket(flush flushPolicy) error {
+	if f.curWritePacketLen != 0 || f.availableWriteCap != 0 {
 		panic(fmt.Sprintf("internal error, miscalculated packet size, still %v bytes on previous packet", f.curWritePacketLen))
 	}
 
@@ -202,6 +284,7 @@ func (f *framer) EndPacket(flush bool) error {
 			return err
 		}
 	}
+	f.curWritePacketLen = -1
 
 	if !flush {
 		return nil
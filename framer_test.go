@@ -0,0 +1,97 @@
+package gms
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFramerRoundTrip(t *testing.T) {
+	const maxSize = 8
+
+	tests := []struct {
+		size int
+		// The lengths of the packets that the payload is split into.
+		packets []int
+	}{
+		{0, []int{0}},
+		{3, []int{3}},
+		{maxSize - 1, []int{maxSize - 1}},
+		{maxSize, []int{maxSize, 0}},
+		{maxSize + 1, []int{maxSize, 1}},
+		{2 * maxSize, []int{maxSize, maxSize, 0}},
+	}
+
+	for _, test := range tests {
+		var wire bytes.Buffer
+		bw := bufio.NewWriter(&wire)
+
+		w := newFramer(nil, bw)
+		w.maxSendPacketSize = maxSize
+
+		payload := bytes.Repeat([]byte{'x'}, test.size)
+		w.BeginPacket(int64(len(payload)))
+		// Write in two halves, to exercise packets that span writes.
+		if _, err := w.Write(payload[:len(payload)/2]); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		if _, err := w.Write(payload[len(payload)/2:]); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		if err := w.EndPacket(FLUSH); err != nil {
+			t.Fatalf("EndPacket error: %v", err)
+		}
+
+		// Check the framing on the wire.
+		raw := wire.Bytes()
+		for i, n := range test.packets {
+			if len(raw) < 4 {
+				t.Fatalf("size %d: missing packet %d", test.size, i)
+			}
+			gotLen := int(raw[0]) | int(raw[1])<<8 | int(raw[2])<<16
+			if gotLen != n || raw[3] != byte(i) {
+				t.Errorf("size %d: packet %d has (length, seq) = (%d, %d), want (%d, %d)", test.size, i, gotLen, raw[3], n, i)
+			}
+			raw = raw[4+gotLen:]
+		}
+		if len(raw) != 0 {
+			t.Errorf("size %d: %d unexpected trailing bytes", test.size, len(raw))
+		}
+
+		// Append another packet, to check that the first is read exactly.
+		wire.Write([]byte{1, 0, 0, byte(len(test.packets)), 'y'})
+
+		r := newFramer(&wire, nil)
+		r.maxRecvPacketSize = maxSize
+		if err := r.NextPacket(); err != nil {
+			t.Fatalf("size %d: NextPacket error: %v", test.size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: ReadAll error: %v", test.size, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("size %d: read back %q", test.size, got)
+		}
+
+		if err := r.NextPacket(); err != nil {
+			t.Fatalf("size %d: NextPacket error: %v", test.size, err)
+		}
+		if got, _ := io.ReadAll(r); string(got) != "y" {
+			t.Errorf("size %d: read next packet %q, want %q", test.size, got, "y")
+		}
+	}
+}
+
+func TestCheckPacketSize(t *testing.T) {
+	c := newConn(nil, NewConfig())
+	c.maxAllowedPacket = 1024
+
+	if err := c.checkPacketSize(1024); err != nil {
+		t.Errorf("checkPacketSize(1024) error: %v", err)
+	}
+	if _, ok := c.checkPacketSize(1025).(*PacketTooLargeError); !ok {
+		t.Errorf("checkPacketSize(1025): want *PacketTooLargeError")
+	}
+}
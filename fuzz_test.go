@@ -0,0 +1,285 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	drv "database/sql/driver"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// The fuzz targets below feed arbitrary bytes to the packet parsers, through a
+// connection that reads them as if they came from the server. The parsers must
+// return an error for malformed input, rather than panic, hang, or allocate
+// more than the input could justify.
+
+// newFuzzConn returns a connection that reads data, and that behaves as if it
+// had negotiated the capabilities picked out by the bits of flags.
+func newFuzzConn(flags byte, data []byte) *conn {
+	c := newScriptedConn(data)
+	if flags&1 != 0 {
+		c.clientFlags |= flagDeprecateEOF
+	}
+	if flags&2 != 0 {
+		c.clientFlags |= flagSessionTrack
+	}
+	if flags&4 != 0 {
+		c.clientMariaDBFlags |= mariadbFlagCacheMetadata
+	}
+	if flags&8 != 0 {
+		c.clientMariaDBFlags |= mariadbFlagExtendedMetadata
+	}
+	return c
+}
+
+// fakeGreeting returns the greeting of a server that supports CLIENT_PROTOCOL_41
+// and mysql_native_password.
+func fakeGreeting() []byte {
+	caps := flagProtocol41 | flagSecureConn | flagLongPassword | flagTransactions | flagPluginAuth | flagDeprecateEOF | flagSessionTrack
+
+	var buf bytes.Buffer
+	buf.WriteByte(0x0a)
+	buf.WriteString("8.0.0-fake\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	buf.WriteString("abcdefgh\x00")
+	binary.Write(&buf, binary.LittleEndian, uint16(caps))
+	buf.WriteByte(45)
+	binary.Write(&buf, binary.LittleEndian, uint16(statusAutocommit))
+	binary.Write(&buf, binary.LittleEndian, uint16(caps>>16))
+	buf.WriteByte(21)
+	buf.Write(make([]byte, 10))
+	buf.WriteString("ijklmnopqrst\x00")
+	buf.WriteString("mysql_native_password\x00")
+	return buf.Bytes()
+}
+
+// fakeErr is an ERR packet with an SQL state, and fakeErrNoState is one
+// without, as sent before the handshake completes.
+var (
+	fakeErr        = append([]byte{0xff, 0x28, 0x04, '#', '4', '2', '0', '0', '0'}, "You have an error in your SQL syntax"...)
+	fakeErrNoState = append([]byte{0xff, 0x10, 0x04}, "Too many connections"...)
+)
+
+// fakeBinaryResult returns the response to a COM_STMT_EXECUTE with a BIGINT,
+// a VARCHAR and a DATETIME column, and a row of values, one of them NULL.
+func fakeBinaryResult(deprecateEOF bool) []byte {
+	packets := [][]byte{
+		{3},
+		fakeColumnDefinition("n", fieldTypeLongLong, binaryCollationID, nil),
+		fakeColumnDefinition("s", fieldTypeVarString, 45, nil),
+		fakeColumnDefinition("t", fieldTypeDateTime, binaryCollationID, nil),
+	}
+	if !deprecateEOF {
+		packets = append(packets, fakeEOF)
+	}
+
+	row := []byte{0x00, 0x10}
+	row = binary.LittleEndian.AppendUint64(row, 42)
+	row = append(row, 5, 'h', 'e', 'l', 'l', 'o')
+	packets = append(packets, row)
+
+	if deprecateEOF {
+		packets = append(packets, fakeEOFOK)
+	} else {
+		packets = append(packets, fakeEOF)
+	}
+	return fakePackets(1, packets...)
+}
+
+func FuzzReadLengthEncodedInt(f *testing.F) {
+	for _, seed := range [][]byte{{0x00}, {0xfa}, {0xfb}, {0xfc, 0x01, 0x02}, {0xfd, 0x01, 0x02, 0x03}, {0xfe, 1, 2, 3, 4, 5, 6, 7, 8}, {0xff}, {0xfe, 0x01}} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := newScriptedConn(nil)
+		got, err := c.ReadLengthEncodedInt(bytes.NewReader(data))
+		want, _, wantErr := wire.ReadLengthEncodedInt(data)
+		if (err == nil) != (wantErr == nil) {
+			t.Fatalf("ReadLengthEncodedInt(%x) returned error %v, but the wire package returned %v", data, err, wantErr)
+		}
+		if err == nil && got != want {
+			t.Fatalf("ReadLengthEncodedInt(%x) = %d, but the wire package returned %d", data, got, want)
+		}
+	})
+}
+
+func FuzzHandshake(f *testing.F) {
+	greeting := fakePackets(0, fakeGreeting())
+	f.Add(append(greeting, fakePackets(2, fakeOK)...))
+	f.Add(append(greeting, fakePackets(2, fakeErrNoState)...))
+	f.Add(append(greeting, fakePackets(2, fakeErr)...))
+	f.Add(fakePackets(0, fakeErrNoState))
+	f.Add(greeting[:20])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := newFuzzConn(0, data)
+		c.cfg.User = "root"
+		c.cfg.Passwd = "secret"
+		c.handshake()
+	})
+}
+
+func FuzzReadFieldDefinition(f *testing.F) {
+	f.Add(byte(0), fakePackets(0, fakeColumnDefinition("id", fieldTypeLongLong, binaryCollationID, nil)))
+	f.Add(byte(8), fakePackets(0, fakeColumnDefinition("doc", fieldTypeBLOB, 45, []byte{1, 4, 'j', 's', 'o', 'n'})))
+	f.Add(byte(8), fakePackets(0, []byte{3, 'd', 'e', 'f', 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}))
+
+	f.Fuzz(func(t *testing.T, flags byte, data []byte) {
+		c := newFuzzConn(flags, data)
+		var f field
+		c.ReadFieldDefinition(&f)
+	})
+}
+
+func FuzzExec(f *testing.F) {
+	f.Add(byte(0), fakePackets(1, fakeOK))
+	f.Add(byte(2), fakePackets(1, fakeSessionOK("3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5")))
+	f.Add(byte(0), fakePackets(1, fakeErr))
+	f.Add(byte(0), fakePackets(1, fakeErrNoState))
+	f.Add(byte(0), fakePackets(1, []byte{0xff}))
+	f.Add(byte(0), fakePackets(1, []byte{0xfb, 'f', 'i', 'l', 'e'}))
+
+	f.Add(byte(0), fakeResult([]string{"n"}, []string{"1"}, false))
+
+	f.Fuzz(func(t *testing.T, flags byte, data []byte) {
+		c := newFuzzConn(flags, data)
+		c.ExecContext(context.Background(), "DO 1", nil)
+	})
+}
+
+// readAll reads rows until they fail or end, and then closes them.
+func readAll(rows drv.Rows) {
+	dest := make([]drv.Value, len(rows.Columns()))
+	for rows.Next(dest) == nil {
+	}
+	rows.Close()
+}
+
+func FuzzQuery(f *testing.F) {
+	for _, deprecateEOF := range []bool{false, true} {
+		var flags byte
+		if deprecateEOF {
+			flags = 1
+		}
+
+		f.Add(flags, fakeResult([]string{"a", "b"}, []string{"1", "2"}, deprecateEOF))
+	}
+
+	// A result set that fails partway through.
+	column := fakeColumnDefinition("n", fieldTypeLongLong, binaryCollationID, nil)
+	f.Add(byte(0), fakePackets(1, []byte{1}, column, fakeEOF, []byte{1, '1'}, fakeErr))
+	f.Add(byte(1), fakePackets(1, []byte{1}, column, []byte{1, '1'}, fakeErr))
+
+	// A row with a value that is longer than its packet.
+	f.Add(byte(0), fakePackets(1, []byte{1}, fakeColumnDefinition("s", fieldTypeVarString, 45, nil), fakeEOF,
+		[]byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, fakeEOF))
+
+	// More columns than a result set can have.
+	f.Add(byte(0), fakePackets(1, []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}))
+
+	f.Fuzz(func(t *testing.T, flags byte, data []byte) {
+		c := newFuzzConn(flags, data)
+		rows, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+		if err == nil {
+			readAll(rows)
+		}
+	})
+}
+
+func FuzzPrepareAndQuery(f *testing.F) {
+	for _, deprecateEOF := range []bool{false, true} {
+		var flags byte
+		if deprecateEOF {
+			flags = 1
+		}
+
+		prepare := fakePrepareOK(1, 1, deprecateEOF)
+		f.Add(flags, prepare, fakeBinaryResult(deprecateEOF))
+		f.Add(flags, prepare, fakePackets(1, fakeOK))
+		f.Add(flags, prepare, fakePackets(1, fakeErr))
+	}
+	f.Add(byte(0), fakePackets(1, fakeErr), []byte(nil))
+	f.Add(byte(0), fakePackets(1, []byte{0x01}), []byte(nil))
+
+	// A binary row that doesn't start with 0x00.
+	f.Add(byte(1), fakePackets(1, []byte{0x00, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
+		fakePackets(1, []byte{1}, fakeColumnDefinition("n", fieldTypeLongLong, binaryCollationID, nil), []byte{0x01, 0x00}, fakeEOFOK))
+
+	f.Fuzz(func(t *testing.T, flags byte, prepare, execute []byte) {
+		c := newFuzzConn(flags, append(bytes.Clone(prepare), execute...))
+		s, err := c.Prepare("SELECT ?")
+		if err != nil {
+			return
+		}
+
+		args := make([]drv.Value, s.NumInput())
+		for i := range args {
+			args[i] = int64(1)
+		}
+		rows, err := s.(*stmt).Query(args)
+		if err == nil {
+			readAll(rows)
+		}
+	})
+}
+
+func FuzzWithoutConnectAttrs(f *testing.F) {
+	flags := flagProtocol41 | flagSecureConn | flagPluginAuth | flagPluginAuthLenEncData | flagConnectWithDB | flagConnectAttrs
+	response := binary.LittleEndian.AppendUint32(nil, uint32(flags))
+	response = append(response, make([]byte, 28)...)
+	response = append(response, "root\x00"...)
+	response = append(response, 2, 0xab, 0xcd)
+	response = append(response, "test\x00mysql_native_password\x00"...)
+	response = append(response, 14, 12)
+	response = append(response, "program_name"...)
+	response = append(response, 0)
+	f.Add(response)
+	f.Add(append(bytes.Clone(response[:len(response)-15]), 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		withoutConnectAttrs(payload)
+		redactHandshakeResponse(payload)
+	})
+}
+
+// The binary protocol's DATETIME values must survive being written and read
+// back, which they didn't when months were written zero-based.
+func FuzzBinaryTime(f *testing.F) {
+	f.Add(int64(0), int64(0))
+	f.Add(time.Date(2024, time.December, 31, 23, 59, 59, 999999000, time.UTC).Unix(), int64(999999000))
+	f.Add(time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC).Unix(), int64(0))
+
+	f.Fuzz(func(t *testing.T, sec, nsec int64) {
+		want := time.Unix(sec, nsec%1e9).UTC().Truncate(time.Microsecond)
+		if want.Year() < 1 || want.Year() > 9999 {
+			return
+		}
+
+		c := newScriptedConn(nil)
+		var value bytes.Buffer
+		_, ftype, err := c.WriteObj(&value, want)
+		if err != nil {
+			t.Fatalf("WriteObj(%v) error: %v", want, err)
+		}
+
+		c = newScriptedConn(fakePackets(0, value.Bytes()))
+		err = c.AdvancePacket()
+		if err != nil {
+			t.Fatalf("AdvancePacket error: %v", err)
+		}
+		o := outputFieldData{field: field{ftype: ftype}}
+		var got drv.Value
+		err = c.ReadValue(&o, &got)
+		if err != nil {
+			t.Fatalf("ReadValue error: %v", err)
+		}
+		if got, ok := got.(time.Time); !ok || !got.Equal(want) {
+			t.Fatalf("wrote %v, read back %v", want, got)
+		}
+	})
+}
@@ -1,11 +1,12 @@
 package gms
 
 import (
+	"context"
 	"database/sql"
 	drv "database/sql/driver"
+	"errors"
 	"fmt"
 	"net"
-	"net/url"
 	"time"
 )
 
@@ -21,68 +22,217 @@ type driver struct {
 }
 
 func (d *driver) Open(dsn string) (drv.Conn, error) {
-	u, err := url.Parse(dsn)
+	connector, err := d.OpenConnector(dsn)
 	if err != nil {
 		return nil, err
 	}
-	params := u.Query()
+	return connector.Connect(context.Background())
+}
+
+func (d *driver) OpenConnector(dsn string) (drv.Connector, error) {
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newConnector(cfg), nil
+}
 
-	var (
-		username = ""
-		password = ""
-		db       = ""
-	)
+type connector struct {
+	cfg *Config
 
-	if u.User != nil {
-		username = u.User.Username()
-		if tmp, ok := u.User.Password(); ok {
-			password = tmp
-		}
+	// The health of each of the hosts in cfg.Addr.
+	hosts *hostList
+}
+
+// NewConnector returns a connector that opens connections with the settings in
+// cfg, for use with sql.OpenDB. cfg is copied, so later changes to it have no
+// effect on the connector.
+func NewConnector(cfg *Config) (drv.Connector, error) {
+	cfg = cfg.Clone()
+	err := cfg.normalize()
+	if err != nil {
+		return nil, err
 	}
+	return newConnector(cfg), nil
+}
 
-	if tmp := params.Get("db"); tmp != "" {
-		db = tmp
+func newConnector(cfg *Config) *connector {
+	return &connector{
+		cfg:   cfg,
+		hosts: newHostList(cfg.addrs(), cfg.HostStrategy, cfg.HostBackoff),
 	}
+}
 
-	var (
-		dialer net.Dialer
-	)
+var errNoPrimary = errors.New("no host is a writable primary")
+
+// Connect opens a connection to one of the hosts in the connector's Config. The
+// hosts are tried in the order given by its HostStrategy, until one of them
+// succeeds. If none do, then the error from the last host is returned.
+func (cn *connector) Connect(ctx context.Context) (drv.Conn, error) {
+	var lastErr error
+
+	for _, addr := range cn.hosts.order() {
+		start := time.Now()
+		c, err := cn.connectHost(ctx, addr)
+		if hooks := cn.cfg.Hooks; hooks != nil {
+			e := HookEvent{Addr: addr, Start: start, Duration: time.Since(start), Err: err}
+			if c != nil {
+				e.BytesSent = c.framer.bytesSent
+				e.BytesReceived = c.framer.bytesReceived
+			}
+			hooks.Connect(ctx, e)
+		}
+		if err == nil {
+			cn.hosts.markUp(addr)
+			return c, nil
+		}
 
-	if tmp, err := time.ParseDuration(params.Get("timeout")); err == nil {
-		dialer.Timeout = tmp
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		// A read-only host is up, it just isn't the one we want.
+		if err != errNoPrimary {
+			cn.hosts.markDown(addr)
+		}
+		lastErr = err
 	}
 
-	var (
-		prot string
-		addr string
-	)
+	return nil, lastErr
+}
 
-	prot = u.Scheme
-	switch prot {
-	case "tcp":
-		addr = u.Host
-	case "unix":
-		addr = u.Path
-	default:
-		return nil, &UnknownProtocolError{prot: prot}
+// connectHost opens a connection to the host at addr.
+func (cn *connector) connectHost(ctx context.Context, addr string) (*conn, error) {
+	cfg := cn.cfg.Clone()
+	cfg.Addr = addr
+
+	// Unless told otherwise, TLS verifies the name of the host we're dialing.
+	if cfg.TLS != nil && cfg.TLS.ServerName == "" && cfg.Net == "tcp" {
+		cfg.TLS.ServerName, _, _ = net.SplitHostPort(addr)
 	}
 
-	nc, err := dialer.Dial(prot, addr)
+	dial := cfg.Dial
+	if dial == nil {
+		dialer := net.Dialer{Timeout: cfg.Timeout}
+		dial = dialer.DialContext
+	}
+	nc, err := dial(ctx, cfg.Net, cfg.Addr)
 	if err != nil {
 		return nil, err
 	}
 
-	c := newConn(nc)
+	if cfg.ReadTimeout != 0 || cfg.WriteTimeout != 0 {
+		nc = &timeoutConn{Conn: nc, readTimeout: cfg.ReadTimeout, writeTimeout: cfg.WriteTimeout}
+	}
+
+	// The handshake is bounded by the context's deadline, if it has one.
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+
+	c := newConn(nc, cfg)
 
 	// We have to complete the handshake before we can use the connection.
-	err = c.handshake(username, password, db)
+	h := c.startHook("", 0)
+	err = c.handshake()
+	h.finish(err, func(e HookEvent) { cfg.Hooks.Handshake(ctx, e) })
 	if err != nil {
+		c.close()
 		return nil, err
 	}
 
+	if cfg.RequirePrimary {
+		readOnly, err := c.isReadOnly(ctx)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		if readOnly {
+			c.close()
+			return nil, errNoPrimary
+		}
+	}
+
+	err = c.initSession(ctx)
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("initializing session: %w", err)
+	}
+
+	// Without a limit in the Config, we go by the server's, so that an
+	// oversized statement fails before it is sent.
+	if cfg.MaxAllowedPacket == 0 {
+		err = c.readMaxAllowedPacket(ctx)
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("reading max_allowed_packet: %w", err)
+		}
+	}
+
+	nc.SetDeadline(time.Time{})
+
 	return c, nil
 }
 
+func (cn *connector) Driver() drv.Driver {
+	return &driver{}
+}
+
+// timeoutConn is a net.Conn that sets a deadline before every read and write.
+// A deadline set on the timeoutConn itself still applies: each read and write
+// gets the earlier of the two.
+type timeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (t *timeoutConn) Read(b []byte) (int, error) {
+	if t.readTimeout != 0 {
+		t.Conn.SetReadDeadline(earliest(t.readDeadline, time.Now().Add(t.readTimeout)))
+	}
+	return t.Conn.Read(b)
+}
+
+func (t *timeoutConn) Write(b []byte) (int, error) {
+	if t.writeTimeout != 0 {
+		t.Conn.SetWriteDeadline(earliest(t.writeDeadline, time.Now().Add(t.writeTimeout)))
+	}
+	return t.Conn.Write(b)
+}
+
+func (t *timeoutConn) SetDeadline(deadline time.Time) error {
+	t.readDeadline, t.writeDeadline = deadline, deadline
+	return t.Conn.SetDeadline(deadline)
+}
+
+func (t *timeoutConn) SetReadDeadline(deadline time.Time) error {
+	t.readDeadline = deadline
+	return t.Conn.SetReadDeadline(deadline)
+}
+
+func (t *timeoutConn) SetWriteDeadline(deadline time.Time) error {
+	t.writeDeadline = deadline
+	return t.Conn.SetWriteDeadline(deadline)
+}
+
+// earliest returns the earlier of deadline and t, where a zero deadline means
+// there is none.
+func earliest(deadline, t time.Time) time.Time {
+	if deadline.IsZero() || t.Before(deadline) {
+		return t
+	}
+	return deadline
+}
+
 func init() {
 	sql.Register("gms", &driver{})
 }
+
+var (
+	_ drv.DriverContext = (*driver)(nil)
+	_ drv.Connector     = (*connector)(nil)
+)
@@ -2,44 +2,108 @@ package gms_test
 
 import (
 	"database/sql"
-	"fmt"
-	_ "github.com/balasanjay/gms"
 	"testing"
 	"time"
+
+	_ "github.com/balasanjay/gms"
+	"github.com/balasanjay/gms/gmstest"
 )
 
 func TestSimple(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.AddResult("CREATE TABLE IF NOT EXISTS test (value BOOL, value2 VARCHAR(20) NOT NULL)", gmstest.Result{})
+	srv.AddResult("INSERT INTO test VALUES (?, ?)", gmstest.Result{AffectedRows: 1})
+	srv.AddResult("SELECT * FROM test WHERE value = ?", gmstest.Result{
+		Columns: []gmstest.Column{{Name: "value", Type: gmstest.TypeTinyInt}, {Name: "value2"}},
+	})
+	srv.AddResult("SELECT value, value2 FROM test", gmstest.Result{
+		Columns: []gmstest.Column{{Name: "value", Type: gmstest.TypeTinyInt}, {Name: "value2"}},
+		Rows:    [][]any{{nil, "hello world"}},
+	})
+
 	before := time.Now()
-	db, err := sql.Open("gms", "tcp://root:@localhost:3306?db=test&timeout=1s")
+	db, err := sql.Open("gms", srv.DSN()+"?db=test&timeout=1s")
 	if err != nil {
-		t.Errorf("sql.Open error: %v", err)
-		return
+		t.Fatalf("sql.Open error: %v", err)
 	}
+	defer db.Close()
 
 	t.Logf("sql.Open took %v", time.Since(before))
 
-	fmt.Printf("Before\n")
 	_, err = db.Exec("CREATE TABLE IF NOT EXISTS test (value BOOL, value2 VARCHAR(20) NOT NULL);")
-	fmt.Printf("After CREATE TABLE, err=%v\n", err)
+	if err != nil {
+		t.Fatalf("CREATE TABLE error: %v", err)
+	}
 	_, err = db.Exec("INSERT INTO test VALUES (?, ?)", nil, "hello world")
-	fmt.Printf("After INSERT, err=%v\n", err)
+	if err != nil {
+		t.Fatalf("INSERT error: %v", err)
+	}
 	_, err = db.Exec("SELECT * FROM test WHERE value = ?;", false)
-	fmt.Printf("After SELECT, err=%v\n", err)
+	if err != nil {
+		t.Fatalf("SELECT error: %v", err)
+	}
 
 	iter, err := db.Query("SELECT value, value2 FROM test")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	defer iter.Close()
+
+	n := 0
 	for iter.Next() {
 		var value *bool
 		var value2 string
 		err = iter.Scan(&value, &value2)
 		if err != nil {
-			t.Errorf("unexpected Scan error: %v", err)
-			break
+			t.Fatalf("unexpected Scan error: %v", err)
 		}
+		if value != nil || value2 != "hello world" {
+			t.Errorf("got row (%v, %q), want (nil, %q)", value, value2, "hello world")
+		}
+		n++
 	}
 	err = iter.Err()
 	if err != nil {
-		fmt.Printf("iteration error: %v\n", err)
+		t.Errorf("iteration error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("got %d rows, want 1", n)
+	}
+}
+
+func TestBinaryTime(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.Handle("SELECT ?", func(args []any) gmstest.Result {
+		return gmstest.Result{
+			Columns: []gmstest.Column{{Name: "at", Type: gmstest.TypeDateTime}},
+			Rows:    [][]any{args},
+		}
+	})
+
+	db, err := sql.Open("gms", srv.DSN())
+	if err != nil {
+		t.Fatalf("sql.Open error: %v", err)
 	}
+	defer db.Close()
 
-	iter.Close()
+	// The argument and the result are both sent in the binary protocol.
+	want := time.Date(2024, time.December, 31, 23, 59, 58, 0, time.UTC)
+	var got time.Time
+	err = db.QueryRow("SELECT ?", want).Scan(&got)
+	if err != nil {
+		t.Fatalf("QueryRow error: %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	statements := srv.Statements()
+	last := statements[len(statements)-1]
+	if len(last.Args) != 1 || !last.Args[0].(time.Time).Equal(want) {
+		t.Errorf("server got arguments %v, want [%v]", last.Args, want)
+	}
 }
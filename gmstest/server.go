@@ -0,0 +1,486 @@
+// Package gmstest provides an in-process MySQL server for tests. It speaks
+// enough of the protocol for the gms driver, and other clients, to connect,
+// authenticate with mysql_native_password, run queries and prepared
+// statements, and read back result sets and errors that the test scripted:
+//
+//	srv := gmstest.NewServer()
+//	defer srv.Close()
+//
+//	srv.AddResult("SELECT name FROM users", gmstest.Result{
+//		Columns: []gmstest.Column{{Name: "name"}},
+//		Rows:    [][]any{{"alice"}, {"bob"}},
+//	})
+//	srv.AddError("DROP TABLE users", &gmstest.Error{Code: 1142, Message: "DROP command denied"})
+//
+//	db, err := sql.Open("gms", srv.DSN())
+//
+// The server doesn't parse SQL. A query is answered with the result scripted
+// for its exact text, after leading and trailing whitespace and semicolons are
+// removed, or by the function given to HandleDefault, and with an error if
+// there is neither. LOAD DATA LOCAL INFILE statements are the exception: the
+// server asks the client for the file first, and answers with an OK packet if
+// no result was scripted.
+//
+// Optional protocol features are turned on by setting the server's fields
+// between NewUnstartedServer and Start:
+//
+//	srv := gmstest.NewUnstartedServer()
+//	srv.SessionTrack = true
+//	srv.Start()
+package gmstest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/balasanjay/gms/server"
+)
+
+// ColumnType is the SQL type of a column in a result set.
+type ColumnType = server.ColumnType
+
+const (
+	TypeTinyInt   = server.TypeTinyInt
+	TypeSmallInt  = server.TypeSmallInt
+	TypeInt       = server.TypeInt
+	TypeBigInt    = server.TypeBigInt
+	TypeFloat     = server.TypeFloat
+	TypeDouble    = server.TypeDouble
+	TypeDecimal   = server.TypeDecimal
+	TypeVarChar   = server.TypeVarChar
+	TypeBlob      = server.TypeBlob
+	TypeDate      = server.TypeDate
+	TypeDateTime  = server.TypeDateTime
+	TypeTimestamp = server.TypeTimestamp
+)
+
+// Column describes a column of a result set. If its Type is empty, it is
+// inferred from the column's first non-nil value.
+type Column = server.Column
+
+// Result is the response to a query. It is a result set if it has columns,
+// and an OK packet reporting AffectedRows and LastInsertID if not. If Err is
+// set, it is sent instead; an *Error is sent as it is, and any other error
+// with code 1105 (ER_UNKNOWN_ERROR).
+type Result struct {
+	Columns []Column
+
+	// The rows of the result set. Values may be of any type that
+	// driver.DefaultParameterConverter accepts, or nil for NULL.
+	Rows [][]any
+
+	AffectedRows uint64
+	LastInsertID uint64
+
+	// The number of warnings reported.
+	Warnings uint16
+
+	// The changes to the session state reported, if the server supports
+	// SessionTrack.
+	Session *SessionState
+
+	Err error
+}
+
+// Error is an error sent by the server.
+type Error = server.Error
+
+// SessionState describes changes to the state of a connection's session.
+type SessionState = server.SessionState
+
+// Indicator is an argument of an execution of COM_STMT_BULK_EXECUTE that
+// stands in for a parameter's value.
+type Indicator = server.Indicator
+
+const (
+	Default = server.Default
+	Ignore  = server.Ignore
+)
+
+// Statement is a query received by the server.
+type Statement struct {
+	Query string
+
+	// The arguments of a prepared statement, converted to int64, uint64,
+	// float64, string, []byte, time.Time or nil. Executions with
+	// COM_STMT_BULK_EXECUTE may also have Indicators.
+	Args []any
+
+	// Whether the statement was run with COM_STMT_EXECUTE, or
+	// COM_STMT_BULK_EXECUTE, rather than COM_QUERY.
+	Prepared bool
+
+	// The query attributes sent with the statement, of the same types as
+	// Args, or nil if there were none.
+	Attributes map[string]any
+
+	// The contents of the file sent for a LOAD DATA LOCAL INFILE.
+	Infile []byte
+}
+
+// MaxAllowedPacket is the value the server reports for @@max_allowed_packet.
+const MaxAllowedPacket = 64 << 20
+
+// A Server is a MySQL server listening on a loopback address, or serving
+// connections handed to it, in the same process.
+type Server struct {
+	// The address the server listens on, as host:port.
+	Addr string
+
+	// The version the server claims to be.
+	Version string
+
+	// Optional protocol features, as described by server.Listener. They may
+	// be changed between NewUnstartedServer and Start, which turns on
+	// DeprecateEOF.
+	DeprecateEOF    bool
+	SessionTrack    bool
+	QueryAttributes bool
+	Compress        bool
+	MariaDB         bool
+
+	l *server.Listener
+
+	mu             sync.Mutex
+	users          map[string]string
+	handlers       map[string]func(args []any) Result
+	defaultHandler func(query string, args []any) Result
+	statements     []Statement
+	numConns       int
+	connectAttrs   map[string]string
+}
+
+// NewServer starts a server listening on a loopback address. It panics if it
+// can't listen. The caller should call Close when done.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a server listening on a loopback address, which
+// doesn't accept connections until Start is called. It panics if it can't
+// listen. The caller should call Close when done.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		Version:      "8.0.36-gmstest",
+		DeprecateEOF: true,
+		handlers:     make(map[string]func(args []any) Result),
+	}
+
+	l, err := server.Listen("tcp", "127.0.0.1:0", handler{s})
+	if err != nil {
+		panic(fmt.Sprintf("gmstest: failed to listen on a port: %v", err))
+	}
+	l.Authenticate = s.authenticate
+	l.Connected = s.connected
+
+	s.Addr = l.Addr().String()
+	s.l = l
+	return s
+}
+
+// Start starts accepting connections.
+func (s *Server) Start() {
+	s.l.Version = s.Version
+	s.l.DeprecateEOF = s.DeprecateEOF
+	s.l.SessionTrack = s.SessionTrack
+	s.l.QueryAttributes = s.QueryAttributes
+	s.l.Compress = s.Compress
+	s.l.MariaDB = s.MariaDB
+	go s.l.Serve()
+}
+
+// DSN returns a DSN for the gms driver that connects to the server as root.
+func (s *Server) DSN() string {
+	return "tcp://root:@" + s.Addr
+}
+
+// Close stops the server, closes its connections, and waits for them to
+// finish.
+func (s *Server) Close() {
+	s.l.Close()
+}
+
+// ServeConn serves the client at the other end of nc, in a new goroutine, and
+// closes nc when the client disconnects or the server is closed.
+func (s *Server) ServeConn(nc net.Conn) {
+	go s.l.ServeConn(nc)
+}
+
+// Pipe returns the client end of an in-memory connection to the server, made
+// with net.Pipe.
+func (s *Server) Pipe() net.Conn {
+	client, serverEnd := net.Pipe()
+	s.ServeConn(serverEnd)
+	return client
+}
+
+// AddUser adds a user that may log in with password. Until a user is added,
+// any credentials are accepted.
+func (s *Server) AddUser(name, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users == nil {
+		s.users = make(map[string]string)
+	}
+	s.users[name] = password
+}
+
+// Handle answers query with the result of fn, which is called with the
+// arguments of each execution of a prepared statement, and with nil for a
+// COM_QUERY. When the query is prepared, fn is also called with nil
+// arguments, to find out the columns of its result set, which must not change
+// from one execution to the next.
+//
+// Handle replaces any result scripted for query earlier.
+func (s *Server) Handle(query string, fn func(args []any) Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[normalizeQuery(query)] = fn
+}
+
+// HandleDefault answers the queries that nothing was scripted for, and that
+// the server doesn't answer itself, with the result of fn. It is called with
+// the query, as well as the arguments that Handle's functions are called with.
+func (s *Server) HandleDefault(fn func(query string, args []any) Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.defaultHandler = fn
+}
+
+// AddResult answers query with result.
+func (s *Server) AddResult(query string, result Result) {
+	s.Handle(query, func([]any) Result { return result })
+}
+
+// AddError answers query with err.
+func (s *Server) AddError(query string, err *Error) {
+	s.AddResult(query, Result{Err: err})
+}
+
+// Statements returns the queries the server has received, in order, and
+// forgets them.
+func (s *Server) Statements() []Statement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statements := s.statements
+	s.statements = nil
+	return statements
+}
+
+// Conns returns the number of clients that have logged in.
+func (s *Server) Conns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.numConns
+}
+
+// ConnectAttrs returns the connection attributes sent by the last client that
+// logged in.
+func (s *Server) ConnectAttrs() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connectAttrs
+}
+
+// connected records a client that has logged in.
+func (s *Server) connected(c *server.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.numConns++
+	s.connectAttrs = c.Attributes()
+}
+
+// authenticate reports whether user may log in.
+func (s *Server) authenticate(user string, check func(password string) bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users == nil {
+		return true
+	}
+
+	password, ok := s.users[user]
+	return ok && check(password)
+}
+
+// handler returns the function that answers query, or nil if there is none.
+func (s *Server) handler(query string) func(args []any) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn := s.handlers[query]
+	if fn == nil {
+		fn = builtinHandlers[strings.ToUpper(query)]
+	}
+	if fn == nil && s.defaultHandler != nil {
+		defaultHandler := s.defaultHandler
+		fn = func(args []any) Result { return defaultHandler(query, args) }
+	}
+	return fn
+}
+
+// record appends stmt to the statements the server has received.
+func (s *Server) record(stmt Statement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statements = append(s.statements, stmt)
+}
+
+// result returns the result of query, with args, or an error if nothing was
+// scripted for it.
+func (s *Server) result(c *server.Conn, query string, args []any) (*server.Result, error) {
+	fn := s.handler(query)
+	if fn == nil {
+		return nil, noResultErr(query)
+	}
+
+	result := fn(args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+
+	// Transactions are only tracked for the status flags.
+	switch strings.ToUpper(query) {
+	case "START TRANSACTION", "BEGIN":
+		c.SetInTransaction(true)
+	case "COMMIT", "ROLLBACK":
+		c.SetInTransaction(false)
+	}
+
+	return &server.Result{
+		Columns:      result.Columns,
+		Rows:         result.Rows,
+		AffectedRows: result.AffectedRows,
+		LastInsertID: result.LastInsertID,
+		Warnings:     result.Warnings,
+		Session:      result.Session,
+	}, nil
+}
+
+// noResultErr returns the error sent for a query nothing was scripted for.
+func noResultErr(query string) error {
+	msg := fmt.Sprintf("gmstest: no result scripted for query %q", query)
+	return &Error{Code: 1064, State: "42000", Message: msg}
+}
+
+// handler answers commands with the results scripted on a Server.
+type handler struct {
+	s *Server
+}
+
+func (h handler) Query(c *server.Conn, query string) (*server.Result, error) {
+	query = normalizeQuery(query)
+	stmt := Statement{Query: query, Attributes: c.QueryAttributes()}
+
+	name, isInfile := localInfileName(query)
+	if isInfile {
+		contents, err := c.ReadLocalInfile(name)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Infile = contents
+	}
+
+	h.s.record(stmt)
+	if isInfile && h.s.handler(query) == nil {
+		return &server.Result{}, nil
+	}
+	return h.s.result(c, query, nil)
+}
+
+func (h handler) Prepare(c *server.Conn, query string) (int, []Column, error) {
+	query = normalizeQuery(query)
+	fn := h.s.handler(query)
+	if fn == nil {
+		return 0, nil, noResultErr(query)
+	}
+
+	// Errors are left for the executions to report, apart from those meant
+	// for the server to send.
+	result := fn(nil)
+	if err, ok := result.Err.(*Error); ok {
+		return 0, nil, err
+	}
+	return server.CountParams(query), server.ResolveColumns(result.Columns, result.Rows), nil
+}
+
+func (h handler) Execute(c *server.Conn, stmt *server.Stmt, args []any) (*server.Result, error) {
+	query := normalizeQuery(stmt.Query)
+	h.s.record(Statement{Query: query, Args: args, Prepared: true, Attributes: c.QueryAttributes()})
+	return h.s.result(c, query, args)
+}
+
+func (h handler) Close(c *server.Conn, stmt *server.Stmt) {}
+
+func (h handler) InitDB(c *server.Conn, db string) error {
+	return nil
+}
+
+func (h handler) Ping(c *server.Conn) error {
+	return nil
+}
+
+// builtinHandlers answer the queries that clients commonly send without being
+// asked to, unless the test scripts a result of its own.
+var builtinHandlers = map[string]func(args []any) Result{
+	"SELECT @@MAX_ALLOWED_PACKET": func([]any) Result {
+		return Result{
+			Columns: []Column{{Name: "@@max_allowed_packet", Type: TypeBigInt}},
+			Rows:    [][]any{{int64(MaxAllowedPacket)}},
+		}
+	},
+	"SELECT @@GLOBAL.READ_ONLY, @@GLOBAL.SUPER_READ_ONLY": func([]any) Result {
+		return Result{
+			Columns: []Column{
+				{Name: "@@global.read_only", Type: TypeBigInt},
+				{Name: "@@global.super_read_only", Type: TypeBigInt},
+			},
+			Rows: [][]any{{int64(0), int64(0)}},
+		}
+	},
+	"START TRANSACTION": okHandler,
+	"BEGIN":             okHandler,
+	"COMMIT":            okHandler,
+	"ROLLBACK":          okHandler,
+}
+
+func okHandler([]any) Result {
+	return Result{}
+}
+
+// localInfileName returns the name of the file in a LOAD DATA LOCAL INFILE
+// statement, and whether query is one.
+func localInfileName(query string) (string, bool) {
+	const prefix = "LOAD DATA LOCAL INFILE "
+	if len(query) <= len(prefix) || !strings.EqualFold(query[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	rest := strings.TrimLeft(query[len(prefix):], " ")
+	if rest == "" || (rest[0] != '\'' && rest[0] != '"') {
+		return "", false
+	}
+	end := strings.IndexByte(rest[1:], rest[0])
+	if end < 0 {
+		return "", false
+	}
+	return rest[1 : 1+end], true
+}
+
+// normalizeQuery returns query without leading and trailing whitespace and
+// semicolons.
+func normalizeQuery(query string) string {
+	return strings.Trim(query, " \t\r\n;")
+}
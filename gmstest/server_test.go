@@ -0,0 +1,255 @@
+package gmstest_test
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/balasanjay/gms"
+	"github.com/balasanjay/gms/gmstest"
+)
+
+func openDB(t *testing.T, dsn string) *sql.DB {
+	db, err := sql.Open("gms", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestQuery(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.AddResult("SELECT id, name, score FROM users", gmstest.Result{
+		Columns: []gmstest.Column{{Name: "id"}, {Name: "name"}, {Name: "score", Type: gmstest.TypeDouble}},
+		Rows: [][]any{
+			{1, "alice", 1.5},
+			{2, nil, nil},
+		},
+	})
+
+	db := openDB(t, srv.DSN())
+	rows, err := db.Query("SELECT id, name, score FROM users;")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	defer rows.Close()
+
+	type user struct {
+		id    int64
+		name  sql.NullString
+		score sql.NullFloat64
+	}
+	var got []user
+	for rows.Next() {
+		var u user
+		err = rows.Scan(&u.id, &u.name, &u.score)
+		if err != nil {
+			t.Fatalf("Scan error: %v", err)
+		}
+		got = append(got, u)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("rows.Err: %v", err)
+	}
+
+	want := []user{
+		{1, sql.NullString{String: "alice", Valid: true}, sql.NullFloat64{Float64: 1.5, Valid: true}},
+		{2, sql.NullString{}, sql.NullFloat64{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %+v, want %+v", got, want)
+	}
+}
+
+func TestPreparedStatement(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.Handle("SELECT name FROM users WHERE id = ?", func(args []any) gmstest.Result {
+		result := gmstest.Result{Columns: []gmstest.Column{{Name: "name", Type: gmstest.TypeVarChar}}}
+		if len(args) == 1 && args[0] == int64(7) {
+			result.Rows = [][]any{{"carol"}}
+		}
+		return result
+	})
+	srv.AddResult("INSERT INTO users (name, active) VALUES (?, ?)", gmstest.Result{AffectedRows: 1, LastInsertID: 8})
+
+	db := openDB(t, srv.DSN())
+
+	var name string
+	err := db.QueryRow("SELECT name FROM users WHERE id = ?", 7).Scan(&name)
+	if err != nil {
+		t.Fatalf("QueryRow error: %v", err)
+	}
+	if name != "carol" {
+		t.Errorf("got name %q, want %q", name, "carol")
+	}
+
+	err = db.QueryRow("SELECT name FROM users WHERE id = ?", 8).Scan(&name)
+	if err != sql.ErrNoRows {
+		t.Errorf("got error %v, want sql.ErrNoRows", err)
+	}
+
+	result, err := db.Exec("INSERT INTO users (name, active) VALUES (?, ?)", "dave", true)
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+	if id, _ := result.LastInsertId(); id != 8 {
+		t.Errorf("got last insert id %d, want 8", id)
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		t.Errorf("got %d rows affected, want 1", n)
+	}
+
+	var got []gmstest.Statement
+	for _, stmt := range srv.Statements() {
+		if stmt.Prepared {
+			got = append(got, stmt)
+		}
+	}
+	want := []gmstest.Statement{
+		{Query: "SELECT name FROM users WHERE id = ?", Args: []any{int64(7)}, Prepared: true},
+		{Query: "SELECT name FROM users WHERE id = ?", Args: []any{int64(8)}, Prepared: true},
+		{Query: "INSERT INTO users (name, active) VALUES (?, ?)", Args: []any{"dave", int64(1)}, Prepared: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %+v, want %+v", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.AddError("DROP TABLE users", &gmstest.Error{Code: 1142, State: "42000", Message: "DROP command denied"})
+	srv.AddResult("SELECT broken", gmstest.Result{Err: errors.New("broken")})
+
+	db := openDB(t, srv.DSN())
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"DROP TABLE users", "DROP command denied"},
+		{"SELECT broken", "broken"},
+		{"SELECT unscripted", "no result scripted"},
+	}
+	for _, test := range tests {
+		_, err := db.Exec(test.query)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Exec(%q) got error %v, want one containing %q", test.query, err, test.want)
+		}
+	}
+
+	// The connection is still usable after an error.
+	err := db.Ping()
+	if err != nil {
+		t.Errorf("Ping error: %v", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser("app", "secret")
+
+	db := openDB(t, "tcp://app:secret@"+srv.Addr)
+	err := db.Ping()
+	if err != nil {
+		t.Errorf("Ping with the right password got error %v", err)
+	}
+
+	db = openDB(t, "tcp://app:wrong@"+srv.Addr)
+	err = db.Ping()
+	if err == nil {
+		t.Errorf("Ping with the wrong password got no error")
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.AddResult("UPDATE users SET active = 0", gmstest.Result{AffectedRows: 3})
+
+	db := openDB(t, srv.DSN())
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+	_, err = tx.Exec("UPDATE users SET active = 0")
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit error: %v", err)
+	}
+
+	var got []string
+	for _, stmt := range srv.Statements() {
+		got = append(got, stmt.Query)
+	}
+	want := []string{"SELECT @@max_allowed_packet", "START TRANSACTION", "UPDATE users SET active = 0", "COMMIT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+}
+
+func TestHandleDefault(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	srv.AddResult("DELETE FROM users", gmstest.Result{AffectedRows: 5})
+	srv.HandleDefault(func(query string, args []any) gmstest.Result {
+		return gmstest.Result{AffectedRows: uint64(len(query))}
+	})
+
+	db := openDB(t, srv.DSN())
+	tests := []struct {
+		query string
+		want  int64
+	}{
+		{"DELETE FROM users", 5},
+		{"DELETE FROM t", int64(len("DELETE FROM t"))},
+	}
+	for _, test := range tests {
+		result, err := db.Exec(test.query)
+		if err != nil {
+			t.Fatalf("Exec(%q) error: %v", test.query, err)
+		}
+		if n, _ := result.RowsAffected(); n != test.want {
+			t.Errorf("Exec(%q) affected %d rows, want %d", test.query, n, test.want)
+		}
+	}
+}
+
+func TestLocalInfile(t *testing.T) {
+	srv := gmstest.NewServer()
+	defer srv.Close()
+
+	gms.RegisterReaderHandler("users", func() io.Reader { return strings.NewReader("1,alice\n2,bob\n") })
+	defer gms.DeregisterReaderHandler("users")
+
+	db := openDB(t, srv.DSN()+"?allowLocalInfile=true")
+	_, err := db.Exec("LOAD DATA LOCAL INFILE 'Reader::users' INTO TABLE users")
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+
+	statements := srv.Statements()
+	last := statements[len(statements)-1]
+	if got, want := string(last.Infile), "1,alice\n2,bob\n"; got != want {
+		t.Errorf("got file %q, want %q", got, want)
+	}
+	if srv.Conns() != 1 {
+		t.Errorf("got %d connections, want 1", srv.Conns())
+	}
+}
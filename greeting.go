@@ -0,0 +1,159 @@
+package gms
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ServerInfo describes the server at the other end of a connection, as it
+// announced itself in its greeting when the connection was opened. It can be
+// retrieved through sql.Conn.Raw:
+//
+//	err := sqlConn.Raw(func(driverConn any) error {
+//		info := driverConn.(interface{ ServerInfo() gms.ServerInfo }).ServerInfo()
+//		...
+//	})
+type ServerInfo struct {
+	// The server's version string, such as "8.0.36" or "5.5.5-10.11.6-MariaDB".
+	Version string
+
+	// The kind of server, and its version, as parsed from Version by
+	// ParseServerVersion.
+	Flavor        ServerFlavor
+	ParsedVersion ServerVersion
+
+	// The id of the connection on the server, as returned by CONNECTION_ID().
+	ConnectionID uint32
+
+	// The capability flags that the server supports.
+	Capabilities uint32
+
+	// The MariaDB extended capability flags that the server supports, which
+	// only MariaDB servers send.
+	MariaDBCapabilities uint32
+
+	// The server status flags at the time of the greeting.
+	Status uint16
+
+	// The name of the server's default authentication plugin, if it sent one.
+	AuthPlugin string
+}
+
+// ServerInfo returns the information from the server's greeting.
+func (c *conn) ServerInfo() ServerInfo {
+	return c.serverInfo
+}
+
+// readGreeting reads the greeting that the server sends when a connection is
+// opened, which must use protocol version 10, and records it on the conn. It
+// returns the challenge for authentication.
+func (c *conn) readGreeting() ([]byte, error) {
+	err := c.AdvancePacket()
+	if err != nil {
+		return nil, err
+	}
+
+	// First, we have the protocol version.
+	err = readExactly(c, c.scratch[:1])
+	if err != nil {
+		return nil, err
+	}
+	if c.scratch[0] == 0xff {
+		// The server refused the connection, for example because it has too
+		// many connections already.
+		return nil, c.ErrorFromErrPacket()
+	} else if c.scratch[0] != 0xa {
+		return nil, fmt.Errorf("Unexpected protocol version: %x", c.scratch[0])
+	}
+
+	// Next, we have the server version as a NULL-terminated string.
+	c.serverInfo.Version, err = c.readNullTerminated()
+	if err != nil {
+		return nil, err
+	}
+	c.serverInfo.Flavor, c.serverInfo.ParsedVersion = ParseServerVersion(c.serverInfo.Version)
+
+	// Next, we have the connection id as a uint32, the first 8 bytes of the
+	// challenge, a one-byte pad, and the lower 2 bytes of the capability
+	// flags.
+	err = readExactly(c, c.scratch[:15])
+	if err != nil {
+		return nil, err
+	}
+	c.serverInfo.ConnectionID = binary.LittleEndian.Uint32(c.scratch[0:4])
+	challenge := append([]byte(nil), c.scratch[4:12]...)
+	c.serverFlags = connectionFlag(binary.LittleEndian.Uint16(c.scratch[13:15]))
+
+	if c.serverFlags&flagProtocol41 == 0 {
+		return nil, errors.New("Server does not support 4.1 wire protocol")
+	}
+
+	// Servers that predate the 4.1 protocol stop here, but we've already
+	// ruled those out.
+	if c.framer.Remaining() == 0 {
+		return nil, errors.New("server greeting is truncated")
+	}
+
+	// Next, we have the server's default character set, which we skip since
+	// we send our own, the status flags, the upper 2 bytes of the capability
+	// flags, the length of the challenge, and 10 reserved bytes, the last 4
+	// of which are MariaDB's extended capability flags.
+	err = readExactly(c, c.scratch[:16])
+	if err != nil {
+		return nil, err
+	}
+	c.status = serverStatus(binary.LittleEndian.Uint16(c.scratch[1:3]))
+	c.serverFlags |= connectionFlag(binary.LittleEndian.Uint16(c.scratch[3:5])) << 16
+	challengeLen := int(c.scratch[5])
+	if c.serverFlags&flagLongPassword == 0 {
+		c.serverMariaDBFlags = mariadbFlag(binary.LittleEndian.Uint32(c.scratch[12:16]))
+	}
+
+	// Next, we have the rest of the challenge, which is at least 13 bytes,
+	// the last of which is a NUL.
+	if c.serverFlags&flagSecureConn != 0 {
+		restLen := challengeLen - len(challenge)
+		if restLen < 13 {
+			restLen = 13
+		}
+
+		err = readExactly(c, c.scratch[:restLen])
+		if err != nil {
+			return nil, err
+		}
+		challenge = append(challenge, c.scratch[:restLen-1]...)
+	}
+
+	// Finally, we have the name of the authentication plugin. Some servers
+	// leave out its NUL terminator, so we read the rest of the packet.
+	if c.serverFlags&flagPluginAuth != 0 {
+		c.reuseBuf.Reset()
+		_, err = c.reuseBuf.ReadFrom(c)
+		if err != nil {
+			return nil, err
+		}
+		c.serverInfo.AuthPlugin = strings.TrimRight(c.reuseBuf.String(), "\x00")
+	}
+
+	c.serverInfo.Capabilities = uint32(c.serverFlags)
+	c.serverInfo.MariaDBCapabilities = uint32(c.serverMariaDBFlags)
+	c.serverInfo.Status = uint16(c.status)
+	return challenge, nil
+}
+
+// readNullTerminated reads a NUL-terminated string from the current packet.
+func (c *conn) readNullTerminated() (string, error) {
+	c.reuseBuf.Reset()
+	for {
+		err := readExactly(c, c.scratch[:1])
+		if err != nil {
+			return "", err
+		}
+		if c.scratch[0] == 0 {
+			return c.reuseBuf.String(), nil
+		}
+		c.reuseBuf.WriteByte(c.scratch[0])
+	}
+}
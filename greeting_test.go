@@ -0,0 +1,58 @@
+package gms
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+func TestServerInfo(t *testing.T) {
+	s := newTestServer(t)
+
+	sqlConn := openTestConn(t, s.Addr, nil)
+
+	var info ServerInfo
+	err := sqlConn.Raw(func(driverConn any) error {
+		info = driverConn.(interface{ ServerInfo() ServerInfo }).ServerInfo()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Raw error: %v", err)
+	}
+
+	want := ServerInfo{
+		Version:       "8.0.36-gmstest",
+		Flavor:        FlavorMySQL,
+		ParsedVersion: ServerVersion{8, 0, 36},
+		ConnectionID:  1,
+		Capabilities: uint32(flagLongPassword | flagConnectWithDB | flagLocalFiles | flagProtocol41 | flagTransactions |
+			flagSecureConn | flagPluginAuth | flagConnectAttrs | flagPluginAuthLenEncData | flagDeprecateEOF),
+		Status:     uint16(statusAutocommit),
+		AuthPlugin: "mysql_native_password",
+	}
+	if info != want {
+		t.Errorf("ServerInfo() = %+v, want %+v", info, want)
+	}
+}
+
+func TestScramblePassword(t *testing.T) {
+	challenge := []byte("abcdefghijklmnopqrst")
+	password := "secret"
+
+	// The server stores SHA1(SHA1(password)), and checks a response by
+	// recovering SHA1(password) from it.
+	stage1 := sha1.Sum([]byte(password))
+	stored := sha1.Sum(stage1[:])
+
+	response := wire.ScramblePassword(challenge, password)
+
+	mask := sha1.Sum(append(append([]byte(nil), challenge...), stored[:]...))
+	for i := range mask {
+		mask[i] ^= response[i]
+	}
+	if got := sha1.Sum(mask[:]); !bytes.Equal(got[:], stored[:]) {
+		t.Errorf("ScramblePassword(%q, %q) = %x, which the server would reject", challenge, password, response)
+	}
+}
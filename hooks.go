@@ -0,0 +1,155 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"time"
+)
+
+// Hooks is told about the operations on the connections of a Config, when
+// they finish, so that they can be counted, timed, or turned into spans. Each
+// method is called from the goroutine that performed the operation, which
+// waits for it to return. Embed NoopHooks to implement only some of them.
+//
+// The ctx passed to a method is the one the operation was started with, or
+// context.Background() for operations that aren't given one, such as Commit
+// and Close.
+type Hooks interface {
+	// Connect is called after connecting to a host, or failing to, for each
+	// host that is tried. It covers dialing, the handshake, and setting up
+	// the session.
+	Connect(ctx context.Context, e HookEvent)
+
+	// Handshake is called after the handshake with a host, including any TLS
+	// handshake and authentication, has succeeded or failed.
+	Handshake(ctx context.Context, e HookEvent)
+
+	// Prepare is called after a statement is prepared.
+	Prepare(ctx context.Context, e HookEvent)
+
+	// Execute is called after a query, or a prepared statement, is executed.
+	// For a query that returns rows, it covers the time until the first row
+	// may be read, and RowsDone is called once the rows have been read.
+	Execute(ctx context.Context, e HookEvent)
+
+	// RowsDone is called after the rows of a query have all been read, or
+	// they have been closed, or reading them failed. Its event covers the
+	// time from when Execute was called until then.
+	RowsDone(ctx context.Context, e HookEvent)
+
+	// Begin, Commit and Rollback are called after a transaction is started,
+	// committed, and rolled back.
+	Begin(ctx context.Context, e HookEvent)
+	Commit(ctx context.Context, e HookEvent)
+	Rollback(ctx context.Context, e HookEvent)
+
+	// Close is called after a connection is closed.
+	Close(ctx context.Context, e HookEvent)
+}
+
+// HookEvent describes an operation that has finished.
+type HookEvent struct {
+	// The address of the host the connection is to.
+	Addr string
+
+	// The SQL text of the query or statement, if there is one, and the
+	// number of arguments it was executed with.
+	Query   string
+	NumArgs int
+
+	// When the operation started, and how long it took.
+	Start    time.Time
+	Duration time.Duration
+
+	// For Execute, the number of rows affected, if known. For RowsDone, the
+	// number of rows read.
+	Rows int64
+
+	// The number of bytes of packets sent and received during the
+	// operation, before any compression or encryption.
+	BytesSent     int64
+	BytesReceived int64
+
+	// The error the operation failed with, or nil.
+	Err error
+}
+
+// NoopHooks is a Hooks whose methods do nothing.
+type NoopHooks struct{}
+
+func (NoopHooks) Connect(ctx context.Context, e HookEvent)   {}
+func (NoopHooks) Handshake(ctx context.Context, e HookEvent) {}
+func (NoopHooks) Prepare(ctx context.Context, e HookEvent)   {}
+func (NoopHooks) Execute(ctx context.Context, e HookEvent)   {}
+func (NoopHooks) RowsDone(ctx context.Context, e HookEvent)  {}
+func (NoopHooks) Begin(ctx context.Context, e HookEvent)     {}
+func (NoopHooks) Commit(ctx context.Context, e HookEvent)    {}
+func (NoopHooks) Rollback(ctx context.Context, e HookEvent)  {}
+func (NoopHooks) Close(ctx context.Context, e HookEvent)     {}
+
+var _ Hooks = NoopHooks{}
+
+// hookSpan is an operation on a connection that Hooks are to be told about
+// when it finishes.
+type hookSpan struct {
+	c     *conn
+	event HookEvent
+
+	// The byte counts of the connection when the operation started.
+	sent, received int64
+}
+
+// startHook starts an operation running query with numArgs arguments, or
+// returns nil if the connection has no Hooks.
+func (c *conn) startHook(query string, numArgs int) *hookSpan {
+	if c.cfg.Hooks == nil {
+		return nil
+	}
+	return &hookSpan{
+		c:        c,
+		event:    HookEvent{Addr: c.cfg.Addr, Query: query, NumArgs: numArgs, Start: time.Now()},
+		sent:     c.framer.bytesSent,
+		received: c.framer.bytesReceived,
+	}
+}
+
+// finish completes the event of the operation, which failed with err, and
+// passes it to fn. It does nothing if h is nil.
+func (h *hookSpan) finish(err error, fn func(HookEvent)) {
+	if h == nil {
+		return
+	}
+	h.event.Duration = time.Since(h.event.Start)
+	h.event.BytesSent = h.c.framer.bytesSent - h.sent
+	h.event.BytesReceived = h.c.framer.bytesReceived - h.received
+	h.event.Err = err
+	fn(h.event)
+}
+
+// finishExec completes an Execute event for an operation that returned result
+// and err.
+func (h *hookSpan) finishExec(ctx context.Context, result drv.Result, err error) {
+	if h == nil || err == drv.ErrSkip {
+		return
+	}
+	if result != nil {
+		if n, err := result.RowsAffected(); err == nil {
+			h.event.Rows = n
+		}
+	}
+	h.finish(err, func(e HookEvent) { h.c.cfg.Hooks.Execute(ctx, e) })
+}
+
+// finishQuery completes an Execute event for a query that returned rows and
+// err, and sets rows up to report a RowsDone event when they are done.
+func (h *hookSpan) finishQuery(ctx context.Context, rows drv.Rows, err error) {
+	if h == nil || err == drv.ErrSkip {
+		return
+	}
+	h.finish(err, func(e HookEvent) { h.c.cfg.Hooks.Execute(ctx, e) })
+
+	if iter, ok := rows.(*resultIter); ok && err == nil {
+		iter.hook = h.c.startHook(h.event.Query, h.event.NumArgs)
+		iter.hookCtx = ctx
+	}
+}
@@ -0,0 +1,162 @@
+package gms
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+// hookRecorder is a Hooks that keeps a line for each event it is told about.
+type hookRecorder struct {
+	mu     sync.Mutex
+	events []HookEvent
+	lines  []string
+}
+
+func (r *hookRecorder) record(kind string, e HookEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, e)
+	r.lines = append(r.lines, fmt.Sprintf("%s %q args=%d rows=%d err=%v", kind, e.Query, e.NumArgs, e.Rows, e.Err))
+}
+
+func (r *hookRecorder) Connect(ctx context.Context, e HookEvent)   { r.record("connect", e) }
+func (r *hookRecorder) Handshake(ctx context.Context, e HookEvent) { r.record("handshake", e) }
+func (r *hookRecorder) Prepare(ctx context.Context, e HookEvent)   { r.record("prepare", e) }
+func (r *hookRecorder) Execute(ctx context.Context, e HookEvent)   { r.record("execute", e) }
+func (r *hookRecorder) RowsDone(ctx context.Context, e HookEvent)  { r.record("rows", e) }
+func (r *hookRecorder) Begin(ctx context.Context, e HookEvent)     { r.record("begin", e) }
+func (r *hookRecorder) Commit(ctx context.Context, e HookEvent)    { r.record("commit", e) }
+func (r *hookRecorder) Rollback(ctx context.Context, e HookEvent)  { r.record("rollback", e) }
+func (r *hookRecorder) Close(ctx context.Context, e HookEvent)     { r.record("close", e) }
+
+func TestHooks(t *testing.T) {
+	s := newTestServer(t)
+	s.AddResult("INSERT INTO t VALUES (?), (?)", gmstest.Result{AffectedRows: 2})
+
+	hooks := &hookRecorder{}
+	db := openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.MaxAllowedPacket = 1 << 20
+		cfg.Hooks = hooks
+	})
+	db.SetMaxOpenConns(1)
+
+	var maxAllowedPacket int64
+	err := db.QueryRow("SELECT @@max_allowed_packet").Scan(&maxAllowedPacket)
+	if err != nil {
+		t.Fatalf("QueryRow error: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+	_, err = tx.Exec("INSERT INTO t VALUES (?), (?)", 1, 2)
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit error: %v", err)
+	}
+	db.Close()
+
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+
+	want := []string{
+		`handshake "" args=0 rows=0 err=<nil>`,
+		`connect "" args=0 rows=0 err=<nil>`,
+		`execute "SELECT @@max_allowed_packet" args=0 rows=0 err=<nil>`,
+		`rows "SELECT @@max_allowed_packet" args=0 rows=1 err=<nil>`,
+		`begin "" args=0 rows=0 err=<nil>`,
+		`prepare "INSERT INTO t VALUES (?), (?)" args=0 rows=0 err=<nil>`,
+		`execute "INSERT INTO t VALUES (?), (?)" args=2 rows=2 err=<nil>`,
+		`commit "" args=0 rows=0 err=<nil>`,
+		`close "" args=0 rows=0 err=<nil>`,
+	}
+	if !reflect.DeepEqual(hooks.lines, want) {
+		t.Fatalf("got events\n%q\nwant\n%q", hooks.lines, want)
+	}
+
+	for i, e := range hooks.events {
+		if e.Addr != s.Addr || e.Start.IsZero() || e.Duration <= 0 {
+			t.Errorf("event %q has address %q, start %v and duration %v", hooks.lines[i], e.Addr, e.Start, e.Duration)
+		}
+	}
+
+	// The handshake and the statements go back and forth with the server.
+	for _, i := range []int{0, 1, 2, 6} {
+		if e := hooks.events[i]; e.BytesSent == 0 || e.BytesReceived == 0 {
+			t.Errorf("event %q sent %d bytes and received %d", hooks.lines[i], e.BytesSent, e.BytesReceived)
+		}
+	}
+}
+
+func TestHooksError(t *testing.T) {
+	hooks := &hookRecorder{}
+	cfg := NewConfig()
+	cfg.Addr = "127.0.0.1:1"
+	cfg.Hooks = hooks
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewConnector error: %v", err)
+	}
+
+	_, err = connector.Connect(context.Background())
+	if err == nil {
+		t.Fatalf("got no error connecting to a closed port")
+	}
+
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if len(hooks.events) != 1 || hooks.events[0].Err != err {
+		t.Errorf("got events %q, want a connect event with error %v", hooks.lines, err)
+	}
+}
+
+func TestHooksSkipSetup(t *testing.T) {
+	s := newTestServer(t)
+	answerAll(s)
+
+	// Connecting checks that the host is a primary, sets up the session,
+	// and reads max_allowed_packet, none of which the hooks are told about.
+	hooks := &hookRecorder{}
+	cfg := NewConfig()
+	cfg.Addr = s.Addr
+	cfg.RequirePrimary = true
+	cfg.Params = map[string]string{"time_zone": "'+00:00'"}
+	cfg.InitSQL = []string{"SET @a = 1"}
+	cfg.Hooks = hooks
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewConnector error: %v", err)
+	}
+
+	c, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	c.Close()
+
+	if got := len(s.Statements()); got != 4 {
+		t.Errorf("server got %d statements while connecting, want 4", got)
+	}
+
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+
+	want := []string{
+		`handshake "" args=0 rows=0 err=<nil>`,
+		`connect "" args=0 rows=0 err=<nil>`,
+		`close "" args=0 rows=0 err=<nil>`,
+	}
+	if !reflect.DeepEqual(hooks.lines, want) {
+		t.Errorf("got events\n%q\nwant\n%q", hooks.lines, want)
+	}
+}
@@ -0,0 +1,141 @@
+package gms
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// HostStrategy determines the order in which the hosts of a multi-host Config
+// are tried when opening a connection.
+type HostStrategy string
+
+const (
+	// HostSequential tries the hosts in the order they are listed, so the
+	// first host is used whenever it is up.
+	HostSequential HostStrategy = "sequential"
+
+	// HostRandom tries the hosts in a random order.
+	HostRandom HostStrategy = "random"
+
+	// HostRoundRobin starts with a different host for each new connection,
+	// cycling through them in the order they are listed.
+	HostRoundRobin HostStrategy = "roundrobin"
+)
+
+const (
+	// The back-off for a host after its first failure, if the Config does
+	// not set one.
+	defaultHostBackoff = time.Second
+
+	// The back-off doubles with each consecutive failure of a host, up to
+	// this limit.
+	maxHostBackoff = time.Minute
+)
+
+// hostList tracks the health of the hosts of a connector. A host that fails to
+// connect is backed off: until its back-off expires, it is only tried after
+// all of the healthy hosts.
+type hostList struct {
+	strategy HostStrategy
+	backoff  time.Duration
+
+	mu    sync.Mutex
+	addrs []string
+	hosts map[string]*hostState
+	next  int
+}
+
+type hostState struct {
+	failures  uint
+	downUntil time.Time
+}
+
+func newHostList(addrs []string, strategy HostStrategy, backoff time.Duration) *hostList {
+	if backoff <= 0 {
+		backoff = defaultHostBackoff
+	}
+
+	h := &hostList{
+		strategy: strategy,
+		backoff:  backoff,
+		addrs:    addrs,
+		hosts:    make(map[string]*hostState, len(addrs)),
+	}
+	for _, addr := range addrs {
+		h.hosts[addr] = &hostState{}
+	}
+	return h
+}
+
+// order returns the addresses of the hosts, in the order they should be tried.
+func (h *hostList) order() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ordered := make([]string, len(h.addrs))
+	switch h.strategy {
+	case HostRandom:
+		for i, j := range rand.Perm(len(h.addrs)) {
+			ordered[i] = h.addrs[j]
+		}
+	case HostRoundRobin:
+		for i := range h.addrs {
+			ordered[i] = h.addrs[(h.next+i)%len(h.addrs)]
+		}
+		h.next = (h.next + 1) % len(h.addrs)
+	default:
+		copy(ordered, h.addrs)
+	}
+
+	// Move the hosts that are backed off to the end, keeping the order
+	// otherwise.
+	now := time.Now()
+	up := ordered[:0:0]
+	var down []string
+	for _, addr := range ordered {
+		if now.Before(h.hosts[addr].downUntil) {
+			down = append(down, addr)
+		} else {
+			up = append(up, addr)
+		}
+	}
+	return append(up, down...)
+}
+
+// markDown records a failure to connect to addr, and backs it off.
+func (h *hostList) markDown(addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.hosts[addr]
+	s.failures++
+
+	// The backoff doubles with each failure, up to the limit. It is compared
+	// with the limit before it is shifted, so that it can't overflow.
+	backoff := maxHostBackoff
+	if h.backoff < maxHostBackoff>>(s.failures-1) {
+		backoff = h.backoff << (s.failures - 1)
+	}
+	s.downUntil = time.Now().Add(backoff)
+}
+
+// markUp records a successful connection to addr.
+func (h *hostList) markUp(addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.hosts[addr]
+	s.failures = 0
+	s.downUntil = time.Time{}
+}
+
+// parseHostStrategy returns the HostStrategy named s.
+func parseHostStrategy(s string) (HostStrategy, error) {
+	switch strategy := HostStrategy(s); strategy {
+	case HostSequential, HostRandom, HostRoundRobin:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown host strategy: %q", s)
+}
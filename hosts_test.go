@@ -0,0 +1,101 @@
+package gms
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func connectAll(t *testing.T, cfg *Config, n int) {
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewConnector error: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		c, err := connector.Connect(context.Background())
+		if err != nil {
+			t.Fatalf("Connect error: %v", err)
+		}
+		c.Close()
+	}
+}
+
+func TestConnectorFailover(t *testing.T) {
+	s := newTestServer(t)
+
+	cfg := NewConfig()
+	cfg.Addr = strings.Join([]string{deadAddr(t), s.Addr}, ",")
+	connectAll(t, cfg, 2)
+
+	if s.Conns() != 2 {
+		t.Errorf("got %d connections to the live host, want 2", s.Conns())
+	}
+}
+
+func TestConnectorRequirePrimary(t *testing.T) {
+	replica := newReadOnlyServer(t)
+	primary := newTestServer(t)
+
+	cfg := NewConfig()
+	cfg.Addr = strings.Join([]string{replica.Addr, primary.Addr}, ",")
+	cfg.RequirePrimary = true
+	connectAll(t, cfg, 2)
+
+	if primary.Conns() != 2 {
+		t.Errorf("got %d connections to the primary, want 2", primary.Conns())
+	}
+
+	cfg.Addr = replica.Addr
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewConnector error: %v", err)
+	}
+	if _, err = connector.Connect(context.Background()); err != errNoPrimary {
+		t.Errorf("Connect to a replica: got error %v, want %v", err, errNoPrimary)
+	}
+}
+
+func TestConnectorRoundRobin(t *testing.T) {
+	s1 := newTestServer(t)
+	s2 := newTestServer(t)
+
+	cfg := NewConfig()
+	cfg.Addr = strings.Join([]string{s1.Addr, s2.Addr}, ",")
+	cfg.HostStrategy = HostRoundRobin
+	connectAll(t, cfg, 4)
+
+	if s1.Conns() != 2 || s2.Conns() != 2 {
+		t.Errorf("got (%d, %d) connections to each host, want (2, 2)", s1.Conns(), s2.Conns())
+	}
+}
+
+func TestHostListBackoff(t *testing.T) {
+	h := newHostList([]string{"h1", "h2", "h3"}, HostSequential, 0)
+
+	h.markDown("h1")
+	if got, want := h.order(), []string{"h2", "h3", "h1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order after h1 is down = %v, want %v", got, want)
+	}
+
+	h.markUp("h1")
+	if got, want := h.order(), []string{"h1", "h2", "h3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order after h1 is up = %v, want %v", got, want)
+	}
+}
+
+func TestHostListBackoffLimit(t *testing.T) {
+	// Shifting a backoff this large overflows long before the failures stop.
+	h := newHostList([]string{"h1"}, HostSequential, 30*time.Second)
+
+	for i := 0; i < 70; i++ {
+		before := time.Now()
+		h.markDown("h1")
+		downUntil := h.hosts["h1"].downUntil
+		if downUntil.Before(before) || downUntil.After(time.Now().Add(maxHostBackoff)) {
+			t.Fatalf("after %d failures, down for %v, want at most %v", i+1, downUntil.Sub(before), maxHostBackoff)
+		}
+	}
+}
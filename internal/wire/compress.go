@@ -0,0 +1,306 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// This file implements the compressed protocol. Once it has been negotiated in
+// the handshake, the stream of regular packets in each direction is chopped up
+// into compressed packets, each with a 7 byte header: the 3 byte length of the
+// compressed payload, a 1 byte sequence id, and the 3 byte length of the
+// payload before compression. A length of 0 before compression means that the
+// payload was too small to be worth compressing, and is sent as-is.
+
+// The capability flags that turn on the compressed protocol, with zlib and
+// with zstd respectively.
+const (
+	FlagCompress        uint32 = 1 << 5
+	FlagZstdCompression uint32 = 1 << 26
+)
+
+const (
+	// The size, in bytes, below which payloads are sent uncompressed when
+	// NewCompressConn is given no other. This is the same threshold that
+	// the MySQL client library uses.
+	defaultCompressMinSize = 50
+
+	// The largest payload of a single compressed packet, before or after
+	// compression.
+	maxCompressedPayload = (1 << 24) - 1
+
+	// The zstd level used when none is given, which is also the server's
+	// default.
+	defaultZstdLevel = 3
+)
+
+// ZstdLevel returns the zstd level to compress at, for the given level, where
+// 0 means the default.
+func ZstdLevel(level int) int {
+	if level == 0 {
+		return defaultZstdLevel
+	}
+	return level
+}
+
+// codec compresses and decompresses the payloads of compressed packets.
+type codec interface {
+	// compress appends the compressed form of src to dst.
+	compress(dst, src []byte) ([]byte, error)
+
+	// decompress appends the decompressed form of src, which is size bytes
+	// long, to dst.
+	decompress(dst, src []byte, size int) ([]byte, error)
+
+	// close releases the codec's resources.
+	close()
+}
+
+// newCodec returns the codec for the compression algorithm named algorithm,
+// compressing at level, or the algorithm's default level if level is 0.
+func newCodec(algorithm string, level int) (codec, error) {
+	switch algorithm {
+	case "zlib":
+		return newZlibCodec(level)
+	case "zstd":
+		return newZstdCodec(level)
+	}
+	return nil, fmt.Errorf("unknown compression algorithm: %q", algorithm)
+}
+
+// CompressConn is an io.ReadWriter that implements the compressed protocol on
+// top of rw. Each call to Write sends its data in as few compressed packets as
+// possible, so it should be called with as much data as is available, which a
+// buffered writer takes care of.
+type CompressConn struct {
+	rw io.ReadWriter
+	br *bufio.Reader
+
+	codec   codec
+	minSize int
+
+	// The sequence id of the next compressed packet, in either direction.
+	// It is separate from the sequence id of the packets inside, but like
+	// them, is reset at the start of every command.
+	Seq uint8
+
+	// The decompressed payload of the current packet being read, of which
+	// readBuf[readPos:] is unread.
+	readBuf []byte
+	readPos int
+
+	// Space for the compressed packet being read or written.
+	packetBuf []byte
+
+	header [7]byte
+}
+
+// NewCompressConn returns a CompressConn on top of rw, which compresses with
+// the algorithm named algorithm, "zlib" or "zstd", at level, or the
+// algorithm's default level if level is 0. Payloads smaller than minSize bytes
+// are sent uncompressed; if minSize is 0, the threshold is 50 bytes. The
+// CompressConn must be closed once it is no longer used.
+func NewCompressConn(rw io.ReadWriter, algorithm string, level, minSize int) (*CompressConn, error) {
+	codec, err := newCodec(algorithm, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if minSize <= 0 {
+		minSize = defaultCompressMinSize
+	}
+
+	return &CompressConn{
+		rw:      rw,
+		br:      bufio.NewReader(rw),
+		codec:   codec,
+		minSize: minSize,
+	}, nil
+}
+
+// Close releases the resources of the CompressConn's codec. It does not close
+// the underlying connection.
+func (cc *CompressConn) Close() error {
+	if cc.codec != nil {
+		cc.codec.close()
+		cc.codec = nil
+	}
+	return nil
+}
+
+func (cc *CompressConn) Read(buf []byte) (int, error) {
+	for cc.readPos >= len(cc.readBuf) {
+		err := cc.readPacket()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(buf, cc.readBuf[cc.readPos:])
+	cc.readPos += n
+	return n, nil
+}
+
+// readPacket reads the next compressed packet into cc.readBuf.
+func (cc *CompressConn) readPacket() error {
+	_, err := io.ReadFull(cc.br, cc.header[:7])
+	if err != nil {
+		return err
+	}
+
+	compressedLen := int(cc.header[0]) | int(cc.header[1])<<8 | int(cc.header[2])<<16
+	seq := cc.header[3]
+	uncompressedLen := int(cc.header[4]) | int(cc.header[5])<<8 | int(cc.header[6])<<16
+
+	if seq != cc.Seq {
+		return fmt.Errorf("Expecting compressed sequence id %v, got %v.", cc.Seq, seq)
+	}
+	cc.Seq++
+
+	if cap(cc.packetBuf) < compressedLen {
+		cc.packetBuf = make([]byte, compressedLen)
+	}
+	payload := cc.packetBuf[:compressedLen]
+	_, err = io.ReadFull(cc.br, payload)
+	if err != nil {
+		return err
+	}
+
+	cc.readPos = 0
+	if uncompressedLen == 0 {
+		cc.readBuf = append(cc.readBuf[:0], payload...)
+		return nil
+	}
+
+	cc.readBuf, err = cc.codec.decompress(cc.readBuf[:0], payload, uncompressedLen)
+	if err != nil {
+		return err
+	}
+	if len(cc.readBuf) != uncompressedLen {
+		return fmt.Errorf("compressed packet decompressed to %d bytes, expected %d", len(cc.readBuf), uncompressedLen)
+	}
+	return nil
+}
+
+func (cc *CompressConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxCompressedPayload {
+			chunk = chunk[:maxCompressedPayload]
+		}
+
+		err := cc.writePacket(chunk)
+		if err != nil {
+			return written, err
+		}
+
+		b = b[len(chunk):]
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// writePacket sends payload as a single compressed packet.
+func (cc *CompressConn) writePacket(payload []byte) error {
+	buf := append(cc.packetBuf[:0], cc.header[:7]...)
+	uncompressedLen := 0
+
+	if len(payload) >= cc.minSize {
+		var err error
+		buf, err = cc.codec.compress(buf, payload)
+		if err != nil {
+			return err
+		}
+		uncompressedLen = len(payload)
+	}
+
+	// Compression can make incompressible data larger, in which case we send
+	// it as-is.
+	if uncompressedLen == 0 || len(buf)-7 >= len(payload) || len(buf)-7 > maxCompressedPayload {
+		buf = append(buf[:7], payload...)
+		uncompressedLen = 0
+	}
+
+	compressedLen := len(buf) - 7
+	buf[0] = byte(compressedLen)
+	buf[1] = byte(compressedLen >> 8)
+	buf[2] = byte(compressedLen >> 16)
+	buf[3] = cc.Seq
+	buf[4] = byte(uncompressedLen)
+	buf[5] = byte(uncompressedLen >> 8)
+	buf[6] = byte(uncompressedLen >> 16)
+	cc.Seq++
+
+	cc.packetBuf = buf
+	_, err := cc.rw.Write(buf)
+	return err
+}
+
+// zlibCodec is the codec for zlib compression.
+type zlibCodec struct {
+	level int
+	buf   bytes.Buffer
+	w     *zlib.Writer
+	r     io.ReadCloser
+}
+
+func newZlibCodec(level int) (*zlibCodec, error) {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+
+	w, err := zlib.NewWriterLevel(nil, level)
+	if err != nil {
+		return nil, err
+	}
+
+	return &zlibCodec{level: level, w: w}, nil
+}
+
+func (z *zlibCodec) compress(dst, src []byte) ([]byte, error) {
+	z.buf.Reset()
+	z.w.Reset(&z.buf)
+
+	_, err := z.w.Write(src)
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(dst, z.buf.Bytes()...), nil
+}
+
+func (z *zlibCodec) decompress(dst, src []byte, size int) ([]byte, error) {
+	var err error
+	if z.r == nil {
+		z.r, err = zlib.NewReader(bytes.NewReader(src))
+	} else {
+		err = z.r.(zlib.Resetter).Reset(bytes.NewReader(src), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	z.buf.Reset()
+	z.buf.Grow(size)
+	_, err = io.Copy(&z.buf, io.LimitReader(z.r, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(dst, z.buf.Bytes()...), nil
+}
+
+func (z *zlibCodec) close() {
+	if z.r != nil {
+		z.r.Close()
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !zstd
+
+package wire
+
+import "errors"
+
+// ZstdAvailable reports whether zstd compression is available. It is only in
+// builds with the zstd build tag, which bring in github.com/klauspost/compress.
+const ZstdAvailable = false
+
+func newZstdCodec(level int) (codec, error) {
+	return nil, errors.New("zstd compression needs the zstd build tag")
+}
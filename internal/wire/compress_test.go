@@ -0,0 +1,104 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCompressConnZlib(t *testing.T) {
+	testCompressConn(t, "zlib")
+}
+
+func TestCompressConnZstd(t *testing.T) {
+	if !ZstdAvailable {
+		t.Skip("built without the zstd build tag")
+	}
+	testCompressConn(t, "zstd")
+}
+
+func testCompressConn(t *testing.T, algorithm string) {
+	var wire bytes.Buffer
+
+	w, err := NewCompressConn(&wire, algorithm, 0, 0)
+	if err != nil {
+		t.Fatalf("NewCompressConn error: %v", err)
+	}
+	defer w.Close()
+
+	payloads := [][]byte{
+		[]byte("short"),
+		bytes.Repeat([]byte("compressible "), 1000),
+		{},
+		bytes.Repeat([]byte{0xff}, defaultCompressMinSize),
+	}
+
+	var want []byte
+	for _, p := range payloads {
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		want = append(want, p...)
+	}
+
+	if wire.Len() >= len(want) {
+		t.Errorf("compressed %d bytes into %d, want fewer", len(want), wire.Len())
+	}
+
+	r, err := NewCompressConn(&wire, algorithm, 0, 0)
+	if err != nil {
+		t.Fatalf("NewCompressConn error: %v", err)
+	}
+	defer r.Close()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatalf("ReadFull error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read back %d bytes, want the %d bytes written", len(got), len(want))
+	}
+}
+
+func TestCompressConnSequence(t *testing.T) {
+	var wire bytes.Buffer
+
+	w, _ := NewCompressConn(&wire, "zlib", 0, 0)
+	w.Seq = 3
+	w.Write([]byte("hello"))
+
+	r, _ := NewCompressConn(&wire, "zlib", 0, 0)
+	if _, err := r.Read(make([]byte, 5)); err == nil {
+		t.Errorf("Read with sequence id 3, want error")
+	}
+}
+
+func TestCompressConnSize(t *testing.T) {
+	algorithms := []string{"zlib"}
+	if ZstdAvailable {
+		algorithms = append(algorithms, "zstd")
+	}
+
+	for _, algorithm := range algorithms {
+		var wire bytes.Buffer
+		w, err := NewCompressConn(&wire, algorithm, 0, 0)
+		if err != nil {
+			t.Fatalf("%s: NewCompressConn error: %v", algorithm, err)
+		}
+		w.Write(bytes.Repeat([]byte("compressible "), 1000))
+		w.Close()
+
+		// The packet claims to hold fewer bytes than it decompresses to.
+		packet := wire.Bytes()
+		packet[4], packet[5], packet[6] = 100, 0, 0
+
+		r, err := NewCompressConn(&wire, algorithm, 0, 0)
+		if err != nil {
+			t.Fatalf("%s: NewCompressConn error: %v", algorithm, err)
+		}
+		if _, err := r.Read(make([]byte, 100)); err == nil {
+			t.Errorf("%s: Read of an oversized packet succeeded, want error", algorithm)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,59 @@
+//go:build zstd
+
+package wire
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdAvailable reports whether zstd compression is available. It is only in
+// builds with the zstd build tag, which bring in github.com/klauspost/compress.
+const ZstdAvailable = true
+
+// zstdCodec is the codec for zstd compression.
+type zstdCodec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstdCodec(level int) (*zstdCodec, error) {
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(ZstdLevel(level))),
+		zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	// No packet holds more than maxCompressedPayload bytes once it is
+	// decompressed, so the decoder needs no more memory than that.
+	dec, err := zstd.NewReader(nil,
+		zstd.WithDecoderMaxMemory(maxCompressedPayload),
+		zstd.WithDecodeAllCapLimit(true),
+		zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		enc.Close()
+		return nil, err
+	}
+
+	return &zstdCodec{enc: enc, dec: dec}, nil
+}
+
+func (z *zstdCodec) compress(dst, src []byte) ([]byte, error) {
+	return z.enc.EncodeAll(src, dst), nil
+}
+
+func (z *zstdCodec) decompress(dst, src []byte, size int) ([]byte, error) {
+	// The decoder is limited to the capacity of dst, which we make room for
+	// exactly size more bytes in, so that a packet can't decompress to more
+	// than it says.
+	n := len(dst)
+	if cap(dst)-n < size {
+		dst = append(dst, make([]byte, size)...)[:n]
+	}
+	return z.dec.DecodeAll(src, dst[:n:n+size])
+}
+
+func (z *zstdCodec) close() {
+	z.enc.Close()
+	z.dec.Close()
+}
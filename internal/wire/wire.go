@@ -0,0 +1,275 @@
+// Package wire holds the parts of the MySQL wire protocol that are shared by
+// the client, in package gms, and the server, in package server: packet
+// framing, the compressed protocol, length-encoded values, and the
+// mysql_native_password scramble.
+package wire
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxPacketSize is the largest payload that fits in a single packet. Larger
+// payloads are split up, and a payload of exactly this size is followed by an
+// empty packet.
+const MaxPacketSize = (1 << 24) - 1
+
+// Capability flags.
+const (
+	FlagLongPassword     uint32 = 1 << 0
+	FlagConnectWithDB    uint32 = 1 << 3
+	FlagLocalFiles       uint32 = 1 << 7
+	FlagProtocol41       uint32 = 1 << 9
+	FlagTransactions     uint32 = 1 << 13
+	FlagSecureConn       uint32 = 1 << 15
+	FlagPluginAuth       uint32 = 1 << 19
+	FlagConnectAttrs     uint32 = 1 << 20
+	FlagPluginAuthLenEnc uint32 = 1 << 21
+	FlagSessionTrack     uint32 = 1 << 23
+	FlagDeprecateEOF     uint32 = 1 << 24
+	FlagQueryAttributes  uint32 = 1 << 27
+)
+
+// MariaDB's extended capability flags, which MariaDB servers send in place of
+// CLIENT_LONG_PASSWORD.
+const (
+	MariaDBFlagStmtBulkOperations uint32 = 1 << 2
+	MariaDBFlagBulkUnitResults    uint32 = 1 << 5
+)
+
+// Commands.
+const (
+	ComQuit            byte = 0x01
+	ComInitDB          byte = 0x02
+	ComQuery           byte = 0x03
+	ComPing            byte = 0x0e
+	ComStmtPrepare     byte = 0x16
+	ComStmtExecute     byte = 0x17
+	ComStmtClose       byte = 0x19
+	ComStmtReset       byte = 0x1a
+	ComResetConnection byte = 0x1f
+	ComStmtBulkExecute byte = 0xfa
+)
+
+// Flags of COM_STMT_EXECUTE and COM_STMT_BULK_EXECUTE.
+const (
+	ParameterCountAvailable byte   = 0x08
+	BulkSendUnitResults     uint16 = 64
+	BulkSendTypes           uint16 = 128
+)
+
+// Status flags.
+const (
+	StatusInTrans             uint16 = 1 << 0
+	StatusAutocommit          uint16 = 1 << 1
+	StatusSessionStateChanged uint16 = 1 << 14
+)
+
+// Types of session state changes.
+const (
+	SessionTrackSystemVariables byte = 0x00
+	SessionTrackSchema          byte = 0x01
+	SessionTrackGTIDs           byte = 0x03
+)
+
+// Types of columns and parameters.
+const (
+	TypeTiny       byte = 0x01
+	TypeShort      byte = 0x02
+	TypeLong       byte = 0x03
+	TypeFloat      byte = 0x04
+	TypeDouble     byte = 0x05
+	TypeNULL       byte = 0x06
+	TypeTimestamp  byte = 0x07
+	TypeLongLong   byte = 0x08
+	TypeInt24      byte = 0x09
+	TypeDate       byte = 0x0a
+	TypeDateTime   byte = 0x0c
+	TypeYear       byte = 0x0d
+	TypeNewDecimal byte = 0xf6
+	TypeBLOB       byte = 0xfc
+	TypeVarString  byte = 0xfd
+)
+
+// Column flags.
+const (
+	ColumnFlagBinary uint16 = 1 << 7
+)
+
+// Collation ids.
+const (
+	CollationUTF8MB4 = 45
+	CollationBinary  = 63
+)
+
+// NativePassword is the name of the mysql_native_password authentication
+// method.
+const NativePassword = "mysql_native_password"
+
+// ErrMalformedPacket is returned when a packet is too short for what it
+// claims to contain.
+var ErrMalformedPacket = errors.New("malformed packet")
+
+// PacketConn reads and writes whole packets on a connection, keeping track of
+// their sequence ids. Writes are buffered until Flush is called.
+type PacketConn struct {
+	br *bufio.Reader
+	bw *bufio.Writer
+
+	// The sequence id of the next packet to be read or written. It is reset
+	// to 0 at the start of each command.
+	Seq uint8
+}
+
+// NewPacketConn returns a PacketConn that reads from r and writes to w.
+func NewPacketConn(r io.Reader, w io.Writer) *PacketConn {
+	return &PacketConn{br: bufio.NewReader(r), bw: bufio.NewWriter(w)}
+}
+
+// ReadPacket reads the next packet, merging packets of the maximum size with
+// the ones that follow them.
+func (pc *PacketConn) ReadPacket() ([]byte, error) {
+	var payload []byte
+	for {
+		var header [4]byte
+		_, err := io.ReadFull(pc.br, header[:])
+		if err != nil {
+			return nil, err
+		}
+
+		size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		if header[3] != pc.Seq {
+			return nil, fmt.Errorf("packet has sequence id %d, want %d", header[3], pc.Seq)
+		}
+		pc.Seq++
+
+		start := len(payload)
+		payload = append(payload, make([]byte, size)...)
+		_, err = io.ReadFull(pc.br, payload[start:])
+		if err != nil {
+			return nil, err
+		}
+
+		if size < MaxPacketSize {
+			return payload, nil
+		}
+	}
+}
+
+// WritePacket writes payload as a packet, split up if it is too large for
+// one.
+func (pc *PacketConn) WritePacket(payload []byte) error {
+	for {
+		size := len(payload)
+		if size > MaxPacketSize {
+			size = MaxPacketSize
+		}
+
+		header := [4]byte{byte(size), byte(size >> 8), byte(size >> 16), pc.Seq}
+		pc.Seq++
+		_, err := pc.bw.Write(header[:])
+		if err != nil {
+			return err
+		}
+		_, err = pc.bw.Write(payload[:size])
+		if err != nil {
+			return err
+		}
+
+		payload = payload[size:]
+		if size < MaxPacketSize {
+			return nil
+		}
+	}
+}
+
+// Flush sends the packets written so far.
+func (pc *PacketConn) Flush() error {
+	return pc.bw.Flush()
+}
+
+// AppendLengthEncodedInt appends n to b as a length-encoded integer.
+func AppendLengthEncodedInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(b, byte(n))
+	case n < (1 << 16):
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < (1 << 24):
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+	b = append(b, 0xfe)
+	return binary.LittleEndian.AppendUint64(b, n)
+}
+
+// AppendLengthEncodedString appends s to b as a length-encoded string.
+func AppendLengthEncodedString(b []byte, s string) []byte {
+	b = AppendLengthEncodedInt(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// ReadLengthEncodedInt returns the length-encoded integer at the start of b,
+// and the rest of b.
+func ReadLengthEncodedInt(b []byte) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, ErrMalformedPacket
+	}
+
+	switch first := b[0]; {
+	case first < 0xfb:
+		return uint64(first), b[1:], nil
+	case first == 0xfc && len(b) >= 3:
+		return uint64(binary.LittleEndian.Uint16(b[1:3])), b[3:], nil
+	case first == 0xfd && len(b) >= 4:
+		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, b[4:], nil
+	case first == 0xfe && len(b) >= 9:
+		return binary.LittleEndian.Uint64(b[1:9]), b[9:], nil
+	}
+	return 0, nil, ErrMalformedPacket
+}
+
+// ReadLengthEncodedString returns the length-encoded string at the start of
+// b, and the rest of b.
+func ReadLengthEncodedString(b []byte) ([]byte, []byte, error) {
+	size, b, err := ReadLengthEncodedInt(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size > uint64(len(b)) {
+		return nil, nil, ErrMalformedPacket
+	}
+	return b[:size], b[size:], nil
+}
+
+// ReadNullTerminated returns the NUL-terminated string at the start of b, and
+// the rest of b.
+func ReadNullTerminated(b []byte) ([]byte, []byte, error) {
+	for i, ch := range b {
+		if ch == 0 {
+			return b[:i], b[i+1:], nil
+		}
+	}
+	return nil, nil, ErrMalformedPacket
+}
+
+// ScramblePassword returns the response to challenge for the
+// mysql_native_password authentication method, which is
+// SHA1(password) XOR SHA1(challenge + SHA1(SHA1(password))).
+func ScramblePassword(challenge []byte, password string) []byte {
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+
+	hash := sha1.New()
+	hash.Write(challenge[:20])
+	hash.Write(stage2[:])
+	scramble := hash.Sum(nil)
+
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
@@ -0,0 +1,222 @@
+package gms
+
+import (
+	drv "database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements client-side interpolation of query arguments, for use
+// with servers and proxies that do not support prepared statements.
+
+var errPlaceholderCount = errors.New("number of placeholders does not match number of arguments")
+
+// unsafeEscapingCharsets holds the collation ids of the character sets in which
+// a multi-byte character may have '\' as a trailing byte. Escaping a string
+// byte-by-byte in one of these character sets is not safe, so we never
+// interpolate arguments when the connection uses one of them.
+var unsafeEscapingCharsets = map[byte]bool{
+	1:   true, // big5_chinese_ci
+	13:  true, // sjis_japanese_ci
+	28:  true, // gbk_chinese_ci
+	84:  true, // big5_bin
+	87:  true, // gbk_bin
+	88:  true, // sjis_bin
+	95:  true, // cp932_japanese_ci
+	96:  true, // cp932_bin
+	248: true, // gb18030_chinese_ci
+	249: true, // gb18030_bin
+	250: true, // gb18030_unicode_520_ci
+}
+
+// canEscape returns true if arguments can be escaped safely in the character
+// set that the server decodes queries in. That is the one set in the handshake,
+// unless Params, InitSQL or a later statement may have changed it, or session
+// tracking reported a change to it.
+func (c *conn) canEscape() bool {
+	if c.cfg.changesCharset() || c.charsetChanged {
+		return false
+	}
+	if c.clientCharset == "" {
+		return !unsafeEscapingCharsets[c.charset]
+	}
+
+	// A character set we don't know is assumed to be unsafe.
+	id, ok := collations[defaultCollations[strings.ToLower(c.clientCharset)]]
+	return ok && id <= 255 && !unsafeEscapingCharsets[byte(id)]
+}
+
+// noteCharsetChange records that query may change the character set that the
+// server decodes queries in, if session tracking won't tell us. Only
+// interpolation cares, so nothing is recorded without it.
+func (c *conn) noteCharsetChange(query string) {
+	if c.cfg.InterpolateParams && c.clientFlags&flagSessionTrack == 0 && mayChangeCharset(query) {
+		c.charsetChanged = true
+	}
+}
+
+// interpolateParams returns query with each '?' placeholder replaced by a SQL
+// literal for the corresponding argument. Placeholders inside quoted strings,
+// quoted identifiers and comments are left alone. If the connection's
+// character set can't be escaped safely, drv.ErrSkip is returned.
+func (c *conn) interpolateParams(query string, args []drv.NamedValue) (string, error) {
+	if !c.canEscape() {
+		return "", drv.ErrSkip
+	}
+
+	noBackslashEscapes := c.status&statusNoBackslashEscapes != 0
+
+	buf := make([]byte, 0, len(query)+16*len(args))
+	argIdx := 0
+
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := skipQuoted(query, i, noBackslashEscapes || ch == '`')
+			buf = append(buf, query[i:end]...)
+			i = end - 1
+		case ch == '#' || (ch == '-' && strings.HasPrefix(query[i:], "-- ")):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			buf = append(buf, query[i:i+end]...)
+			i += end - 1
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
+			}
+			buf = append(buf, query[i:i+end]...)
+			i += end - 1
+		case ch == '?':
+			if argIdx >= len(args) {
+				return "", errPlaceholderCount
+			}
+			arg := args[argIdx]
+			argIdx++
+
+			if arg.Name != "" {
+				return "", fmt.Errorf("named arguments are not supported: %q", arg.Name)
+			}
+
+			if t, ok := arg.Value.(time.Time); ok {
+				arg.Value = t.In(c.cfg.Loc)
+			}
+
+			var err error
+			buf, err = appendLiteral(buf, arg.Value, noBackslashEscapes)
+			if err != nil {
+				return "", err
+			}
+		default:
+			buf = append(buf, ch)
+		}
+	}
+
+	if argIdx != len(args) {
+		return "", errPlaceholderCount
+	}
+
+	return string(buf), nil
+}
+
+// skipQuoted returns the index just past the end of the quoted string, or
+// quoted identifier, that starts at query[start]. A doubled quote character
+// does not end the string, and neither does a quote character preceded by a
+// backslash, unless noBackslashEscapes is set.
+func skipQuoted(query string, start int, noBackslashEscapes bool) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if !noBackslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// appendLiteral appends the SQL literal for v to buf. v must be one of the
+// types that WriteObj supports, or nil.
+func appendLiteral(buf []byte, v drv.Value, noBackslashEscapes bool) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "NULL"...), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("can't interpolate %v, it has no SQL literal", v)
+		}
+		return strconv.AppendFloat(buf, v, 'e', -1, 64), nil
+	case bool:
+		if v {
+			return append(buf, '1'), nil
+		}
+		return append(buf, '0'), nil
+	case []byte:
+		// Hexadecimal literals need no escaping, and are always binary strings,
+		// regardless of the connection's character set.
+		buf = append(buf, "X'"...)
+		buf = append(buf, hex.EncodeToString(v)...)
+		return append(buf, '\''), nil
+	case string:
+		buf = append(buf, '\'')
+		buf = appendEscaped(buf, v, noBackslashEscapes)
+		return append(buf, '\''), nil
+	case time.Time:
+		buf = append(buf, '\'')
+		buf = v.AppendFormat(buf, "2006-01-02 15:04:05.999999")
+		return append(buf, '\''), nil
+	}
+
+	return nil, fmt.Errorf("Can't convert type: %T", v)
+}
+
+// appendEscaped appends s to buf, escaped so that it may be placed between
+// single quotes.
+func appendEscaped(buf []byte, s string, noBackslashEscapes bool) []byte {
+	if noBackslashEscapes {
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\'' {
+				buf = append(buf, '\'')
+			}
+			buf = append(buf, s[i])
+		}
+		return buf
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case 0:
+			buf = append(buf, '\\', '0')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\x1a':
+			buf = append(buf, '\\', 'Z')
+		case '\'', '"', '\\':
+			buf = append(buf, '\\', ch)
+		default:
+			buf = append(buf, ch)
+		}
+	}
+	return buf
+}
@@ -0,0 +1,144 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestInterpolateParams(t *testing.T) {
+	c := newConn(nil, NewConfig())
+	c.charset = 33 // utf8_general_ci
+
+	tests := []struct {
+		query string
+		args  []drv.Value
+		want  string
+	}{
+		{"SELECT ?", []drv.Value{nil}, "SELECT NULL"},
+		{"SELECT ?, ?", []drv.Value{int64(-42), true}, "SELECT -42, 1"},
+		{"SELECT ?", []drv.Value{1.5}, "SELECT 1.5e+00"},
+		{"SELECT ?", []drv.Value{[]byte("a'\x00")}, "SELECT X'612700'"},
+		{"SELECT ?", []drv.Value{"it's \\ \"x\"\n"}, `SELECT 'it\'s \\ \"x\"\n'`},
+		{"SELECT ?", []drv.Value{time.Date(2013, 2, 3, 4, 5, 6, 7000, time.UTC)}, "SELECT '2013-02-03 04:05:06.000007'"},
+		{"SELECT '?', `?`, \"\\\"?\", ? -- ?\n", []drv.Value{int64(1)}, "SELECT '?', `?`, \"\\\"?\", 1 -- ?\n"},
+		{"SELECT /* ? */ ? # ?", []drv.Value{int64(1)}, "SELECT /* ? */ 1 # ?"},
+	}
+
+	for _, test := range tests {
+		args := make([]drv.NamedValue, len(test.args))
+		for i := range test.args {
+			args[i] = drv.NamedValue{Ordinal: i + 1, Value: test.args[i]}
+		}
+
+		got, err := c.interpolateParams(test.query, args)
+		if err != nil {
+			t.Errorf("interpolateParams(%q, %v) error: %v", test.query, test.args, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("interpolateParams(%q, %v) = %q, want %q", test.query, test.args, got, test.want)
+		}
+	}
+}
+
+func TestInterpolateParamsNoBackslashEscapes(t *testing.T) {
+	c := newConn(nil, NewConfig())
+	c.charset = 33 // utf8_general_ci
+	c.status |= statusNoBackslashEscapes
+
+	args := []drv.NamedValue{{Ordinal: 1, Value: `it's \`}}
+	got, err := c.interpolateParams(`SELECT '\', ?`, args)
+	if err != nil {
+		t.Fatalf("interpolateParams error: %v", err)
+	}
+	if want := `SELECT '\', 'it''s \'`; got != want {
+		t.Errorf("interpolateParams = %q, want %q", got, want)
+	}
+}
+
+func TestInterpolateParamsErrors(t *testing.T) {
+	c := newConn(nil, NewConfig())
+	c.charset = 33 // utf8_general_ci
+
+	args := []drv.NamedValue{{Ordinal: 1, Value: int64(1)}}
+	if _, err := c.interpolateParams("SELECT ?, ?", args); err != errPlaceholderCount {
+		t.Errorf("too few arguments: got error %v, want %v", err, errPlaceholderCount)
+	}
+	if _, err := c.interpolateParams("SELECT 1", args); err != errPlaceholderCount {
+		t.Errorf("too many arguments: got error %v, want %v", err, errPlaceholderCount)
+	}
+
+	c.charset = 28 // gbk_chinese_ci
+	if _, err := c.interpolateParams("SELECT ?", args); err != drv.ErrSkip {
+		t.Errorf("unsafe charset: got error %v, want %v", err, drv.ErrSkip)
+	}
+}
+
+func TestInterpolateParamsCharsetChange(t *testing.T) {
+	args := []drv.NamedValue{{Ordinal: 1, Value: "\xbf' OR 1=1 -- "}}
+
+	tests := []struct {
+		name    string
+		config  func(cfg *Config)
+		tracked string
+	}{
+		{"Params", func(cfg *Config) { cfg.Params = map[string]string{"character_set_client": "'gbk'"} }, ""},
+		{"SET NAMES", func(cfg *Config) { cfg.InitSQL = []string{"set names sjis"} }, ""},
+		{"SET CHARACTER SET", func(cfg *Config) { cfg.InitSQL = []string{"SET CHARACTER  SET big5"} }, ""},
+		{"tracked", func(cfg *Config) {}, "gbk"},
+		{"tracked unknown", func(cfg *Config) {}, "klingon"},
+	}
+
+	for _, test := range tests {
+		cfg := NewConfig()
+		test.config(cfg)
+		c := newConn(nil, cfg)
+		c.charset = 33 // utf8_general_ci
+		c.clientCharset = test.tracked
+
+		if _, err := c.interpolateParams("SELECT ?", args); err != drv.ErrSkip {
+			t.Errorf("%s: got error %v, want %v", test.name, err, drv.ErrSkip)
+		}
+	}
+
+	c := newConn(nil, NewConfig())
+	c.charset = 28 // gbk_chinese_ci
+	c.clientCharset = "utf8mb4"
+	if _, err := c.interpolateParams("SELECT ?", args); err != nil {
+		t.Errorf("tracked change to utf8mb4: got error %v, want nil", err)
+	}
+}
+
+func TestInterpolateParamsUntrackedCharsetChange(t *testing.T) {
+	ctx := context.Background()
+	args := []drv.NamedValue{{Ordinal: 1, Value: "\xbf' OR 1=1 -- "}}
+
+	for _, tracked := range []bool{false, true} {
+		cfg := NewConfig()
+		cfg.InterpolateParams = true
+		c := newConn(nil, cfg)
+		c.charset = 33 // utf8_general_ci
+		if tracked {
+			c.clientFlags |= flagSessionTrack
+		}
+
+		if _, err := c.queryText(ctx, "SELECT ?", args); err != nil {
+			t.Fatalf("tracked=%v: before SET NAMES: got error %v, want nil", tracked, err)
+		}
+		if _, err := c.queryText(ctx, "SET NAMES sjis", nil); err != nil {
+			t.Fatalf("tracked=%v: SET NAMES: got error %v, want nil", tracked, err)
+		}
+
+		// With session tracking, the OK packet would have told us about
+		// sjis, and canEscape goes by that instead.
+		want := drv.ErrSkip
+		if tracked {
+			want = nil
+		}
+		if _, err := c.queryText(ctx, "SELECT ?", args); err != want {
+			t.Errorf("tracked=%v: after SET NAMES: got error %v, want %v", tracked, err, want)
+		}
+	}
+}
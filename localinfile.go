@@ -0,0 +1,186 @@
+package gms
+
+import (
+	drv "database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// readerHandlerPrefix marks the file names in LOAD DATA LOCAL INFILE statements
+// that refer to reader handlers rather than files.
+const readerHandlerPrefix = "Reader::"
+
+var (
+	localInfileMu  sync.RWMutex
+	localFiles     = map[string]bool{}
+	readerHandlers = map[string]func() io.Reader{}
+)
+
+// RegisterLocalFile allows the file at path to be sent to the server by LOAD
+// DATA LOCAL INFILE statements, on connections opened with AllowLocalInfile.
+// No other files can be sent, since the server chooses what to ask for.
+func RegisterLocalFile(path string) {
+	localInfileMu.Lock()
+	localFiles[filepath.Clean(path)] = true
+	localInfileMu.Unlock()
+}
+
+// DeregisterLocalFile removes path from the files that may be sent to the
+// server.
+func DeregisterLocalFile(path string) {
+	localInfileMu.Lock()
+	delete(localFiles, filepath.Clean(path))
+	localInfileMu.Unlock()
+}
+
+// RegisterReaderHandler registers handler under name, so that LOAD DATA LOCAL
+// INFILE 'Reader::name' statements send the contents of the reader that it
+// returns. The handler is called once per statement, and the reader is closed
+// afterwards if it is an io.Closer.
+func RegisterReaderHandler(name string, handler func() io.Reader) {
+	localInfileMu.Lock()
+	readerHandlers[name] = handler
+	localInfileMu.Unlock()
+}
+
+// DeregisterReaderHandler removes the reader handler registered under name.
+func DeregisterReaderHandler(name string) {
+	localInfileMu.Lock()
+	delete(readerHandlers, name)
+	localInfileMu.Unlock()
+}
+
+// openLocalInfile returns the contents to send for the file that the server
+// asked for, which must have been registered.
+func (c *conn) openLocalInfile(name string) (io.Reader, error) {
+	if !c.cfg.AllowLocalInfile {
+		return nil, fmt.Errorf("server asked for local file %q, but local files are not allowed", name)
+	}
+
+	localInfileMu.RLock()
+	handler := readerHandlers[strings.TrimPrefix(name, readerHandlerPrefix)]
+	allowed := localFiles[filepath.Clean(name)]
+	localInfileMu.RUnlock()
+
+	if strings.HasPrefix(name, readerHandlerPrefix) {
+		if handler == nil {
+			return nil, fmt.Errorf("reader handler %q is not registered", strings.TrimPrefix(name, readerHandlerPrefix))
+		}
+		r := handler()
+		if r == nil {
+			return nil, fmt.Errorf("reader handler %q returned a nil reader", strings.TrimPrefix(name, readerHandlerPrefix))
+		}
+		return r, nil
+	}
+
+	if !allowed {
+		return nil, fmt.Errorf("local file %q is not registered", name)
+	}
+	return os.Open(name)
+}
+
+// sendLocalInfile answers a LOAD DATA LOCAL INFILE request from the server,
+// whose first byte has been read, with the contents of the file it names, and
+// returns the result of the statement. If the file can't be sent, the server
+// is sent an empty file, and the error is returned instead. An error reading
+// the file part of the way through leaves what was sent before it loaded. An
+// error writing to the connection leaves it unusable. If none of the file had
+// been sent, drv.ErrBadConn is returned, so that the statement is retried on
+// another connection. Otherwise, the server may have loaded part of it, so the
+// error is returned as is, and the connection is dropped when it is next reset.
+func (c *conn) sendLocalInfile() (drv.Result, error) {
+	c.reuseBuf.Reset()
+	_, err := c.reuseBuf.ReadFrom(c)
+	if err != nil {
+		return nil, err
+	}
+	name := c.reuseBuf.String()
+
+	r, fileErr := c.openLocalInfile(name)
+	if fileErr == nil {
+		if closer, ok := r.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		var sent int64
+		var writeErr error
+		sent, fileErr, writeErr = c.writeLocalInfile(r)
+		if writeErr != nil {
+			// The write may have failed part of the way through a
+			// packet, after which nothing more can be sent.
+			if sent == 0 {
+				return nil, drv.ErrBadConn
+			}
+			c.bad = true
+			return nil, fmt.Errorf("sending local file %q: %w", name, writeErr)
+		}
+		if fileErr != nil {
+			fileErr = fmt.Errorf("sending local file %q: %w", name, fileErr)
+		}
+	}
+
+	// An empty packet marks the end of the file.
+	c.BeginPacket(0)
+	err = c.EndPacket(FLUSH)
+	if err != nil {
+		c.bad = true
+		return nil, err
+	}
+
+	result, err := c.readExecResult()
+	if fileErr != nil {
+		return nil, fileErr
+	}
+	return result, err
+}
+
+// localInfileChunkSize is the most of a local file that is sent in one packet.
+// The server joins the packets back together, so they needn't be large.
+const localInfileChunkSize = 64 << 10
+
+// localInfileBufs holds the buffers that local files are read into, each
+// localInfileChunkSize bytes long.
+var localInfileBufs = sync.Pool{
+	New: func() any { return new([localInfileChunkSize]byte) },
+}
+
+// writeLocalInfile sends the contents of r to the server, in packets of up to
+// localInfileChunkSize bytes, or the maximum size the server allows if that is
+// smaller. It returns the number of bytes of r that were written, and the error
+// from reading r, if any, separately from the error from writing to the
+// connection.
+func (c *conn) writeLocalInfile(r io.Reader) (sent int64, readErr, writeErr error) {
+	chunk := localInfileBufs.Get().(*[localInfileChunkSize]byte)
+	defer localInfileBufs.Put(chunk)
+
+	buf := chunk[:]
+	if c.maxAllowedPacket > 0 && c.maxAllowedPacket < int64(len(buf)) {
+		buf = buf[:c.maxAllowedPacket]
+	}
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			c.BeginPacket(int64(n))
+			written, writeErr := c.Write(buf[:n])
+			sent += int64(written)
+			if writeErr != nil {
+				return sent, nil, writeErr
+			}
+			writeErr = c.EndPacket(NO_FLUSH)
+			if writeErr != nil {
+				return sent, nil, writeErr
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sent, nil, nil
+		} else if err != nil {
+			return sent, err, nil
+		}
+	}
+}
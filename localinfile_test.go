@@ -0,0 +1,176 @@
+package gms
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	drv "database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocalInfile(t *testing.T) {
+	s := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "rows.csv")
+	err := os.WriteFile(path, []byte("1,a\n2,b\n"), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	RegisterLocalFile(path)
+	defer DeregisterLocalFile(path)
+
+	RegisterReaderHandler("rows", func() io.Reader { return strings.NewReader("3,c\n") })
+	defer DeregisterReaderHandler("rows")
+
+	db := openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.AllowLocalInfile = true
+	})
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	for _, name := range []string{path, "Reader::rows"} {
+		_, err = db.ExecContext(ctx, "LOAD DATA LOCAL INFILE '"+name+"' INTO TABLE t")
+		if err != nil {
+			t.Errorf("LOAD DATA LOCAL INFILE %q error: %v", name, err)
+		}
+	}
+
+	// Files that aren't registered are refused, and the connection remains
+	// usable afterwards.
+	unregistered := filepath.Join(filepath.Dir(path), "other.csv")
+	for _, name := range []string{unregistered, "Reader::other"} {
+		_, err = db.ExecContext(ctx, "LOAD DATA LOCAL INFILE '"+name+"' INTO TABLE t")
+		if err == nil || !strings.Contains(err.Error(), "not registered") {
+			t.Errorf("LOAD DATA LOCAL INFILE %q error = %v, want an error about it not being registered", name, err)
+		}
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		t.Errorf("Ping error: %v", err)
+	}
+
+	var got []string
+	for _, st := range s.Statements() {
+		if strings.HasPrefix(st.Query, "LOAD DATA") {
+			got = append(got, string(st.Infile))
+		}
+	}
+	want := []string{"1,a\n2,b\n", "3,c\n", "", ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestWriteLocalInfileChunks(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxAllowedPacket int64
+		size             int
+		want             []int
+	}{
+		{name: "limited", maxAllowedPacket: 4, size: 10, want: []int{4, 4, 2}},
+		{name: "unlimited", maxAllowedPacket: 0, size: 2*localInfileChunkSize + 1, want: []int{localInfileChunkSize, localInfileChunkSize, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			bw := bufio.NewWriter(&buf)
+			c := &conn{maxAllowedPacket: test.maxAllowedPacket}
+			c.framer = newFramer(nil, bw)
+
+			sent, readErr, writeErr := c.writeLocalInfile(strings.NewReader(strings.Repeat("x", test.size)))
+			if readErr != nil || writeErr != nil {
+				t.Fatalf("writeLocalInfile errors: %v, %v", readErr, writeErr)
+			}
+			if sent != int64(test.size) {
+				t.Errorf("sent %d bytes, want %d", sent, test.size)
+			}
+			bw.Flush()
+
+			var sizes []int
+			for rest := buf.Bytes(); len(rest) >= 4; {
+				size := int(rest[0]) | int(rest[1])<<8 | int(rest[2])<<16
+				sizes = append(sizes, size)
+				rest = rest[4+size:]
+			}
+			if !reflect.DeepEqual(sizes, test.want) {
+				t.Errorf("packet sizes = %v, want %v", sizes, test.want)
+			}
+		})
+	}
+}
+
+// failingConn is a connection whose server sends data, and to which every
+// write fails. It is also an unbuffered writeFlusher.
+type failingConn struct {
+	*bytes.Reader
+}
+
+func (failingConn) Write(p []byte) (int, error) { return 0, errors.New("connection reset") }
+func (failingConn) Flush() error                { return nil }
+func (failingConn) Close() error                { return nil }
+
+func TestSendLocalInfileWriteError(t *testing.T) {
+	RegisterReaderHandler("big", func() io.Reader { return strings.NewReader(strings.Repeat("x", 1<<16)) })
+	defer DeregisterReaderHandler("big")
+
+	tests := []struct {
+		name string
+
+		// If true, writes go straight to the connection, so that the
+		// first one fails before any of the file is sent.
+		unbuffered bool
+
+		wantBadConn bool
+	}{
+		// The file is larger than the write buffer, so the first write
+		// to the connection fails after part of the file was buffered.
+		{name: "part sent", unbuffered: false, wantBadConn: false},
+		{name: "none sent", unbuffered: true, wantBadConn: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.AllowLocalInfile = true
+			nc := failingConn{bytes.NewReader(fakePackets(0, []byte("\xfbReader::big")))}
+			c := newConn(nc, cfg)
+			if test.unbuffered {
+				c.framer = newFramer(c.br, nc)
+			}
+
+			err := c.AdvancePacket()
+			if err != nil {
+				t.Fatalf("AdvancePacket error: %v", err)
+			}
+			err = readExactly(c, c.scratch[:1])
+			if err != nil {
+				t.Fatalf("readExactly error: %v", err)
+			}
+
+			_, err = c.sendLocalInfile()
+			if test.wantBadConn {
+				if err != drv.ErrBadConn {
+					t.Errorf("sendLocalInfile error = %v, want %v", err, drv.ErrBadConn)
+				}
+				return
+			}
+
+			// The server may have loaded part of the file, so the
+			// statement must not be retried.
+			if err == nil || err == drv.ErrBadConn {
+				t.Errorf("sendLocalInfile error = %v, want the write error", err)
+			}
+			err = c.ResetSession(context.Background())
+			if err != drv.ErrBadConn {
+				t.Errorf("ResetSession error = %v, want %v", err, drv.ErrBadConn)
+			}
+		})
+	}
+}
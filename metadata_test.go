@@ -0,0 +1,105 @@
+package gms
+
+import (
+	"bytes"
+	drv "database/sql/driver"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// fakeColumnDefinition returns a column definition packet, with ext as its
+// extended metadata if it is non-nil.
+func fakeColumnDefinition(name string, ftype fieldType, charset byte, ext []byte) []byte {
+	var buf bytes.Buffer
+	for _, str := range []string{"def", "", "", "", name, ""} {
+		buf.WriteByte(byte(len(str)))
+		buf.WriteString(str)
+	}
+	if ext != nil {
+		buf.WriteByte(byte(len(ext)))
+		buf.Write(ext)
+	}
+	buf.Write([]byte{0x0c, charset, 0, 0, 0, 0, 0, byte(ftype), 0, 0, 0, 0, 0})
+	return buf.Bytes()
+}
+
+func TestExtendedMetadata(t *testing.T) {
+	tests := []struct {
+		ext  []byte
+		want string
+	}{
+		{[]byte{1, 4, 'j', 's', 'o', 'n'}, "JSON"},
+		{[]byte{0, 4, 'u', 'u', 'i', 'd'}, "UUID"},
+		{[]byte{0, 5, 'i', 'n', 'e', 't', '6', 1, 3, 'x', 'y', 'z'}, "INET6"},
+		{[]byte{}, "LONGTEXT"},
+	}
+
+	for _, test := range tests {
+		c := newScriptedConn(fakePackets(1, fakeColumnDefinition("c", fieldTypeLongBLOB, 45, test.ext)))
+		c.clientMariaDBFlags = mariadbFlagExtendedMetadata
+		c.framer.nextExpectedSeq = 1
+
+		var f field
+		err := c.ReadFieldDefinition(&f)
+		if err != nil {
+			t.Fatalf("ReadFieldDefinition error: %v", err)
+		}
+		if got := f.typeName(); got != test.want {
+			t.Errorf("typeName() with extended metadata %v = %q, want %q", test.ext, got, test.want)
+		}
+	}
+}
+
+func TestCachedMetadata(t *testing.T) {
+	row := []byte{0x00, 0x00}
+	row = binary.LittleEndian.AppendUint64(row, 42)
+
+	tests := []struct {
+		name    string
+		packets [][]byte
+		want    string
+	}{
+		{
+			name:    "omitted",
+			packets: [][]byte{{1, 0}, row, fakeEOF},
+			want:    "prepared",
+		},
+		{
+			name:    "resent",
+			packets: [][]byte{{1, 1}, fakeColumnDefinition("changed", fieldTypeLongLong, binaryCollationID, nil), fakeEOF, row, fakeEOF},
+			want:    "changed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newScriptedConn(fakePackets(1, test.packets...))
+			c.clientMariaDBFlags = mariadbFlagCacheMetadata
+
+			s := &stmt{c: c, id: 1, outputFields: []outputFieldData{
+				{field: field{ftype: fieldTypeLongLong, name: "prepared", charset: binaryCollationID}},
+			}}
+
+			rows, err := s.Query(nil)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			if got := rows.Columns(); len(got) != 1 || got[0] != test.want {
+				t.Errorf("Columns() = %q, want [%q]", got, test.want)
+			}
+
+			dest := make([]drv.Value, 1)
+			err = rows.Next(dest)
+			if err != nil {
+				t.Fatalf("Next error: %v", err)
+			}
+			if dest[0] != int64(42) {
+				t.Errorf("value = %v, want 42", dest[0])
+			}
+			if err = rows.Next(dest); err != io.EOF {
+				t.Errorf("Next error = %v, want io.EOF", err)
+			}
+		})
+	}
+}
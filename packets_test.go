@@ -0,0 +1,107 @@
+package gms
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// This file holds canned server packets, for tests that feed the client a
+// fixed conversation rather than talking to a gmstest server.
+
+// scriptedConn is a connection to a server that sends a fixed sequence of
+// bytes, and ignores what it is sent.
+type scriptedConn struct {
+	*bytes.Reader
+}
+
+func (scriptedConn) Write(p []byte) (int, error) { return len(p), nil }
+func (scriptedConn) Close() error                { return nil }
+
+// newScriptedConn returns a conn whose server sends data, which is usually made
+// with fakePackets.
+func newScriptedConn(data []byte) *conn {
+	return newConn(scriptedConn{bytes.NewReader(data)}, NewConfig())
+}
+
+// fakePackets returns payloads as a stream of packets, numbered from seq.
+func fakePackets(seq uint8, payloads ...[]byte) []byte {
+	var b []byte
+	for i, payload := range payloads {
+		b = append(b, byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16), seq+uint8(i))
+		b = append(b, payload...)
+	}
+	return b
+}
+
+var fakeEOF = []byte{0xfe, 0x00, 0x00, byte(statusAutocommit), 0x00}
+
+var (
+	fakeOK = []byte{0x00, 0x00, 0x00, byte(statusAutocommit), 0x00, 0x00, 0x00}
+
+	// The OK packet that ends a result set with CLIENT_DEPRECATE_EOF. It
+	// reports a warning.
+	fakeEOFOK = []byte{0xfe, 0x00, 0x00, byte(statusAutocommit), 0x00, 0x01, 0x00}
+)
+
+// fakeSessionOK returns an OK packet that reports gtid, a change to autocommit
+// and a change of schema to "test".
+func fakeSessionOK(gtid string) []byte {
+	lenenc := func(b []byte) []byte {
+		return append([]byte{byte(len(b))}, b...)
+	}
+
+	var state []byte
+	state = append(state, sessionTrackSystemVariables)
+	state = append(state, lenenc(append(lenenc([]byte("autocommit")), lenenc([]byte("ON"))...))...)
+	state = append(state, sessionTrackSchema)
+	state = append(state, lenenc(lenenc([]byte("test")))...)
+	state = append(state, sessionTrackGTIDs)
+	state = append(state, lenenc(append([]byte{0}, lenenc([]byte(gtid))...))...)
+
+	status := statusAutocommit | statusSessionStateChanged
+	packet := []byte{0x00, 0x00, 0x00, byte(status), byte(status >> 8), 0x00, 0x00}
+	packet = append(packet, 0) // The empty status message.
+	return append(packet, lenenc(state)...)
+}
+
+// fakePrepareOK returns the response to a COM_STMT_PREPARE of a statement with
+// numParams parameters and no result set.
+func fakePrepareOK(id uint32, numParams int, deprecateEOF bool) []byte {
+	ok := []byte{0x00, 0, 0, 0, 0, 0x00, 0x00, byte(numParams), byte(numParams >> 8), 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint32(ok[1:5], id)
+	packets := [][]byte{ok}
+
+	for i := 0; i < numParams; i++ {
+		packets = append(packets, fakeColumnDefinition("?", fieldTypeVarString, binaryCollationID, nil))
+	}
+	if numParams > 0 && !deprecateEOF {
+		packets = append(packets, fakeEOF)
+	}
+	return fakePackets(1, packets...)
+}
+
+// fakeResult returns a text result set with a single row of BIGINTs. With
+// deprecateEOF, the column definitions are not followed by an EOF packet, and
+// the rows are followed by an OK packet instead.
+func fakeResult(names, values []string, deprecateEOF bool) []byte {
+	packets := [][]byte{{byte(len(names))}}
+	for _, name := range names {
+		packets = append(packets, fakeColumnDefinition(name, fieldTypeLongLong, binaryCollationID, nil))
+	}
+	if !deprecateEOF {
+		packets = append(packets, fakeEOF)
+	}
+
+	var row []byte
+	for _, value := range values {
+		row = append(row, byte(len(value)))
+		row = append(row, value...)
+	}
+	packets = append(packets, row)
+	if deprecateEOF {
+		packets = append(packets, fakeEOFOK)
+	} else {
+		packets = append(packets, fakeEOF)
+	}
+	return fakePackets(1, packets...)
+}
@@ -0,0 +1,196 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// QueryLogEntry describes a query that was logged, because it was slow or was
+// sampled.
+type QueryLogEntry struct {
+	// The address of the host the query was sent to.
+	Addr string
+
+	// The SQL text of the query or prepared statement, and the number of
+	// arguments it was executed with.
+	Query   string
+	NumArgs int
+
+	// When the query started. Its Duration is the sum of the time spent in
+	// each of its phases: Send, writing the query to the server; Wait,
+	// waiting for the server to respond, up to the first row; and Stream,
+	// reading the rows. The time between reading one row and asking for the
+	// next isn't counted.
+	Start    time.Time
+	Duration time.Duration
+	Send     time.Duration
+	Wait     time.Duration
+	Stream   time.Duration
+
+	// The number of rows read, or for a statement without a result set, the
+	// number of rows affected.
+	Rows int64
+
+	// The number of bytes of packets received for the query, before any
+	// compression or encryption.
+	BytesRead int64
+
+	// Whether the query took at least the Config's SlowQueryThreshold, as
+	// opposed to having been sampled.
+	Slow bool
+
+	// The error the query failed with, or nil.
+	Err error
+}
+
+// A QueryLogger logs the queries picked out by a Config's SlowQueryThreshold
+// and QuerySampleRate. It is called from the goroutine that ran the query,
+// which waits for it to return.
+type QueryLogger interface {
+	LogQuery(ctx context.Context, e QueryLogEntry)
+}
+
+// SlogQueryLogger is a QueryLogger that logs to a slog.Logger.
+type SlogQueryLogger struct {
+	// The logger to log to, and the level to log at. A nil Logger means
+	// slog.Default().
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+// LogQuery implements QueryLogger.
+func (l *SlogQueryLogger) LogQuery(ctx context.Context, e QueryLogEntry) {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if !logger.Enabled(ctx, l.Level) {
+		return
+	}
+
+	msg := "gms: sampled query"
+	if e.Slow {
+		msg = "gms: slow query"
+	}
+
+	attrs := []slog.Attr{
+		slog.String("addr", e.Addr),
+		slog.String("query", e.Query),
+		slog.Int("args", e.NumArgs),
+		slog.Duration("duration", e.Duration),
+		slog.Duration("send", e.Send),
+		slog.Duration("wait", e.Wait),
+		slog.Duration("stream", e.Stream),
+		slog.Int64("rows", e.Rows),
+		slog.Int64("bytes_read", e.BytesRead),
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	logger.LogAttrs(ctx, l.Level, msg, attrs...)
+}
+
+// defaultQueryLogger is used when a Config has no QueryLogger.
+var defaultQueryLogger = &SlogQueryLogger{Level: slog.LevelWarn}
+
+// queryLog times the phases of a query, so that it can be logged if it turns
+// out to be slow, or is sampled.
+type queryLog struct {
+	c     *conn
+	entry QueryLogEntry
+
+	// When the query finished being sent, or zero if it hasn't been.
+	sent time.Time
+
+	// The number of bytes the connection had received when the query
+	// started.
+	received int64
+}
+
+// startQueryLog starts timing a query running query with numArgs arguments,
+// if the connection logs queries.
+func (c *conn) startQueryLog(query string, numArgs int) {
+	c.qlog = nil
+	if c.cfg.SlowQueryThreshold <= 0 && c.cfg.QuerySampleRate <= 0 {
+		return
+	}
+	c.qlog = &queryLog{
+		c:        c,
+		entry:    QueryLogEntry{Addr: c.cfg.Addr, Query: query, NumArgs: numArgs, Start: time.Now()},
+		received: c.framer.bytesReceived,
+	}
+}
+
+// querySent records that the query being timed, if any, has been sent.
+func (c *conn) querySent() {
+	if l := c.qlog; l != nil && l.sent.IsZero() {
+		l.sent = time.Now()
+		l.entry.Send = l.sent.Sub(l.entry.Start)
+	}
+}
+
+// finishExecLog finishes timing a query that returned result and err, and
+// logs it if need be.
+func (c *conn) finishExecLog(ctx context.Context, result drv.Result, err error) {
+	l := c.takeQueryLog()
+	if l == nil || err == drv.ErrSkip {
+		return
+	}
+	if result != nil {
+		if n, err := result.RowsAffected(); err == nil {
+			l.entry.Rows = n
+		}
+	}
+	l.finish(ctx, err)
+}
+
+// finishQueryLog finishes timing the wait for a query that returned rows and
+// err. The rows are timed until they are done, and the query logged then.
+func (c *conn) finishQueryLog(ctx context.Context, rows drv.Rows, err error) {
+	l := c.takeQueryLog()
+	if l == nil || err == drv.ErrSkip {
+		return
+	}
+
+	iter, ok := rows.(*resultIter)
+	if err != nil || !ok {
+		l.finish(ctx, err)
+		return
+	}
+	iter.qlog = l
+	iter.qlogCtx = ctx
+}
+
+// takeQueryLog returns the query being timed, and forgets it, with the time
+// waited for the server counted up to now.
+func (c *conn) takeQueryLog() *queryLog {
+	l := c.qlog
+	c.qlog = nil
+	if l != nil && !l.sent.IsZero() {
+		l.entry.Wait = time.Since(l.sent)
+	}
+	return l
+}
+
+// finish logs the query, which failed with err, if it was slow or is sampled.
+func (l *queryLog) finish(ctx context.Context, err error) {
+	cfg := l.c.cfg
+
+	e := &l.entry
+	e.Duration = e.Send + e.Wait + e.Stream
+	e.BytesRead = l.c.framer.bytesReceived - l.received
+	e.Err = err
+	e.Slow = cfg.SlowQueryThreshold > 0 && e.Duration >= cfg.SlowQueryThreshold
+	if !e.Slow && (cfg.QuerySampleRate <= 0 || rand.Float64() >= cfg.QuerySampleRate) {
+		return
+	}
+
+	logger := cfg.QueryLogger
+	if logger == nil {
+		logger = defaultQueryLogger
+	}
+	logger.LogQuery(ctx, *e)
+}
@@ -0,0 +1,175 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+// queryLogRecorder is a QueryLogger that keeps the entries it is given.
+type queryLogRecorder struct {
+	mu      sync.Mutex
+	entries []QueryLogEntry
+}
+
+func (r *queryLogRecorder) LogQuery(ctx context.Context, e QueryLogEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+}
+
+// find returns the entry for query.
+func (r *queryLogRecorder) find(query string) (QueryLogEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.entries {
+		if e.Query == query {
+			return e, true
+		}
+	}
+	return QueryLogEntry{}, false
+}
+
+// openQueryLogDB returns a database that logs queries to logger, with the
+// given threshold and sample rate.
+func openQueryLogDB(t *testing.T, threshold time.Duration, sampleRate float64, logger QueryLogger) *sql.DB {
+	s := newTestServer(t)
+	s.AddResult("INSERT INTO t VALUES (?), (?)", gmstest.Result{AffectedRows: 2})
+	s.AddResult("DO 1", gmstest.Result{})
+
+	return openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.SlowQueryThreshold = threshold
+		cfg.QuerySampleRate = sampleRate
+		cfg.QueryLogger = logger
+	})
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	logger := &queryLogRecorder{}
+	db := openQueryLogDB(t, time.Nanosecond, 0, logger)
+
+	var maxAllowedPacket int64
+	err := db.QueryRow("SELECT @@max_allowed_packet").Scan(&maxAllowedPacket)
+	if err != nil {
+		t.Fatalf("QueryRow error: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO t VALUES (?), (?)", 1, 2)
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+
+	e, ok := logger.find("SELECT @@max_allowed_packet")
+	if !ok {
+		t.Fatalf("query wasn't logged, got %+v", logger.entries)
+	}
+	if !e.Slow || e.Rows != 1 || e.BytesRead == 0 || e.Err != nil {
+		t.Errorf("got entry %+v, want a slow query with 1 row", e)
+	}
+	if e.Send <= 0 || e.Wait <= 0 || e.Stream <= 0 || e.Duration != e.Send+e.Wait+e.Stream {
+		t.Errorf("got phases send %v, wait %v and stream %v, for a duration of %v", e.Send, e.Wait, e.Stream, e.Duration)
+	}
+
+	e, ok = logger.find("INSERT INTO t VALUES (?), (?)")
+	if !ok {
+		t.Fatalf("prepared statement wasn't logged, got %+v", logger.entries)
+	}
+	if e.NumArgs != 2 || e.Rows != 2 || e.Stream != 0 {
+		t.Errorf("got entry %+v, want 2 arguments, 2 rows affected, and no time streaming rows", e)
+	}
+}
+
+func TestSampledQueryLog(t *testing.T) {
+	t.Run("sampled", func(t *testing.T) {
+		logger := &queryLogRecorder{}
+		db := openQueryLogDB(t, time.Hour, 1, logger)
+
+		_, err := db.Exec("DO 1")
+		if err != nil {
+			t.Fatalf("Exec error: %v", err)
+		}
+		if e, ok := logger.find("DO 1"); !ok || e.Slow {
+			t.Errorf("got entry %+v, want a sampled query", e)
+		}
+	})
+
+	t.Run("not sampled", func(t *testing.T) {
+		logger := &queryLogRecorder{}
+		db := openQueryLogDB(t, time.Hour, 0, logger)
+
+		_, err := db.Exec("DO 1")
+		if err != nil {
+			t.Fatalf("Exec error: %v", err)
+		}
+		if e, ok := logger.find("DO 1"); ok {
+			t.Errorf("got entry %+v for a fast query that wasn't sampled", e)
+		}
+	})
+}
+
+func TestQueryLogSkipsSetup(t *testing.T) {
+	s := newTestServer(t)
+	answerAll(s)
+
+	// Every query is logged, but the ones that set up the connection aren't
+	// the user's, so none of them should be.
+	logger := &queryLogRecorder{}
+	cfg := NewConfig()
+	cfg.Addr = s.Addr
+	cfg.RequirePrimary = true
+	cfg.Params = map[string]string{"time_zone": "'+00:00'"}
+	cfg.InitSQL = []string{"SET @a = 1"}
+	cfg.SlowQueryThreshold = time.Nanosecond
+	cfg.QuerySampleRate = 1
+	cfg.QueryLogger = logger
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewConnector error: %v", err)
+	}
+
+	c, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	c.Close()
+
+	if got := len(s.Statements()); got != 4 {
+		t.Errorf("server got %d statements while connecting, want 4", got)
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.entries) != 0 {
+		t.Errorf("got entries %+v while connecting, want none", logger.entries)
+	}
+}
+
+func TestSlogQueryLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &SlogQueryLogger{Logger: slog.New(slog.NewTextHandler(&buf, nil)), Level: slog.LevelWarn}
+	logger.LogQuery(context.Background(), QueryLogEntry{
+		Query:    "SELECT 1",
+		Duration: 3 * time.Second,
+		Send:     time.Second,
+		Wait:     time.Second,
+		Stream:   time.Second,
+		Rows:     1,
+		Slow:     true,
+		Err:      errors.New("oops"),
+	})
+
+	got := buf.String()
+	for _, want := range []string{"level=WARN", `msg="gms: slow query"`, `query="SELECT 1"`, "duration=3s", "wait=1s", "rows=1", "error=oops"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log output %q doesn't contain %q", got, want)
+		}
+	}
+}
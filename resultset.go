@@ -1,49 +1,153 @@
 package gms
 
 import (
+	"context"
 	drv "database/sql/driver"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"strconv"
+	"time"
 )
 
 type resultIter struct {
-	atEOF bool
-	c     *conn
-	s     *stmt
+	atEOF  bool
+	c      *conn
+	fields []outputFieldData
+
+	// If true, rows are in the text protocol (the response to COM_QUERY),
+	// rather than the binary protocol (the response to COM_STMT_EXECUTE).
+	text bool
+
+	// The number of warnings reported at the end of the result set.
+	warnings uint16
+
+	// If non-nil, the Config's Hooks are told when the rows are done, with
+	// hookCtx, and the number of rows read.
+	hook     *hookSpan
+	hookCtx  context.Context
+	rowsRead int64
+
+	// If non-nil, the query is being timed for the slow query log, which
+	// is given qlogCtx.
+	qlog    *queryLog
+	qlogCtx context.Context
 }
 
 func (r *resultIter) Close() error {
 	if r.atEOF {
+		r.finish(nil)
 		return nil
 	}
 
+	start := time.Now()
 	err := r.c.SkipPacketsUntilEOFPacket()
+	if r.qlog != nil {
+		r.qlog.entry.Stream += time.Since(start)
+	}
+	r.finish(err)
 	if err != nil {
 		return err
 	}
+	r.warnings = r.c.warnings
 
 	r.atEOF = true
 	r.c = nil
-	r.s = nil
+	r.fields = nil
 	return nil
 }
 
 func (r *resultIter) Columns() []string {
-	ret := make([]string, 0, len(r.s.outputFields))
-	for i := range r.s.outputFields {
-		ret = append(ret, r.s.outputFields[i].name)
+	ret := make([]string, 0, len(r.fields))
+	for i := range r.fields {
+		ret = append(ret, r.fields[i].name)
 	}
 	return ret
 }
 
+// ColumnTypeDatabaseTypeName implements drv.RowsColumnTypeDatabaseTypeName.
+// Binary string columns are reported as BINARY, VARBINARY or BLOB types, and
+// text columns as CHAR, VARCHAR or TEXT types.
+func (r *resultIter) ColumnTypeDatabaseTypeName(index int) string {
+	return r.fields[index].typeName()
+}
+
+// Warnings returns the number of warnings the query raised, which the server
+// reports at the end of the result set. It is zero until Next has returned
+// io.EOF, or the rows have been closed. Like ColumnCollation, it is available
+// through sql.Conn.Raw.
+func (r *resultIter) Warnings() uint16 {
+	return r.warnings
+}
+
+// ColumnCollation returns the name of the collation of the column at index,
+// such as "utf8mb4_general_ci", or "binary" for binary strings and non-string
+// columns. A collation that isn't known by name is returned as its id. It is
+// available through sql.Conn.Raw, on the drv.Rows returned by the driver
+// connection's QueryContext.
+func (r *resultIter) ColumnCollation(index int) string {
+	id := r.fields[index].charset
+	if name, ok := collationNames[id]; ok {
+		return name
+	}
+	return strconv.Itoa(int(id))
+}
+
+// ColumnCharset returns the name of the character set of the column at index,
+// such as "utf8mb4", or "binary" for binary strings and non-string columns.
+// It is empty if the column's collation isn't known.
+func (r *resultIter) ColumnCharset(index int) string {
+	name, ok := collationNames[r.fields[index].charset]
+	if !ok {
+		return ""
+	}
+	return collationCharset(name)
+}
+
+// finish tells the Config's Hooks, and its slow query log, that the rows are
+// done, with err, if it hasn't already.
+func (r *resultIter) finish(err error) {
+	if h := r.hook; h != nil {
+		r.hook = nil
+		h.event.Rows = r.rowsRead
+		h.finish(err, func(e HookEvent) { h.c.cfg.Hooks.RowsDone(r.hookCtx, e) })
+	}
+
+	if l := r.qlog; l != nil {
+		r.qlog = nil
+		l.entry.Rows = r.rowsRead
+		l.finish(r.qlogCtx, err)
+	}
+}
+
 func (r *resultIter) Next(dest []drv.Value) error {
+	if r.hook == nil && r.qlog == nil {
+		return r.next(dest)
+	}
+
+	// The time spent reading rows is counted, but not the time in between.
+	start := time.Now()
+	err := r.next(dest)
+	if r.qlog != nil {
+		r.qlog.entry.Stream += time.Since(start)
+	}
+
+	if err == nil {
+		r.rowsRead++
+	} else if err == io.EOF {
+		r.finish(nil)
+	} else {
+		r.finish(err)
+	}
+	return err
+}
+
+func (r *resultIter) next(dest []drv.Value) error {
 	if r.atEOF {
 		return io.EOF
 	}
 
 	c := r.c
-	s := r.s
+	fields := r.fields
 
 	err := c.AdvancePacket()
 	if err != nil {
@@ -57,25 +161,49 @@ func (r *resultIter) Next(dest []drv.Value) error {
 
 	// If we read an EOF packet, then record that fact, skip the rest of the
 	// packet, and return io.EOF.
-	if c.scratch[0] == 0xfe && c.lr.N <= 4 {
+	if c.atEOFPacket() {
 		r.atEOF = true
-		err = c.AdvanceToEOF()
+		err = c.readEOFStatus()
 		if err != nil {
 			return err
 		}
+		r.warnings = c.warnings
 		return io.EOF
 	}
 
+	// The server can fail partway through a result set. No row starts with
+	// 0xff, in either protocol.
+	if c.scratch[0] == 0xff {
+		r.atEOF = true
+		return c.ErrorFromErrPacket()
+	}
+
+	c.reuseBuf.Reset()
+	if r.text {
+		for i := range fields {
+			if i > 0 {
+				err = readExactly(c, c.scratch[:1])
+				if err != nil {
+					return err
+				}
+			}
+			err = c.ReadTextValue(&fields[i], c.scratch[0], &dest[i])
+			if err != nil {
+				return err
+			}
+		}
+		return r.finishRow(dest)
+	}
+
 	if c.scratch[0] != 0x00 {
-		// TODO(sanjay): fix this panic
-		panic("unexpected first byte of binary result set row")
+		return fmt.Errorf("malformed packet: binary result set row starts with 0x%02x", c.scratch[0])
 	}
 
 	// Otherwise, we've reached a data packet. First, deal with the NULL bitmap.
 	curBitmapByte := -1
 	const offset = 2
-	for i := range s.outputFields {
-		f := &s.outputFields[i]
+	for i := range fields {
+		f := &fields[i]
 		thisBitmapByte := (i + offset) / 8
 		if thisBitmapByte != curBitmapByte {
 			err = readExactly(c, c.scratch[:1])
@@ -89,9 +217,8 @@ func (r *resultIter) Next(dest []drv.Value) error {
 		f.isNull = (c.scratch[0] & byte(1<<bitIdx)) != 0
 	}
 
-	c.reuseBuf.Reset()
-	for i := range s.outputFields {
-		f := &s.outputFields[i]
+	for i := range fields {
+		f := &fields[i]
 		if f.isNull {
 			dest[i] = nil
 			continue
@@ -102,10 +229,19 @@ func (r *resultIter) Next(dest []drv.Value) error {
 		}
 	}
 
+	return r.finishRow(dest)
+}
+
+// finishRow points the values of dest that were read into the connection's
+// reusable buffer at their data, and checks that the row's packet has been
+// exhausted.
+func (r *resultIter) finishRow(dest []drv.Value) error {
+	c := r.c
+
 	bufStartIdx := 0
 	buf := c.reuseBuf.Bytes()
-	for i := range s.outputFields {
-		f := &s.outputFields[i]
+	for i := range r.fields {
+		f := &r.fields[i]
 		if f.isNull {
 			continue
 		}
@@ -117,13 +253,19 @@ func (r *resultIter) Next(dest []drv.Value) error {
 	}
 
 	// Sanity-check that we've exhausted a packet
-	if c.lr.N != 0 {
-		_, err = io.Copy(ioutil.Discard, c)
-		if err != nil {
-			return err
-		}
-		return fmt.Errorf("data packet has %d more bytes than expected", c.lr.N)
+	n, err := c.Read(c.scratch[:1])
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
 	}
 
-	return nil
+	extra := int64(n) + c.framer.Remaining()
+	err = c.AdvanceToEOF()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("data packet has at least %d more bytes than expected", extra)
 }
+
+var _ drv.RowsColumnTypeDatabaseTypeName = (*resultIter)(nil)
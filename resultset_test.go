@@ -0,0 +1,122 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+func TestDeprecateEOF(t *testing.T) {
+	for _, deprecateEOF := range []bool{false, true} {
+		s := gmstest.NewUnstartedServer()
+		s.DeprecateEOF = deprecateEOF
+		startTestServer(t, s)
+		s.AddResult("SELECT @@read_only, @@super_read_only", gmstest.Result{
+			Columns:  []gmstest.Column{{Name: "@@read_only"}, {Name: "@@super_read_only"}},
+			Rows:     [][]any{{1, 1}},
+			Warnings: 1,
+		})
+		s.AddResult("SELECT @@read_only", gmstest.Result{
+			Columns:  []gmstest.Column{{Name: "@@read_only"}},
+			Rows:     [][]any{{1}},
+			Warnings: 1,
+		})
+		s.AddResult("INSERT INTO t VALUES (1)", gmstest.Result{AffectedRows: 1, Warnings: 2})
+
+		sqlConn := openTestConn(t, s.Addr, nil)
+
+		var readOnly, superReadOnly int64
+		err := sqlConn.QueryRowContext(context.Background(), "SELECT @@read_only, @@super_read_only").Scan(&readOnly, &superReadOnly)
+		if err != nil {
+			t.Fatalf("deprecateEOF=%v: QueryRow error: %v", deprecateEOF, err)
+		}
+		if readOnly != 1 || superReadOnly != 1 {
+			t.Errorf("deprecateEOF=%v: got (%d, %d), want (1, 1)", deprecateEOF, readOnly, superReadOnly)
+		}
+
+		// A result set is skipped over by Exec.
+		_, err = sqlConn.ExecContext(context.Background(), "SELECT @@read_only")
+		if err != nil {
+			t.Fatalf("deprecateEOF=%v: Exec error: %v", deprecateEOF, err)
+		}
+
+		err = sqlConn.Raw(func(driverConn any) error {
+			c := driverConn.(*conn)
+			if got := c.clientFlags&flagDeprecateEOF != 0; got != deprecateEOF {
+				t.Errorf("deprecateEOF=%v: negotiated CLIENT_DEPRECATE_EOF = %v", deprecateEOF, got)
+			}
+			rows, err := c.QueryContext(context.Background(), "SELECT @@read_only", nil)
+			if err != nil {
+				t.Fatalf("deprecateEOF=%v: QueryContext error: %v", deprecateEOF, err)
+			}
+			dest := make([]drv.Value, 1)
+			for rows.Next(dest) == nil {
+			}
+			if got := rows.(interface{ Warnings() uint16 }).Warnings(); got != 1 {
+				t.Errorf("deprecateEOF=%v: got %d warnings at the end of the rows, want 1", deprecateEOF, got)
+			}
+			rows.Close()
+
+			result, err := c.ExecContext(context.Background(), "INSERT INTO t VALUES (1)", nil)
+			if err != nil {
+				t.Fatalf("deprecateEOF=%v: ExecContext error: %v", deprecateEOF, err)
+			}
+			if got := result.(interface{ Warnings() uint16 }).Warnings(); got != 2 {
+				t.Errorf("deprecateEOF=%v: got %d warnings from the OK packet, want 2", deprecateEOF, got)
+			}
+
+			if c.status&statusAutocommit == 0 {
+				t.Errorf("deprecateEOF=%v: status %#x is missing autocommit", deprecateEOF, c.status)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Raw error: %v", err)
+		}
+	}
+}
+
+func TestColumnCollation(t *testing.T) {
+	s := newTestServer(t)
+	s.AddResult("SELECT name, data, n FROM t", gmstest.Result{
+		Columns: []gmstest.Column{
+			{Name: "name", Type: gmstest.TypeVarChar},
+			{Name: "data", Type: gmstest.TypeBlob},
+			{Name: "n", Type: gmstest.TypeBigInt},
+		},
+	})
+
+	sqlConn := openTestConn(t, s.Addr, nil)
+
+	err := sqlConn.Raw(func(driverConn any) error {
+		rows, err := driverConn.(drv.QueryerContext).QueryContext(context.Background(), "SELECT name, data, n FROM t", nil)
+		if err != nil {
+			t.Fatalf("QueryContext error: %v", err)
+		}
+		defer rows.Close()
+
+		columns := rows.(interface {
+			ColumnCollation(index int) string
+			ColumnCharset(index int) string
+		})
+		want := []struct{ collation, charset string }{
+			{"utf8mb4_general_ci", "utf8mb4"},
+			{"binary", "binary"},
+			{"binary", "binary"},
+		}
+		for i, w := range want {
+			if got := columns.ColumnCollation(i); got != w.collation {
+				t.Errorf("ColumnCollation(%d) = %q, want %q", i, got, w.collation)
+			}
+			if got := columns.ColumnCharset(i); got != w.charset {
+				t.Errorf("ColumnCharset(%d) = %q, want %q", i, got, w.charset)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Raw error: %v", err)
+	}
+}
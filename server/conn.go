@@ -0,0 +1,840 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// baseFlags are the capabilities every server supports. The optional ones are
+// added by Listener.flags.
+const baseFlags = wire.FlagLongPassword |
+	wire.FlagConnectWithDB |
+	wire.FlagLocalFiles |
+	wire.FlagProtocol41 |
+	wire.FlagTransactions |
+	wire.FlagSecureConn |
+	wire.FlagPluginAuth |
+	wire.FlagPluginAuthLenEnc |
+	wire.FlagConnectAttrs
+
+// errMalformed is sent in answer to a command that can't be parsed.
+var errMalformed = &Error{Code: 1835, Message: "Malformed communication packet"}
+
+// Conn is a connection from a client.
+type Conn struct {
+	l  *Listener
+	nc net.Conn
+	id uint32
+	pc *wire.PacketConn
+
+	// If non-nil, the compressed protocol is in use, and pc reads and writes
+	// through cc.
+	cc *wire.CompressConn
+
+	// The zstd level the client compresses at, if it asked for zstd.
+	zstdLevel int
+
+	// The capabilities, and MariaDB extended capabilities, the client asked
+	// for, out of those the server supports.
+	clientFlags        uint32
+	clientMariaDBFlags uint32
+
+	status uint16
+	user   string
+	db     string
+	attrs  map[string]string
+
+	// The query attributes sent with the command being run.
+	queryAttrs map[string]any
+
+	// The prepared statements, by id.
+	stmts      map[uint32]*Stmt
+	nextStmtID uint32
+}
+
+func newConn(l *Listener, nc net.Conn, id uint32) *Conn {
+	return &Conn{
+		l:      l,
+		nc:     nc,
+		id:     id,
+		pc:     wire.NewPacketConn(nc, nc),
+		status: wire.StatusAutocommit,
+		stmts:  make(map[uint32]*Stmt),
+	}
+}
+
+// ID returns the connection's id, which is unique within its Listener.
+func (c *Conn) ID() uint32 {
+	return c.id
+}
+
+// User returns the name of the user the client logged in as.
+func (c *Conn) User() string {
+	return c.user
+}
+
+// DB returns the connection's default database, which is empty if there is
+// none.
+func (c *Conn) DB() string {
+	return c.db
+}
+
+// Attributes returns the connection attributes the client sent.
+func (c *Conn) Attributes() map[string]string {
+	return c.attrs
+}
+
+// RemoteAddr returns the client's address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.nc.RemoteAddr()
+}
+
+// QueryAttributes returns the query attributes sent with the command being
+// run, by name, or nil if there are none. Their values are of the same types
+// as the arguments of Handler.Execute.
+func (c *Conn) QueryAttributes() map[string]any {
+	return c.queryAttrs
+}
+
+// ReadLocalInfile asks the client to send the file name, as LOAD DATA LOCAL
+// INFILE does, and returns its contents. It may only be called by
+// Handler.Query. A client that refuses to send the file sends an empty one.
+func (c *Conn) ReadLocalInfile(name string) ([]byte, error) {
+	if c.clientFlags&wire.FlagLocalFiles == 0 {
+		return nil, &Error{Code: 3948, State: "42000", Message: "Loading local data is disabled; this must be enabled on both the client and server sides"}
+	}
+
+	err := c.writePackets(append([]byte{0xfb}, name...))
+	if err != nil {
+		return nil, err
+	}
+
+	// The file is sent in packets, which end with an empty one.
+	var contents []byte
+	for {
+		payload, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) == 0 {
+			return contents, nil
+		}
+		contents = append(contents, payload...)
+	}
+}
+
+// SetInTransaction sets whether the status flags sent to the client say that
+// a transaction is in progress.
+func (c *Conn) SetInTransaction(inTrans bool) {
+	if inTrans {
+		c.status |= wire.StatusInTrans
+	} else {
+		c.status &^= wire.StatusInTrans
+	}
+}
+
+// serve runs the handshake, and then the client's commands, until the client
+// quits or the connection fails.
+func (c *Conn) serve() {
+	defer c.closeStmts()
+
+	err := c.handshake()
+	if err != nil {
+		return
+	}
+	if c.cc != nil {
+		defer c.cc.Close()
+	}
+	if c.l.Connected != nil {
+		c.l.Connected(c)
+	}
+
+	for {
+		c.pc.Seq = 0
+		if c.cc != nil {
+			c.cc.Seq = 0
+		}
+		payload, err := c.readPacket()
+		if err != nil || len(payload) == 0 || payload[0] == wire.ComQuit {
+			return
+		}
+
+		err = c.dispatch(payload[0], payload[1:])
+		if err != nil {
+			return
+		}
+	}
+}
+
+// closeStmts closes all the connection's prepared statements.
+func (c *Conn) closeStmts() {
+	for id, stmt := range c.stmts {
+		c.l.handler.Close(c, stmt)
+		delete(c.stmts, id)
+	}
+}
+
+// readPacket reads the next packet from the client. With the compressed
+// protocol, the reply is numbered starting from the sequence id of the
+// compressed packets, rather than of the packets inside them, as MySQL does.
+func (c *Conn) readPacket() ([]byte, error) {
+	payload, err := c.pc.ReadPacket()
+	if err == nil && c.cc != nil {
+		c.pc.Seq = c.cc.Seq
+	}
+	return payload, err
+}
+
+// writePackets writes each of payloads as a packet, and flushes them.
+func (c *Conn) writePackets(payloads ...[]byte) error {
+	for _, payload := range payloads {
+		err := c.pc.WritePacket(payload)
+		if err != nil {
+			return err
+		}
+	}
+	return c.pc.Flush()
+}
+
+// handshake sends the greeting, and authenticates the client.
+func (c *Conn) handshake() error {
+	var challenge [20]byte
+	_, err := rand.Read(challenge[:])
+	if err != nil {
+		return err
+	}
+	// The challenge is sent NUL-terminated, so it mustn't contain a NUL.
+	for i := range challenge {
+		challenge[i] = challenge[i]&0x7f | 1
+	}
+
+	flags := c.l.flags()
+	greeting := []byte{10}
+	greeting = append(greeting, c.l.version()...)
+	greeting = append(greeting, 0)
+	greeting = binary.LittleEndian.AppendUint32(greeting, c.id)
+	greeting = append(greeting, challenge[:8]...)
+	greeting = append(greeting, 0)
+	greeting = binary.LittleEndian.AppendUint16(greeting, uint16(flags&0xffff))
+	greeting = append(greeting, wire.CollationUTF8MB4)
+	greeting = binary.LittleEndian.AppendUint16(greeting, c.status)
+	greeting = binary.LittleEndian.AppendUint16(greeting, uint16(flags>>16))
+	greeting = append(greeting, byte(len(challenge)+1))
+	greeting = append(greeting, make([]byte, 6)...)
+	greeting = binary.LittleEndian.AppendUint32(greeting, c.l.mariaDBFlags())
+	greeting = append(greeting, challenge[8:]...)
+	greeting = append(greeting, 0)
+	greeting = append(greeting, wire.NativePassword...)
+	greeting = append(greeting, 0)
+
+	err = c.writePackets(greeting)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.pc.ReadPacket()
+	if err != nil {
+		return err
+	}
+
+	authResponse, plugin, err := c.parseHandshakeResponse(response)
+	if err != nil {
+		c.writePackets(errPacket(&Error{Code: 1043, State: "08S01", Message: "Bad handshake"}))
+		return err
+	}
+
+	// A client that answered the challenge with another method is asked to
+	// switch to ours.
+	if plugin != "" && plugin != wire.NativePassword {
+		authSwitch := []byte{0xfe}
+		authSwitch = append(authSwitch, wire.NativePassword...)
+		authSwitch = append(authSwitch, 0)
+		authSwitch = append(authSwitch, challenge[:]...)
+		authSwitch = append(authSwitch, 0)
+
+		err = c.writePackets(authSwitch)
+		if err != nil {
+			return err
+		}
+
+		authResponse, err = c.pc.ReadPacket()
+		if err != nil {
+			return err
+		}
+	}
+
+	check := func(password string) bool {
+		if password == "" {
+			return len(authResponse) == 0
+		}
+		return string(authResponse) == string(wire.ScramblePassword(challenge[:], password))
+	}
+	if c.l.Authenticate != nil && !c.l.Authenticate(c.user, check) {
+		usingPassword := "NO"
+		if len(authResponse) > 0 {
+			usingPassword = "YES"
+		}
+		msg := fmt.Sprintf("Access denied for user '%s' (using password: %s)", c.user, usingPassword)
+		c.writePackets(errPacket(&Error{Code: 1045, State: "28000", Message: msg}))
+		return errors.New("access denied")
+	}
+
+	err = c.writePackets(c.ok(nil))
+	if err != nil {
+		return err
+	}
+	return c.startCompression()
+}
+
+// startCompression switches the connection to the compressed protocol, if the
+// client asked for it in its handshake response.
+func (c *Conn) startCompression() error {
+	var algorithm string
+	switch {
+	case c.clientFlags&wire.FlagZstdCompression != 0:
+		algorithm = "zstd"
+	case c.clientFlags&wire.FlagCompress != 0:
+		algorithm = "zlib"
+	default:
+		return nil
+	}
+
+	cc, err := wire.NewCompressConn(c.nc, algorithm, c.zstdLevel, 0)
+	if err != nil {
+		return err
+	}
+	c.cc = cc
+	c.pc = wire.NewPacketConn(cc, cc)
+	return nil
+}
+
+// parseHandshakeResponse returns the response to the challenge and the name
+// of the authentication method from the client's handshake response, and
+// records the rest of what the client sent.
+func (c *Conn) parseHandshakeResponse(b []byte) ([]byte, string, error) {
+	// The response starts with the capability flags, the maximum packet
+	// size, the collation, and 23 reserved bytes, the last 4 of which are
+	// MariaDB's extended capabilities, if CLIENT_LONG_PASSWORD isn't set.
+	if len(b) < 32 {
+		return nil, "", wire.ErrMalformedPacket
+	}
+	clientFlags := binary.LittleEndian.Uint32(b)
+	if clientFlags&wire.FlagProtocol41 == 0 {
+		return nil, "", errors.New("client doesn't support protocol 4.1")
+	}
+	c.clientFlags = clientFlags & c.l.flags()
+	if clientFlags&wire.FlagLongPassword == 0 {
+		c.clientMariaDBFlags = binary.LittleEndian.Uint32(b[28:32]) & c.l.mariaDBFlags()
+	}
+	b = b[32:]
+
+	user, b, err := wire.ReadNullTerminated(b)
+	if err != nil {
+		return nil, "", err
+	}
+	c.user = string(user)
+
+	var authResponse []byte
+	switch {
+	case clientFlags&wire.FlagPluginAuthLenEnc != 0:
+		authResponse, b, err = wire.ReadLengthEncodedString(b)
+	case clientFlags&wire.FlagSecureConn != 0:
+		if len(b) == 0 || int(b[0]) > len(b)-1 {
+			return nil, "", wire.ErrMalformedPacket
+		}
+		authResponse, b = b[1:1+b[0]], b[1+b[0]:]
+	default:
+		authResponse, b, err = wire.ReadNullTerminated(b)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if clientFlags&wire.FlagConnectWithDB != 0 {
+		var db []byte
+		db, b, err = wire.ReadNullTerminated(b)
+		if err != nil {
+			return nil, "", err
+		}
+		c.db = string(db)
+	}
+
+	var plugin []byte
+	if clientFlags&wire.FlagPluginAuth != 0 {
+		plugin, b, err = wire.ReadNullTerminated(b)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if clientFlags&wire.FlagConnectAttrs != 0 {
+		var attrs []byte
+		attrs, b, err = wire.ReadLengthEncodedString(b)
+		if err != nil {
+			return nil, "", err
+		}
+		c.attrs, err = parseConnectAttrs(attrs)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// A client that asks for zstd says which level it compresses at.
+	if c.clientFlags&wire.FlagZstdCompression != 0 {
+		if len(b) < 1 {
+			return nil, "", wire.ErrMalformedPacket
+		}
+		c.zstdLevel = int(b[0])
+	}
+
+	return authResponse, string(plugin), nil
+}
+
+// parseConnectAttrs returns the connection attributes encoded in b.
+func parseConnectAttrs(b []byte) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for len(b) > 0 {
+		var name, value []byte
+		var err error
+		name, b, err = wire.ReadLengthEncodedString(b)
+		if err != nil {
+			return nil, err
+		}
+		value, b, err = wire.ReadLengthEncodedString(b)
+		if err != nil {
+			return nil, err
+		}
+		attrs[string(name)] = string(value)
+	}
+	return attrs, nil
+}
+
+// dispatch runs a command. An error is only returned if the connection
+// can't be used any more.
+func (c *Conn) dispatch(command byte, arg []byte) error {
+	h := c.l.handler
+	c.queryAttrs = nil
+	switch command {
+	case wire.ComQuery:
+		return c.query(arg)
+	case wire.ComStmtPrepare:
+		return c.prepare(string(arg))
+	case wire.ComStmtExecute:
+		return c.execute(arg)
+	case wire.ComStmtBulkExecute:
+		if c.clientMariaDBFlags&wire.MariaDBFlagStmtBulkOperations != 0 {
+			return c.bulkExecute(arg)
+		}
+	case wire.ComStmtClose:
+		// There is no response to COM_STMT_CLOSE.
+		if len(arg) >= 4 {
+			id := binary.LittleEndian.Uint32(arg)
+			if stmt := c.stmts[id]; stmt != nil {
+				h.Close(c, stmt)
+				delete(c.stmts, id)
+			}
+		}
+		return nil
+	case wire.ComStmtReset:
+		return c.writePackets(c.ok(nil))
+	case wire.ComInitDB:
+		err := h.InitDB(c, string(arg))
+		if err != nil {
+			return c.writePackets(errorPacket(err))
+		}
+		c.db = string(arg)
+		return c.writePackets(c.ok(nil))
+	case wire.ComPing:
+		err := h.Ping(c)
+		if err != nil {
+			return c.writePackets(errorPacket(err))
+		}
+		return c.writePackets(c.ok(nil))
+	case wire.ComResetConnection:
+		c.closeStmts()
+		c.status = wire.StatusAutocommit
+		return c.writePackets(c.ok(nil))
+	}
+	return c.writePackets(errPacket(&Error{Code: 1047, State: "08S01", Message: "Unknown command"}))
+}
+
+// query answers a COM_QUERY, whose body is the query, preceded by the query
+// attributes if the client supports them.
+func (c *Conn) query(b []byte) error {
+	if c.clientFlags&wire.FlagQueryAttributes != 0 {
+		// The attributes start with their number, and the number of sets of
+		// them, which is always 1.
+		count, rest, err := wire.ReadLengthEncodedInt(b)
+		if err == nil {
+			_, rest, err = wire.ReadLengthEncodedInt(rest)
+		}
+		if err == nil && count > 0 {
+			var types []byte
+			var values []any
+			var names []string
+			values, names, rest, err = readParams(rest, int(count), true, &types)
+			c.setQueryAttributes(values, names)
+		}
+		if err != nil {
+			return c.writePackets(errPacket(errMalformed))
+		}
+		b = rest
+	}
+
+	result, err := c.l.handler.Query(c, string(b))
+	if err != nil {
+		return c.writePackets(errorPacket(err))
+	}
+	return c.writeResult(result, nil, false)
+}
+
+// setQueryAttributes records the query attributes with the given values and
+// names.
+func (c *Conn) setQueryAttributes(values []any, names []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	c.queryAttrs = make(map[string]any, len(values))
+	for i, v := range values {
+		c.queryAttrs[names[i]] = v
+	}
+}
+
+// prepare answers a COM_STMT_PREPARE.
+func (c *Conn) prepare(query string) error {
+	numParams, columns, err := c.l.handler.Prepare(c, query)
+	if err != nil {
+		return c.writePackets(errorPacket(err))
+	}
+
+	c.nextStmtID++
+	stmt := &Stmt{
+		ID:        c.nextStmtID,
+		Query:     query,
+		NumParams: numParams,
+		Columns:   ResolveColumns(columns, nil),
+	}
+	c.stmts[stmt.ID] = stmt
+
+	ok := []byte{0x00}
+	ok = binary.LittleEndian.AppendUint32(ok, stmt.ID)
+	ok = binary.LittleEndian.AppendUint16(ok, uint16(len(stmt.Columns)))
+	ok = binary.LittleEndian.AppendUint16(ok, uint16(stmt.NumParams))
+	ok = append(ok, 0, 0, 0) // filler and warnings
+
+	packets := [][]byte{ok}
+	if stmt.NumParams > 0 {
+		for i := 0; i < stmt.NumParams; i++ {
+			packets = append(packets, columnDefinition(Column{Name: "?", Type: TypeVarChar}))
+		}
+		if c.clientFlags&wire.FlagDeprecateEOF == 0 {
+			packets = append(packets, eofPacket(0, c.status))
+		}
+	}
+	if len(stmt.Columns) > 0 {
+		for _, col := range stmt.Columns {
+			packets = append(packets, columnDefinition(col))
+		}
+		if c.clientFlags&wire.FlagDeprecateEOF == 0 {
+			packets = append(packets, eofPacket(0, c.status))
+		}
+	}
+	return c.writePackets(packets...)
+}
+
+// execute answers a COM_STMT_EXECUTE.
+func (c *Conn) execute(b []byte) error {
+	// The statement id, flags and iteration count come first.
+	if len(b) < 9 {
+		return c.writePackets(errPacket(errMalformed))
+	}
+
+	stmt, err := c.stmt(binary.LittleEndian.Uint32(b), "mysqld_stmt_execute")
+	if err != nil {
+		return c.writePackets(errPacket(err.(*Error)))
+	}
+	flags := b[4]
+	b = b[9:]
+
+	// With query attributes, the number of parameters is sent, since the
+	// attributes follow the statement's parameters, and each parameter has a
+	// name, which is empty for the statement's own.
+	count, named := stmt.NumParams, c.clientFlags&wire.FlagQueryAttributes != 0
+	if named && (count > 0 || flags&wire.ParameterCountAvailable != 0) {
+		var n uint64
+		n, b, err = wire.ReadLengthEncodedInt(b)
+		if err != nil || n < uint64(count) {
+			return c.writePackets(errPacket(errMalformed))
+		}
+		count = int(n)
+	}
+
+	var args []any
+	if count > 0 {
+		var values []any
+		var names []string
+		values, names, _, err = readParams(b, count, named, &stmt.paramTypes)
+		if err != nil {
+			return c.writePackets(errPacket(errMalformed))
+		}
+		args = values[:stmt.NumParams]
+		if names != nil {
+			c.setQueryAttributes(values[stmt.NumParams:], names[stmt.NumParams:])
+		}
+	}
+
+	result, err := c.l.handler.Execute(c, stmt, args)
+	if err != nil {
+		return c.writePackets(errorPacket(err))
+	}
+	return c.writeResult(result, stmt.Columns, true)
+}
+
+// stmt returns the prepared statement with the given id, or the error that
+// command sends for an unknown one.
+func (c *Conn) stmt(id uint32, command string) (*Stmt, error) {
+	stmt := c.stmts[id]
+	if stmt == nil {
+		msg := fmt.Sprintf("Unknown prepared statement handler (%d) given to %s", id, command)
+		return nil, &Error{Code: 1243, Message: msg}
+	}
+	return stmt, nil
+}
+
+// bulkExecute answers a COM_STMT_BULK_EXECUTE, by executing the statement once
+// for each row of parameters. The results are reported together, or one by
+// one in a result set if the client asks for unit results.
+func (c *Conn) bulkExecute(b []byte) error {
+	// The statement id and flags come first.
+	if len(b) < 6 {
+		return c.writePackets(errPacket(errMalformed))
+	}
+
+	stmt, err := c.stmt(binary.LittleEndian.Uint32(b), "mysqld_stmt_bulk_execute")
+	if err != nil {
+		return c.writePackets(errPacket(err.(*Error)))
+	}
+	flags := binary.LittleEndian.Uint16(b[4:6])
+	b = b[6:]
+
+	if flags&wire.BulkSendTypes != 0 {
+		if len(b) < 2*stmt.NumParams {
+			return c.writePackets(errPacket(errMalformed))
+		}
+		stmt.paramTypes = append(stmt.paramTypes[:0], b[:2*stmt.NumParams]...)
+		b = b[2*stmt.NumParams:]
+	} else if len(stmt.paramTypes) != 2*stmt.NumParams {
+		return c.writePackets(errPacket(errMalformed))
+	}
+
+	rows, err := readBulkRows(b, stmt.paramTypes)
+	if err != nil {
+		return c.writePackets(errPacket(errMalformed))
+	}
+
+	total := &Result{}
+	units := &Result{Columns: []Column{{Name: "Id", Type: TypeBigInt}, {Name: "Affected_rows", Type: TypeBigInt}}}
+	for i, args := range rows {
+		result, err := c.l.handler.Execute(c, stmt, args)
+		if err != nil {
+			return c.writePackets(errorPacket(err))
+		}
+		if result == nil {
+			result = &Result{}
+		}
+
+		// The last insert id is the one generated for the first row.
+		if i == 0 {
+			total.LastInsertID = result.LastInsertID
+		}
+		total.AffectedRows += result.AffectedRows
+		total.Warnings += result.Warnings
+		units.Rows = append(units.Rows, []any{result.LastInsertID, result.AffectedRows})
+	}
+
+	if flags&wire.BulkSendUnitResults != 0 && c.clientMariaDBFlags&wire.MariaDBFlagBulkUnitResults != 0 {
+		return c.writeResult(units, units.Columns, true)
+	}
+	return c.writePackets(c.ok(total))
+}
+
+// readBulkRows returns the rows of parameters of a COM_STMT_BULK_EXECUTE, whose
+// types are given in types. Each value is preceded by an indicator saying
+// whether it is there, or is NULL, or one of the Indicators.
+func readBulkRows(b []byte, types []byte) ([][]any, error) {
+	var rows [][]any
+	for len(b) > 0 {
+		args := make([]any, len(types)/2)
+		for i := range args {
+			if len(b) == 0 {
+				return nil, wire.ErrMalformedPacket
+			}
+			indicator := b[0]
+			b = b[1:]
+
+			switch indicator {
+			case 0:
+				var err error
+				wireType, unsigned := types[2*i], types[2*i+1]&0x80 != 0
+				args[i], b, err = readBinaryValue(b, wireType, unsigned)
+				if err != nil {
+					return nil, err
+				}
+			case 1:
+				// NULL
+			case 2:
+				args[i] = Default
+			case 3:
+				args[i] = Ignore
+			default:
+				return nil, wire.ErrMalformedPacket
+			}
+		}
+		rows = append(rows, args)
+	}
+	return rows, nil
+}
+
+// readParams returns the values of count parameters in the binary protocol,
+// from their NULL bitmap, a flag saying whether their types follow, and their
+// types and values, along with the rest of b. If named, each type is followed
+// by the parameter's name, and the names are returned too. Types that aren't
+// sent are the same as last time, and are kept in types.
+func readParams(b []byte, count int, named bool, types *[]byte) ([]any, []string, []byte, error) {
+	bitmapSize := (count + 7) / 8
+	if len(b) < bitmapSize+1 {
+		return nil, nil, nil, wire.ErrMalformedPacket
+	}
+	nulls, b := b[:bitmapSize], b[bitmapSize:]
+
+	newParamsBound := b[0] == 1
+	b = b[1:]
+
+	var names []string
+	if newParamsBound {
+		*types = (*types)[:0]
+		for i := 0; i < count; i++ {
+			if len(b) < 2 {
+				return nil, nil, nil, wire.ErrMalformedPacket
+			}
+			*types = append(*types, b[0], b[1])
+			b = b[2:]
+
+			if named {
+				name, rest, err := wire.ReadLengthEncodedString(b)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				names = append(names, string(name))
+				b = rest
+			}
+		}
+	} else if len(*types) != 2*count {
+		return nil, nil, nil, wire.ErrMalformedPacket
+	}
+
+	values := make([]any, count)
+	for i := range values {
+		if nulls[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+
+		var err error
+		wireType, unsigned := (*types)[2*i], (*types)[2*i+1]&0x80 != 0
+		values[i], b, err = readBinaryValue(b, wireType, unsigned)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return values, names, b, nil
+}
+
+// writeResult sends result, as a result set in the binary protocol if
+// binaryProtocol is true, and in the text protocol if not. The columns of a prepared
+// statement are given in columns, and result must have the same ones.
+func (c *Conn) writeResult(result *Result, columns []Column, binaryProtocol bool) error {
+	if result == nil {
+		result = &Result{}
+	}
+
+	rows, err := convertRows(result.Rows)
+	if err != nil {
+		return c.writePackets(errorPacket(err))
+	}
+
+	if !binaryProtocol {
+		columns = ResolveColumns(result.Columns, rows)
+	} else if len(result.Columns) != 0 && len(result.Columns) != len(columns) {
+		err = fmt.Errorf("result has %d columns, but the statement has %d", len(result.Columns), len(columns))
+		return c.writePackets(errorPacket(err))
+	}
+
+	if len(columns) == 0 {
+		return c.writePackets(c.ok(result))
+	}
+
+	packets := c.columnDefinitions(columns)
+	for _, values := range rows {
+		if len(values) != len(columns) {
+			err = fmt.Errorf("row has %d values, but the result has %d columns", len(values), len(columns))
+			return c.writePackets(errorPacket(err))
+		}
+
+		var row []byte
+		if binaryProtocol {
+			row, err = binaryRow(columns, values)
+		} else {
+			row, err = textRow(values)
+		}
+		if err != nil {
+			return c.writePackets(errorPacket(err))
+		}
+		packets = append(packets, row)
+	}
+	return c.writePackets(append(packets, c.endOfResultSet(result))...)
+}
+
+// columnDefinitions returns the packets that start a result set with columns.
+func (c *Conn) columnDefinitions(columns []Column) [][]byte {
+	packets := [][]byte{wire.AppendLengthEncodedInt(nil, uint64(len(columns)))}
+	for _, col := range columns {
+		packets = append(packets, columnDefinition(col))
+	}
+	if c.clientFlags&wire.FlagDeprecateEOF == 0 {
+		packets = append(packets, eofPacket(0, c.status))
+	}
+	return packets
+}
+
+// endOfResultSet returns the packet that ends the result set of result, which
+// reports its warnings, and with CLIENT_DEPRECATE_EOF, its session state.
+func (c *Conn) endOfResultSet(result *Result) []byte {
+	if c.clientFlags&wire.FlagDeprecateEOF != 0 {
+		end := &Result{Warnings: result.Warnings, Session: result.Session}
+		return okPacket(0xfe, end, c.status, c.clientFlags&wire.FlagSessionTrack != 0)
+	}
+	return eofPacket(result.Warnings, c.status)
+}
+
+// ok returns an OK packet reporting result, which may be nil.
+func (c *Conn) ok(result *Result) []byte {
+	if result == nil {
+		result = &Result{}
+	}
+	return okPacket(0x00, result, c.status, c.clientFlags&wire.FlagSessionTrack != 0)
+}
+
+// errorPacket returns the ERR packet for err.
+func errorPacket(err error) []byte {
+	var serverErr *Error
+	if errors.As(err, &serverErr) {
+		return errPacket(serverErr)
+	}
+	return errPacket(&Error{Code: 1105, Message: err.Error()})
+}
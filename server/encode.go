@@ -0,0 +1,358 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// This file implements the encoding of the packets the server sends, and the
+// decoding of the parameters of prepared statements.
+
+// okPacket returns an OK packet reporting result, with header as its first
+// byte, which is 0xfe when it ends a result set in place of an EOF packet. The
+// changes to the session state are included if sessionTrack is true, which it
+// may only be if the client asked for them.
+func okPacket(header byte, result *Result, status uint16, sessionTrack bool) []byte {
+	var state []byte
+	if sessionTrack && result.Session != nil {
+		state = appendSessionState(nil, result.Session)
+		status |= wire.StatusSessionStateChanged
+	}
+
+	b := []byte{header}
+	b = wire.AppendLengthEncodedInt(b, result.AffectedRows)
+	b = wire.AppendLengthEncodedInt(b, result.LastInsertID)
+	b = binary.LittleEndian.AppendUint16(b, status)
+	b = binary.LittleEndian.AppendUint16(b, result.Warnings)
+	if !sessionTrack {
+		return b
+	}
+
+	// With session tracking, the empty status message is length-encoded, and
+	// followed by the changes to the session state, if there are any.
+	b = append(b, 0)
+	if state != nil {
+		b = wire.AppendLengthEncodedString(b, string(state))
+	}
+	return b
+}
+
+// appendSessionState appends the changes in state to b, each as its type
+// followed by its length-encoded data.
+func appendSessionState(b []byte, state *SessionState) []byte {
+	names := make([]string, 0, len(state.Variables))
+	for name := range state.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data := wire.AppendLengthEncodedString(nil, name)
+		data = wire.AppendLengthEncodedString(data, state.Variables[name])
+		b = append(b, wire.SessionTrackSystemVariables)
+		b = wire.AppendLengthEncodedString(b, string(data))
+	}
+
+	if state.Schema != "" {
+		b = append(b, wire.SessionTrackSchema)
+		b = wire.AppendLengthEncodedString(b, string(wire.AppendLengthEncodedString(nil, state.Schema)))
+	}
+
+	if state.GTIDs != "" {
+		// The GTIDs are preceded by the encoding specification, which is
+		// always 0.
+		data := wire.AppendLengthEncodedString([]byte{0}, state.GTIDs)
+		b = append(b, wire.SessionTrackGTIDs)
+		b = wire.AppendLengthEncodedString(b, string(data))
+	}
+	return b
+}
+
+// eofPacket returns an EOF packet.
+func eofPacket(warnings, status uint16) []byte {
+	b := binary.LittleEndian.AppendUint16([]byte{0xfe}, warnings)
+	return binary.LittleEndian.AppendUint16(b, status)
+}
+
+// errPacket returns an ERR packet for err.
+func errPacket(err *Error) []byte {
+	state := err.State
+	if len(state) != 5 {
+		state = "HY000"
+	}
+
+	b := []byte{0xff}
+	b = binary.LittleEndian.AppendUint16(b, err.Code)
+	b = append(b, '#')
+	b = append(b, state...)
+	return append(b, err.Message...)
+}
+
+// columnDefinition returns the definition packet of col, whose type must have
+// been resolved.
+func columnDefinition(col Column) []byte {
+	wireType, charset, flags := col.Type.wire()
+
+	var b []byte
+	for _, s := range []string{"def", "", "", "", col.Name, col.Name} {
+		b = wire.AppendLengthEncodedString(b, s)
+	}
+	b = append(b, 0x0c)
+	b = binary.LittleEndian.AppendUint16(b, charset)
+	b = binary.LittleEndian.AppendUint32(b, col.Type.length())
+	b = append(b, wireType)
+	b = binary.LittleEndian.AppendUint16(b, flags)
+	b = append(b, 0)       // decimals
+	return append(b, 0, 0) // filler
+}
+
+// textRow returns a row of a result set in the text protocol.
+func textRow(values []any) ([]byte, error) {
+	var b []byte
+	for _, v := range values {
+		if v == nil {
+			b = append(b, 0xfb)
+			continue
+		}
+
+		s, err := formatText(v)
+		if err != nil {
+			return nil, err
+		}
+		b = wire.AppendLengthEncodedString(b, s)
+	}
+	return b, nil
+}
+
+// formatText returns the text protocol representation of v.
+func formatText(v any) (string, error) {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999"), nil
+	}
+	return "", fmt.Errorf("can't send a value of type %T", v)
+}
+
+// binaryRow returns a row of a result set in the binary protocol.
+func binaryRow(columns []Column, values []any) ([]byte, error) {
+	// The row starts with a header byte, and a NULL bitmap whose first two
+	// bits are unused.
+	b := make([]byte, 1+(len(values)+7+2)/8)
+	for i, v := range values {
+		if v == nil {
+			b[1+(i+2)/8] |= 1 << ((i + 2) % 8)
+		}
+	}
+
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+
+		var err error
+		b, err = appendBinaryValue(b, columns[i].Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %v", columns[i].Name, err)
+		}
+	}
+	return b, nil
+}
+
+// appendBinaryValue appends v to b, encoded in the binary protocol as a value
+// of type t.
+func appendBinaryValue(b []byte, t ColumnType, v any) ([]byte, error) {
+	wireType, _, _ := t.wire()
+	switch wireType {
+	case wire.TypeTiny, wire.TypeShort, wire.TypeLong, wire.TypeLongLong:
+		var n int64
+		switch v := v.(type) {
+		case int64:
+			n = v
+		case uint64:
+			n = int64(v)
+		case bool:
+			if v {
+				n = 1
+			}
+		default:
+			return nil, fmt.Errorf("can't send a %T as %s", v, t)
+		}
+
+		switch wireType {
+		case wire.TypeTiny:
+			return append(b, byte(n)), nil
+		case wire.TypeShort:
+			return binary.LittleEndian.AppendUint16(b, uint16(n)), nil
+		case wire.TypeLong:
+			return binary.LittleEndian.AppendUint32(b, uint32(n)), nil
+		}
+		return binary.LittleEndian.AppendUint64(b, uint64(n)), nil
+	case wire.TypeFloat, wire.TypeDouble:
+		var f float64
+		switch v := v.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		default:
+			return nil, fmt.Errorf("can't send a %T as %s", v, t)
+		}
+
+		if wireType == wire.TypeFloat {
+			return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(f))), nil
+		}
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case wire.TypeDate, wire.TypeDateTime, wire.TypeTimestamp:
+		tm, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("can't send a %T as %s", v, t)
+		}
+		return appendBinaryTime(b, tm, wireType == wire.TypeDate), nil
+	}
+
+	s, err := formatText(v)
+	if err != nil {
+		return nil, err
+	}
+	return wire.AppendLengthEncodedString(b, s), nil
+}
+
+// appendBinaryTime appends tm to b as a DATE, or a DATETIME, in the binary
+// protocol.
+func appendBinaryTime(b []byte, tm time.Time, dateOnly bool) []byte {
+	micros := tm.Nanosecond() / int(time.Microsecond)
+
+	size := byte(4)
+	if !dateOnly {
+		switch {
+		case micros != 0:
+			size = 11
+		case tm.Hour() != 0 || tm.Minute() != 0 || tm.Second() != 0:
+			size = 7
+		}
+	}
+
+	b = append(b, size)
+	b = binary.LittleEndian.AppendUint16(b, uint16(tm.Year()))
+	b = append(b, byte(tm.Month()), byte(tm.Day()))
+	if size >= 7 {
+		b = append(b, byte(tm.Hour()), byte(tm.Minute()), byte(tm.Second()))
+	}
+	if size == 11 {
+		b = binary.LittleEndian.AppendUint32(b, uint32(micros))
+	}
+	return b
+}
+
+// readBinaryValue returns the value of type wireType at the start of b, and
+// the rest of b.
+func readBinaryValue(b []byte, wireType byte, unsigned bool) (any, []byte, error) {
+	need := func(n int) error {
+		if len(b) < n {
+			return wire.ErrMalformedPacket
+		}
+		return nil
+	}
+
+	switch wireType {
+	case wire.TypeNULL:
+		return nil, b, nil
+	case wire.TypeTiny:
+		if err := need(1); err != nil {
+			return nil, nil, err
+		}
+		if unsigned {
+			return int64(b[0]), b[1:], nil
+		}
+		return int64(int8(b[0])), b[1:], nil
+	case wire.TypeShort, wire.TypeYear:
+		if err := need(2); err != nil {
+			return nil, nil, err
+		}
+		n := binary.LittleEndian.Uint16(b)
+		if unsigned {
+			return int64(n), b[2:], nil
+		}
+		return int64(int16(n)), b[2:], nil
+	case wire.TypeLong, wire.TypeInt24:
+		if err := need(4); err != nil {
+			return nil, nil, err
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if unsigned {
+			return int64(n), b[4:], nil
+		}
+		return int64(int32(n)), b[4:], nil
+	case wire.TypeLongLong:
+		if err := need(8); err != nil {
+			return nil, nil, err
+		}
+		n := binary.LittleEndian.Uint64(b)
+		if unsigned {
+			return n, b[8:], nil
+		}
+		return int64(n), b[8:], nil
+	case wire.TypeFloat:
+		if err := need(4); err != nil {
+			return nil, nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), b[4:], nil
+	case wire.TypeDouble:
+		if err := need(8); err != nil {
+			return nil, nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), b[8:], nil
+	case wire.TypeDate, wire.TypeDateTime, wire.TypeTimestamp:
+		if err := need(1); err != nil {
+			return nil, nil, err
+		}
+		size := int(b[0])
+		if err := need(1 + size); err != nil {
+			return nil, nil, err
+		}
+
+		var fields [7]int
+		if size >= 4 {
+			fields[0] = int(binary.LittleEndian.Uint16(b[1:3]))
+			fields[1], fields[2] = int(b[3]), int(b[4])
+		}
+		if size >= 7 {
+			fields[3], fields[4], fields[5] = int(b[5]), int(b[6]), int(b[7])
+		}
+		if size >= 11 {
+			fields[6] = int(binary.LittleEndian.Uint32(b[8:12]))
+		}
+		tm := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], fields[6]*int(time.Microsecond), time.UTC)
+		return tm, b[1+size:], nil
+	case wire.TypeBLOB:
+		s, rest, err := wire.ReadLengthEncodedString(b)
+		return s, rest, err
+	}
+
+	s, rest, err := wire.ReadLengthEncodedString(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return string(s), rest, nil
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// ColumnType is the SQL type of a column in a result set.
+type ColumnType string
+
+const (
+	TypeTinyInt   ColumnType = "TINYINT"
+	TypeSmallInt  ColumnType = "SMALLINT"
+	TypeInt       ColumnType = "INT"
+	TypeBigInt    ColumnType = "BIGINT"
+	TypeFloat     ColumnType = "FLOAT"
+	TypeDouble    ColumnType = "DOUBLE"
+	TypeDecimal   ColumnType = "DECIMAL"
+	TypeVarChar   ColumnType = "VARCHAR"
+	TypeBlob      ColumnType = "BLOB"
+	TypeDate      ColumnType = "DATE"
+	TypeDateTime  ColumnType = "DATETIME"
+	TypeTimestamp ColumnType = "TIMESTAMP"
+)
+
+// wire returns the wire type, collation id and column flags of t.
+func (t ColumnType) wire() (byte, uint16, uint16) {
+	switch t {
+	case TypeTinyInt:
+		return wire.TypeTiny, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeSmallInt:
+		return wire.TypeShort, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeInt:
+		return wire.TypeLong, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeBigInt:
+		return wire.TypeLongLong, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeFloat:
+		return wire.TypeFloat, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeDouble:
+		return wire.TypeDouble, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeDecimal:
+		return wire.TypeNewDecimal, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeBlob:
+		return wire.TypeBLOB, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeDate:
+		return wire.TypeDate, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeDateTime:
+		return wire.TypeDateTime, wire.CollationBinary, wire.ColumnFlagBinary
+	case TypeTimestamp:
+		return wire.TypeTimestamp, wire.CollationBinary, wire.ColumnFlagBinary
+	}
+	return wire.TypeVarString, wire.CollationUTF8MB4, 0
+}
+
+// length returns the maximum display length of a column of type t.
+func (t ColumnType) length() uint32 {
+	switch t {
+	case TypeTinyInt:
+		return 4
+	case TypeSmallInt:
+		return 6
+	case TypeInt:
+		return 11
+	case TypeBigInt:
+		return 20
+	case TypeFloat:
+		return 12
+	case TypeDouble:
+		return 22
+	case TypeDecimal:
+		return 65
+	case TypeDate:
+		return 10
+	case TypeDateTime, TypeTimestamp:
+		return 26
+	case TypeBlob:
+		return 65535
+	}
+	return 255 * 4
+}
+
+// typeOf returns the type of a column holding v, which has been converted
+// with driver.DefaultParameterConverter.
+func typeOf(v any) ColumnType {
+	switch v.(type) {
+	case int64, uint64:
+		return TypeBigInt
+	case float64:
+		return TypeDouble
+	case bool:
+		return TypeTinyInt
+	case []byte:
+		return TypeBlob
+	case time.Time:
+		return TypeDateTime
+	}
+	return TypeVarChar
+}
+
+// Column describes a column of a result set.
+type Column struct {
+	Name string
+
+	// The type of the column. If empty, it is inferred from the column's
+	// first non-nil value, and is VARCHAR if all the values are nil.
+	Type ColumnType
+}
+
+// ResolveColumns returns columns, with any missing types inferred from the
+// values in rows.
+func ResolveColumns(columns []Column, rows [][]any) []Column {
+	columns = append([]Column(nil), columns...)
+	for i := range columns {
+		if columns[i].Type != "" {
+			continue
+		}
+
+		columns[i].Type = TypeVarChar
+		for _, values := range rows {
+			if i >= len(values) {
+				continue
+			}
+			v, err := driver.DefaultParameterConverter.ConvertValue(values[i])
+			if err == nil && v != nil {
+				columns[i].Type = typeOf(v)
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// Result is the response to a query or an execution of a prepared statement.
+// It is a result set if it has columns, and an OK packet reporting
+// AffectedRows and LastInsertID if not.
+type Result struct {
+	Columns []Column
+
+	// The rows of the result set. Values may be of any type that
+	// driver.DefaultParameterConverter accepts, or nil for NULL.
+	Rows [][]any
+
+	AffectedRows uint64
+	LastInsertID uint64
+
+	// The number of warnings the statement raised.
+	Warnings uint16
+
+	// The changes the statement made to the session's state, which are
+	// reported to clients if the Listener supports SessionTrack.
+	Session *SessionState
+}
+
+// SessionState describes changes to the state of a connection's session.
+type SessionState struct {
+	// The system variables that changed, and their new values.
+	Variables map[string]string
+
+	// The new default database, if it changed.
+	Schema string
+
+	// The GTIDs of the transactions that were committed.
+	GTIDs string
+}
+
+// Indicator is passed to Handler.Execute by COM_STMT_BULK_EXECUTE in place of
+// a parameter's value, to say what to do with the parameter instead.
+type Indicator string
+
+const (
+	// Default sets the column to its default value.
+	Default Indicator = "DEFAULT"
+
+	// Ignore leaves the column unchanged in an UPDATE, and sets it to its
+	// default value in an INSERT.
+	Ignore Indicator = "IGNORE"
+)
+
+// convertRows returns rows with their values converted to the types that
+// driver.DefaultParameterConverter produces.
+func convertRows(rows [][]any) ([][]any, error) {
+	converted := make([][]any, len(rows))
+	for i, values := range rows {
+		converted[i] = make([]any, len(values))
+		for j, v := range values {
+			value, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %d: %v", i, j, err)
+			}
+			converted[i][j] = value
+		}
+	}
+	return converted, nil
+}
+
+// Error is an error sent to the client. A Handler that returns an *Error has
+// it sent as it is; any other error is sent with code 1105
+// (ER_UNKNOWN_ERROR).
+type Error struct {
+	Code uint16
+
+	// The SQLSTATE; defaults to HY000.
+	State string
+
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Code, e.Message)
+}
+
+// Stmt is a prepared statement.
+type Stmt struct {
+	// The id the statement was given, which is unique within its
+	// connection.
+	ID uint32
+
+	Query string
+
+	// The number of parameters, and the columns of the statement's result
+	// sets, as returned by Handler.Prepare.
+	NumParams int
+	Columns   []Column
+
+	// The types of the parameters, as sent with the last execution that
+	// bound them.
+	paramTypes []byte
+}
+
+// CountParams returns the number of placeholders in query, ignoring question
+// marks inside quoted strings, identifiers and comments.
+func CountParams(query string) int {
+	n := 0
+	for i := 0; i < len(query); i++ {
+		switch ch := query[i]; {
+		case ch == '?':
+			n++
+		case ch == '\'' || ch == '"' || ch == '`':
+			for i++; i < len(query) && query[i] != ch; i++ {
+				if query[i] == '\\' && ch != '`' {
+					i++
+				}
+			}
+		case ch == '#' || (ch == '-' && strings.HasPrefix(query[i:], "-- ")):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return n
+			}
+			i += end + 3
+		}
+	}
+	return n
+}
@@ -0,0 +1,248 @@
+// Package server implements the server side of the MySQL protocol, for
+// building servers that MySQL clients can connect to, such as shims, proxies,
+// mocks and virtual tables. A Listener accepts connections, carries out the
+// handshake, and passes each command on to a Handler:
+//
+//	ln, err := server.Listen("tcp", "127.0.0.1:3306", handler)
+//	if err != nil {
+//		...
+//	}
+//	defer ln.Close()
+//	err = ln.Serve()
+//
+// Queries are answered with text protocol result sets, and executions of
+// prepared statements with binary protocol ones. Clients authenticate with
+// mysql_native_password. Optional protocol features, such as session tracking
+// and MariaDB's bulk execution, are turned on with the Listener's fields.
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// A Handler answers the commands sent by clients. Its methods may be called
+// concurrently for different connections, but are called in turn for each
+// connection.
+type Handler interface {
+	// Query runs query, sent with COM_QUERY.
+	Query(c *Conn, query string) (*Result, error)
+
+	// Prepare prepares query, sent with COM_STMT_PREPARE, and returns the
+	// number of parameters it takes, and the columns of its result sets,
+	// which every execution must return. A statement that doesn't return a
+	// result set has no columns.
+	Prepare(c *Conn, query string) (numParams int, columns []Column, err error)
+
+	// Execute runs a prepared statement with args, sent with
+	// COM_STMT_EXECUTE. The args are int64, uint64, float64, string, []byte,
+	// time.Time, or nil for NULL. A COM_STMT_BULK_EXECUTE runs it once for
+	// each row of parameters, which may also hold Default and Ignore.
+	Execute(c *Conn, stmt *Stmt, args []any) (*Result, error)
+
+	// Close closes a prepared statement, as asked to with COM_STMT_CLOSE, or
+	// because its connection was reset or closed.
+	Close(c *Conn, stmt *Stmt)
+
+	// InitDB makes db the connection's default database, as asked to with
+	// COM_INIT_DB.
+	InitDB(c *Conn, db string) error
+
+	// Ping answers a COM_PING.
+	Ping(c *Conn) error
+}
+
+// A Listener serves connections from MySQL clients with a Handler.
+type Listener struct {
+	// The version the server claims to be. Defaults to 8.0.36.
+	Version string
+
+	// Authenticate reports whether user may log in, with check reporting
+	// whether the client knows the given password. If nil, any credentials
+	// are accepted.
+	Authenticate func(user string, check func(password string) bool) bool
+
+	// Connected, if non-nil, is called for each client that has logged in,
+	// before its commands are run.
+	Connected func(c *Conn)
+
+	// Optional protocol features, which clients only use if the server
+	// supports them. NewListener turns on DeprecateEOF.
+	//
+	// With DeprecateEOF, result sets end with an OK packet rather than an
+	// EOF packet. With SessionTrack, OK packets report the SessionState of
+	// results. With QueryAttributes, clients may send query attributes,
+	// which Conn.QueryAttributes returns. With Compress, clients may use the
+	// compressed protocol, with zlib, or with zstd in builds with the zstd
+	// build tag.
+	DeprecateEOF    bool
+	SessionTrack    bool
+	QueryAttributes bool
+	Compress        bool
+
+	// MariaDB makes the server send MariaDB's extended capabilities, and
+	// support COM_STMT_BULK_EXECUTE, with unit results. The Version should
+	// then be a MariaDB one; it defaults to 5.5.5-11.5.2-MariaDB.
+	MariaDB bool
+
+	ln      net.Listener
+	handler Handler
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[*Conn]struct{}
+	nextID uint32
+	closed bool
+}
+
+// errClosed is returned by Serve once the Listener is closed.
+var errClosed = errors.New("server: Listener closed")
+
+// NewListener returns a Listener that accepts connections from ln, and
+// answers them with handler. The Listener's fields may be set before Serve is
+// called.
+func NewListener(ln net.Listener, handler Handler) *Listener {
+	return &Listener{
+		DeprecateEOF: true,
+		ln:           ln,
+		handler:      handler,
+		conns:        make(map[*Conn]struct{}),
+	}
+}
+
+// Listen listens on the given network address, as net.Listen does, and returns
+// a Listener that answers connections to it with handler.
+func Listen(network, address string, handler Handler) (*Listener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(ln, handler), nil
+}
+
+// Addr returns the address the Listener accepts connections on.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Serve accepts connections, and serves each in a new goroutine, until the
+// Listener is closed or accepting fails.
+func (l *Listener) Serve() error {
+	for {
+		nc, err := l.ln.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+
+			if closed {
+				return errClosed
+			}
+			return err
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			nc.Close()
+			return errClosed
+		}
+		l.wg.Add(1)
+		l.mu.Unlock()
+
+		go func() {
+			defer l.wg.Done()
+			l.ServeConn(nc)
+		}()
+	}
+}
+
+// ServeConn serves the client at the other end of nc, which need not have
+// been accepted by the Listener, until the client quits, the connection fails
+// or the Listener is closed. It closes nc before returning.
+func (l *Listener) ServeConn(nc net.Conn) {
+	defer nc.Close()
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.nextID++
+	c := newConn(l, nc, l.nextID)
+	l.conns[c] = struct{}{}
+	l.wg.Add(1)
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, c)
+		l.mu.Unlock()
+		l.wg.Done()
+	}()
+
+	c.serve()
+}
+
+// Close stops accepting connections, closes the connections being served,
+// and waits for their handlers to return.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	err := l.ln.Close()
+	for c := range l.conns {
+		c.nc.Close()
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	return err
+}
+
+// version returns the version the server claims to be.
+func (l *Listener) version() string {
+	switch {
+	case l.Version != "":
+		return l.Version
+	case l.MariaDB:
+		return "5.5.5-11.5.2-MariaDB"
+	}
+	return "8.0.36"
+}
+
+// flags returns the capabilities the server supports.
+func (l *Listener) flags() uint32 {
+	flags := baseFlags
+	if l.DeprecateEOF {
+		flags |= wire.FlagDeprecateEOF
+	}
+	if l.SessionTrack {
+		flags |= wire.FlagSessionTrack
+	}
+	if l.QueryAttributes {
+		flags |= wire.FlagQueryAttributes
+	}
+	if l.Compress {
+		flags |= wire.FlagCompress
+		if wire.ZstdAvailable {
+			flags |= wire.FlagZstdCompression
+		}
+	}
+	if l.MariaDB {
+		// MariaDB servers leave out CLIENT_LONG_PASSWORD, which they call
+		// CLIENT_MYSQL, to say that they send extended capabilities.
+		flags &^= wire.FlagLongPassword
+	}
+	return flags
+}
+
+// mariaDBFlags returns the MariaDB extended capabilities the server supports.
+func (l *Listener) mariaDBFlags() uint32 {
+	if !l.MariaDB {
+		return 0
+	}
+	return wire.MariaDBFlagStmtBulkOperations | wire.MariaDBFlagBulkUnitResults
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/balasanjay/gms/internal/wire"
+
+	_ "github.com/balasanjay/gms"
+)
+
+// tableHandler serves a virtual table of numbers and their squares, which may
+// be queried in full, or one row at a time with a prepared statement.
+type tableHandler struct {
+	mu      sync.Mutex
+	closed  []string
+	dbs     []string
+	pings   int
+	attrs   map[string]string
+	queries []string
+}
+
+var tableColumns = []Column{{Name: "n", Type: TypeBigInt}, {Name: "square", Type: TypeBigInt}}
+
+func (h *tableHandler) Query(c *Conn, query string) (*Result, error) {
+	h.mu.Lock()
+	h.queries = append(h.queries, query)
+	h.attrs = c.Attributes()
+	h.mu.Unlock()
+
+	switch query {
+	case "SELECT @@max_allowed_packet":
+		return &Result{Columns: []Column{{Name: "@@max_allowed_packet"}}, Rows: [][]any{{64 << 20}}}, nil
+	case "SELECT * FROM squares":
+		result := &Result{Columns: tableColumns}
+		for n := 1; n <= 3; n++ {
+			result.Rows = append(result.Rows, []any{n, n * n})
+		}
+		return result, nil
+	case "DELETE FROM squares":
+		return &Result{AffectedRows: 3}, nil
+	}
+	return nil, &Error{Code: 1146, State: "42S02", Message: "Table doesn't exist"}
+}
+
+func (h *tableHandler) Prepare(c *Conn, query string) (int, []Column, error) {
+	if query != "SELECT * FROM squares WHERE n = ?" {
+		return 0, nil, errors.New("can't prepare that")
+	}
+	return CountParams(query), tableColumns, nil
+}
+
+func (h *tableHandler) Execute(c *Conn, stmt *Stmt, args []any) (*Result, error) {
+	n, ok := args[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("n is a %T", args[0])
+	}
+	return &Result{Columns: tableColumns, Rows: [][]any{{n, n * n}}}, nil
+}
+
+func (h *tableHandler) Close(c *Conn, stmt *Stmt) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = append(h.closed, stmt.Query)
+}
+
+func (h *tableHandler) InitDB(c *Conn, db string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if db == "missing" {
+		return &Error{Code: 1049, State: "42000", Message: "Unknown database"}
+	}
+	h.dbs = append(h.dbs, db)
+	return nil
+}
+
+func (h *tableHandler) Ping(c *Conn) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pings++
+	return nil
+}
+
+func startListener(t *testing.T, h Handler, authenticate func(string, func(string) bool) bool) *Listener {
+	l, err := Listen("tcp", "127.0.0.1:0", h)
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	l.Authenticate = authenticate
+	go l.Serve()
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestDriver(t *testing.T) {
+	h := &tableHandler{}
+	l := startListener(t, h, nil)
+
+	db, err := sql.Open("gms", "tcp://root:@"+l.Addr().String()+"?attr.app=test")
+	if err != nil {
+		t.Fatalf("sql.Open error: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM squares")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	var got [][2]int64
+	for rows.Next() {
+		var row [2]int64
+		err = rows.Scan(&row[0], &row[1])
+		if err != nil {
+			t.Fatalf("Scan error: %v", err)
+		}
+		got = append(got, row)
+	}
+	rows.Close()
+	if want := [][2]int64{{1, 1}, {2, 4}, {3, 9}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %v, want %v", got, want)
+	}
+
+	var n, square int64
+	err = db.QueryRow("SELECT * FROM squares WHERE n = ?", 12).Scan(&n, &square)
+	if err != nil {
+		t.Fatalf("QueryRow error: %v", err)
+	}
+	if n != 12 || square != 144 {
+		t.Errorf("got row (%d, %d), want (12, 144)", n, square)
+	}
+
+	result, err := db.Exec("DELETE FROM squares")
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected != 3 {
+		t.Errorf("got %d rows affected, want 3", affected)
+	}
+
+	_, err = db.Exec("SELECT * FROM missing")
+	if err == nil || !strings.Contains(err.Error(), "Table doesn't exist") {
+		t.Errorf("got error %v for a missing table", err)
+	}
+	_, err = db.Prepare("SELECT 1")
+	if err == nil {
+		t.Errorf("got no error for an unpreparable statement")
+	}
+
+	db.Close()
+	l.Close()
+
+	if h.attrs["app"] != "test" {
+		t.Errorf("got connection attributes %v, want app=test", h.attrs)
+	}
+	if want := []string{"SELECT * FROM squares WHERE n = ?"}; !reflect.DeepEqual(h.closed, want) {
+		t.Errorf("got closed statements %q, want %q", h.closed, want)
+	}
+}
+
+// rawClient connects to addr and logs in as user, with a handshake response
+// that names plugin as its authentication method. It returns the packets the
+// server answered with.
+func rawClient(t *testing.T, addr, user, password, plugin string) (*wire.PacketConn, []byte) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	pc := wire.NewPacketConn(nc, nc)
+
+	greeting, err := pc.ReadPacket()
+	if err != nil {
+		t.Fatalf("reading greeting: %v", err)
+	}
+	// The challenge is split in two, around the capability flags and such.
+	_, rest, _ := wire.ReadNullTerminated(greeting[1:])
+	challenge := append([]byte(nil), rest[4:12]...)
+	challenge = append(challenge, rest[31:43]...)
+
+	// The response to the challenge is meant for a different plugin, so it
+	// doesn't matter what it is.
+	response := binary.LittleEndian.AppendUint32(nil, wire.FlagProtocol41|wire.FlagSecureConn|wire.FlagPluginAuth)
+	response = append(response, make([]byte, 28)...)
+	response = append(response, user...)
+	response = append(response, 0, 1, 'x')
+	response = append(response, plugin...)
+	response = append(response, 0)
+	err = pc.WritePacket(response)
+	if err == nil {
+		err = pc.Flush()
+	}
+	if err != nil {
+		t.Fatalf("writing handshake response: %v", err)
+	}
+
+	reply, err := pc.ReadPacket()
+	if err != nil {
+		t.Fatalf("reading reply to handshake response: %v", err)
+	}
+	if reply[0] != 0xfe {
+		return pc, reply
+	}
+
+	// We were asked to switch to mysql_native_password.
+	name, rest, _ := wire.ReadNullTerminated(reply[1:])
+	if string(name) != wire.NativePassword || !reflect.DeepEqual(rest[:20], challenge) {
+		t.Fatalf("got auth switch request %q", reply)
+	}
+	err = pc.WritePacket(wire.ScramblePassword(challenge, password))
+	if err == nil {
+		err = pc.Flush()
+	}
+	if err != nil {
+		t.Fatalf("writing auth switch response: %v", err)
+	}
+
+	reply, err = pc.ReadPacket()
+	if err != nil {
+		t.Fatalf("reading reply to auth switch response: %v", err)
+	}
+	return pc, reply
+}
+
+// command sends a command, and returns the server's reply.
+func command(t *testing.T, pc *wire.PacketConn, cmd byte, arg string) []byte {
+	pc.Seq = 0
+	err := pc.WritePacket(append([]byte{cmd}, arg...))
+	if err == nil {
+		err = pc.Flush()
+	}
+	if err != nil {
+		t.Fatalf("writing command: %v", err)
+	}
+
+	reply, err := pc.ReadPacket()
+	if err != nil {
+		t.Fatalf("reading reply to command: %v", err)
+	}
+	return reply
+}
+
+func TestCommands(t *testing.T) {
+	h := &tableHandler{}
+	l := startListener(t, h, func(user string, check func(string) bool) bool {
+		return user == "app" && check("secret")
+	})
+
+	_, reply := rawClient(t, l.Addr().String(), "app", "wrong", "caching_sha2_password")
+	if reply[0] != 0xff || binary.LittleEndian.Uint16(reply[1:]) != 1045 {
+		t.Errorf("got reply %q to the wrong password, want error 1045", reply)
+	}
+
+	pc, reply := rawClient(t, l.Addr().String(), "app", "secret", "caching_sha2_password")
+	if reply[0] != 0x00 {
+		t.Fatalf("got reply %q to the right password, want OK", reply)
+	}
+
+	if reply = command(t, pc, wire.ComPing, ""); reply[0] != 0x00 {
+		t.Errorf("got reply %q to COM_PING, want OK", reply)
+	}
+	if reply = command(t, pc, wire.ComInitDB, "test"); reply[0] != 0x00 {
+		t.Errorf("got reply %q to COM_INIT_DB, want OK", reply)
+	}
+	if reply = command(t, pc, wire.ComInitDB, "missing"); reply[0] != 0xff || binary.LittleEndian.Uint16(reply[1:]) != 1049 {
+		t.Errorf("got reply %q to COM_INIT_DB of a missing database, want error 1049", reply)
+	}
+	if reply = command(t, pc, 0x42, ""); reply[0] != 0xff || binary.LittleEndian.Uint16(reply[1:]) != 1047 {
+		t.Errorf("got reply %q to an unknown command, want error 1047", reply)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pings != 1 || !reflect.DeepEqual(h.dbs, []string{"test"}) {
+		t.Errorf("got %d pings and databases %q, want 1 and [test]", h.pings, h.dbs)
+	}
+}
+
+func TestCountParams(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT 1", 0},
+		{"SELECT ?, ?", 2},
+		{"SELECT '?', \"?\", `?`, ?", 1},
+		{"SELECT 'it\\'s ?', ?", 1},
+		{"SELECT ? -- ?\n, ?", 2},
+		{"SELECT ? # ?\n, ?", 2},
+		{"SELECT /* ? */ ?", 1},
+	}
+	for _, test := range tests {
+		if got := CountParams(test.query); got != test.want {
+			t.Errorf("CountParams(%q) = %d, want %d", test.query, got, test.want)
+		}
+	}
+}
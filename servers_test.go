@@ -0,0 +1,81 @@
+package gms
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+// startTestServer starts srv, and closes it when the test is done.
+func startTestServer(t *testing.T, srv *gmstest.Server) *gmstest.Server {
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestServer starts a gmstest server, and closes it when the test is done.
+func newTestServer(t *testing.T) *gmstest.Server {
+	return startTestServer(t, gmstest.NewUnstartedServer())
+}
+
+// newReadOnlyServer starts a gmstest server that reports itself as read-only,
+// like a replica, and closes it when the test is done.
+func newReadOnlyServer(t *testing.T) *gmstest.Server {
+	srv := newTestServer(t)
+	srv.AddResult("SELECT @@global.read_only, @@global.super_read_only", gmstest.Result{
+		Columns: []gmstest.Column{{Name: "@@global.read_only"}, {Name: "@@global.super_read_only"}},
+		Rows:    [][]any{{1, 1}},
+	})
+	return srv
+}
+
+// answerAll has s answer every statement with an OK packet.
+func answerAll(s *gmstest.Server) {
+	s.HandleDefault(func(string, []any) gmstest.Result { return gmstest.Result{} })
+}
+
+// deadAddr returns the address of a port that refuses connections.
+func deadAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen error: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// openTestDB returns a database that connects to addr, and closes it when the
+// test is done. If configure isn't nil, it is called to change the Config
+// before the connector is made.
+func openTestDB(t *testing.T, addr string, configure func(cfg *Config)) *sql.DB {
+	cfg := NewConfig()
+	cfg.Addr = addr
+	if configure != nil {
+		configure(cfg)
+	}
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewConnector error: %v", err)
+	}
+
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openTestConn is like openTestDB, but returns a single connection from the
+// database.
+func openTestConn(t *testing.T, addr string, configure func(cfg *Config)) *sql.Conn {
+	db := openTestDB(t, addr, configure)
+
+	sqlConn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Conn error: %v", err)
+	}
+	t.Cleanup(func() { sqlConn.Close() })
+	return sqlConn
+}
@@ -0,0 +1,174 @@
+package gms
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The types of the entries in the session state that OK packets carry, when
+// CLIENT_SESSION_TRACK is in use.
+const (
+	sessionTrackSystemVariables = iota
+	sessionTrackSchema
+	sessionTrackStateChange
+	sessionTrackGTIDs
+	sessionTrackTransactionCharacteristics
+	sessionTrackTransactionState
+)
+
+// SessionState holds the changes to a connection's session that the server
+// reported at the end of a statement. What the server reports is controlled by
+// its session_track_* system variables, which may be set through
+// Config.Params. For example, GTIDs are only reported if session_track_gtids is
+// OWN_GTID or ALL_GTIDS.
+//
+// The SessionState of the last statement on a connection, and the last GTID
+// that it reported, are available through sql.Conn.Raw:
+//
+//	err := sqlConn.Raw(func(driverConn any) error {
+//		gtid := driverConn.(interface{ LastGTID() string }).LastGTID()
+//		...
+//	})
+//
+// The drv.Result of an Exec made on the driver connection has the same
+// SessionState method.
+type SessionState struct {
+	// If true, the session changed in some way, which the other fields may
+	// not fully describe.
+	Changed bool
+
+	// The system variables that changed, mapped to their new values.
+	Variables map[string]string
+
+	// The new default schema, if it changed.
+	Schema string
+
+	// The GTIDs of the transactions that the statement committed, if any.
+	GTIDs string
+
+	// Statements that would restore the characteristics of the current
+	// transaction, if they were tracked and changed.
+	TransactionCharacteristics string
+
+	// The state of the current transaction, if it was tracked and changed.
+	TransactionState string
+}
+
+// SessionState returns the changes to the session reported at the end of the
+// last statement.
+func (c *conn) SessionState() SessionState {
+	return c.session
+}
+
+// LastGTID returns the GTIDs most recently reported by the server, which are
+// those of the last transaction committed on the connection, if the server is
+// tracking them.
+func (c *conn) LastGTID() string {
+	return c.lastGTID
+}
+
+var errBadSessionState = errors.New("malformed session state in OK packet")
+
+// readSessionState reads the session state from the rest of an OK packet, and
+// records it on the connection.
+func (c *conn) readSessionState() (SessionState, error) {
+	var state SessionState
+
+	size, err := c.ReadLengthEncodedInt(c)
+	if err != nil {
+		return state, err
+	}
+	if size > uint64(c.framer.Remaining()) {
+		return state, errBadSessionState
+	}
+
+	buf := make([]byte, size)
+	err = readExactly(c, buf)
+	if err != nil {
+		return state, err
+	}
+
+	state.Changed = true
+	for len(buf) > 0 {
+		entryType := buf[0]
+
+		var data []byte
+		data, buf = lengthEncodedBytes(buf[1:])
+		if data == nil {
+			return state, errBadSessionState
+		}
+
+		switch entryType {
+		case sessionTrackSystemVariables:
+			name, rest := lengthEncodedBytes(data)
+			value, _ := lengthEncodedBytes(rest)
+			if name == nil || value == nil {
+				return state, errBadSessionState
+			}
+			if state.Variables == nil {
+				state.Variables = map[string]string{}
+			}
+			state.Variables[string(name)] = string(value)
+			if string(name) == "character_set_client" {
+				c.clientCharset = string(value)
+			}
+		case sessionTrackSchema:
+			schema, _ := lengthEncodedBytes(data)
+			if schema == nil {
+				return state, errBadSessionState
+			}
+			state.Schema = string(schema)
+		case sessionTrackGTIDs:
+			// The GTIDs are preceded by the encoding they are in, of
+			// which there is only one.
+			if len(data) < 1 {
+				return state, errBadSessionState
+			}
+			gtids, _ := lengthEncodedBytes(data[1:])
+			if gtids == nil {
+				return state, errBadSessionState
+			}
+			state.GTIDs = string(gtids)
+			c.lastGTID = state.GTIDs
+		case sessionTrackTransactionCharacteristics:
+			characteristics, _ := lengthEncodedBytes(data)
+			state.TransactionCharacteristics = string(characteristics)
+		case sessionTrackTransactionState:
+			trxState, _ := lengthEncodedBytes(data)
+			state.TransactionState = string(trxState)
+		}
+
+		// Entries of unknown types, and state change entries, which only
+		// say that something changed, need no more handling.
+	}
+
+	return state, nil
+}
+
+// lengthEncodedBytes returns the length-encoded string at the start of b, and
+// the rest of b. If b does not start with a complete length-encoded string,
+// it returns a nil string.
+func lengthEncodedBytes(b []byte) ([]byte, []byte) {
+	if len(b) == 0 {
+		return nil, b
+	}
+
+	var size uint64
+	switch first := b[0]; {
+	case first < 0xfb:
+		size, b = uint64(first), b[1:]
+	case first == 0xfc && len(b) >= 3:
+		size, b = uint64(binary.LittleEndian.Uint16(b[1:3])), b[3:]
+	case first == 0xfd && len(b) >= 4:
+		size, b = uint64(b[1])|uint64(b[2])<<8|uint64(b[3])<<16, b[4:]
+	case first == 0xfe && len(b) >= 9:
+		size, b = binary.LittleEndian.Uint64(b[1:9]), b[9:]
+	default:
+		return nil, b
+	}
+
+	if size > uint64(len(b)) {
+		return nil, b
+	}
+	return b[:size:size], b[size:]
+}
@@ -0,0 +1,142 @@
+package gms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+func TestSessionTrack(t *testing.T) {
+	const gtid = "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+	s := gmstest.NewUnstartedServer()
+	s.SessionTrack = true
+	startTestServer(t, s)
+	s.AddResult("INSERT INTO t VALUES (1)", gmstest.Result{
+		AffectedRows: 1,
+		Session: &gmstest.SessionState{
+			Variables: map[string]string{"autocommit": "ON"},
+			Schema:    "test",
+			GTIDs:     gtid,
+		},
+	})
+
+	sqlConn := openTestConn(t, s.Addr, nil)
+
+	want := SessionState{
+		Changed:   true,
+		Variables: map[string]string{"autocommit": "ON"},
+		Schema:    "test",
+		GTIDs:     gtid,
+	}
+
+	err := sqlConn.Raw(func(driverConn any) error {
+		c := driverConn.(*conn)
+
+		result, err := c.ExecContext(context.Background(), "INSERT INTO t VALUES (1)", nil)
+		if err != nil {
+			t.Fatalf("ExecContext error: %v", err)
+		}
+
+		got := result.(interface{ SessionState() SessionState }).SessionState()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Result.SessionState() = %+v, want %+v", got, want)
+		}
+		if got := c.SessionState(); !reflect.DeepEqual(got, want) {
+			t.Errorf("SessionState() = %+v, want %+v", got, want)
+		}
+		if got := c.LastGTID(); got != gtid {
+			t.Errorf("LastGTID() = %q, want %q", got, gtid)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Raw error: %v", err)
+	}
+}
+
+func TestSessionTrackPreparedQuery(t *testing.T) {
+	const gtid = "3e11fa47-71ca-11e1-9e33-c80aa9429562:24"
+	s := gmstest.NewUnstartedServer()
+	s.SessionTrack = true
+	startTestServer(t, s)
+	s.Handle("UPDATE t SET a = ?", func(args []any) gmstest.Result {
+		return gmstest.Result{AffectedRows: 1, Session: &gmstest.SessionState{GTIDs: gtid}}
+	})
+
+	sqlConn := openTestConn(t, s.Addr, nil)
+
+	// A statement without a result set, run with Query, ends in an OK packet
+	// that still carries the session state.
+	rows, err := sqlConn.QueryContext(context.Background(), "UPDATE t SET a = ?", 1)
+	if err != nil {
+		t.Fatalf("QueryContext error: %v", err)
+	}
+	rows.Close()
+
+	err = sqlConn.Raw(func(driverConn any) error {
+		if got := driverConn.(*conn).LastGTID(); got != gtid {
+			t.Errorf("LastGTID() = %q, want %q", got, gtid)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Raw error: %v", err)
+	}
+}
+
+func TestSessionTrackCharset(t *testing.T) {
+	s := gmstest.NewUnstartedServer()
+	s.SessionTrack = true
+	startTestServer(t, s)
+	s.AddResult("SET NAMES gbk", gmstest.Result{
+		Session: &gmstest.SessionState{
+			Variables: map[string]string{"character_set_client": "gbk"},
+		},
+	})
+	s.AddResult("INSERT INTO t VALUES (?)", gmstest.Result{AffectedRows: 1})
+
+	db := openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.InterpolateParams = true
+	})
+	db.SetMaxOpenConns(1)
+
+	_, err := db.Exec("SET NAMES gbk")
+	if err != nil {
+		t.Fatalf("SET NAMES error: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO t VALUES (?)", "\xbf' OR 1=1 -- ")
+	if err != nil {
+		t.Fatalf("INSERT error: %v", err)
+	}
+
+	// Once the server decodes queries as gbk, the argument can't be escaped
+	// safely, so it is sent in a prepared statement instead.
+	statements := s.Statements()
+	last := statements[len(statements)-1]
+	if !last.Prepared {
+		t.Errorf("INSERT was interpolated into %q after a change to gbk", last.Query)
+	}
+}
+
+func TestLengthEncodedBytes(t *testing.T) {
+	tests := []struct {
+		in         []byte
+		want, rest []byte
+	}{
+		{[]byte{3, 'a', 'b', 'c', 'd'}, []byte("abc"), []byte("d")},
+		{[]byte{0}, []byte{}, []byte{}},
+		{[]byte{0xfc, 2, 0, 'a', 'b'}, []byte("ab"), []byte{}},
+		{[]byte{4, 'a'}, nil, []byte{'a'}},
+		{[]byte{0xfc, 2}, nil, []byte{0xfc, 2}},
+		{nil, nil, nil},
+	}
+
+	for _, test := range tests {
+		got, rest := lengthEncodedBytes(test.in)
+		if (got == nil) != (test.want == nil) || string(got) != string(test.want) || string(rest) != string(test.rest) {
+			t.Errorf("lengthEncodedBytes(%v) = (%v, %v), want (%v, %v)", test.in, got, rest, test.want, test.rest)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package gms
+
+import (
+	"context"
+	drv "database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// SplitConfig holds the settings for a SplitConnector.
+type SplitConfig struct {
+	// The settings for connecting to the primary, and to the replicas. To
+	// spread reads over several replicas, list them all in Replica.Addr, with
+	// a HostStrategy of HostRandom or HostRoundRobin.
+	Primary *Config
+	Replica *Config
+
+	// If non-zero, a replica is only read from while it is at most this far
+	// behind the primary, as reported by SHOW REPLICA STATUS.
+	MaxReplicaLag time.Duration
+
+	// How often a replica's lag is checked, and how long a replica that
+	// failed to connect is avoided for. Defaults to a second.
+	CheckInterval time.Duration
+}
+
+// SplitConnector is a drv.Connector whose connections split statements between
+// a primary and its replicas. Each connection sends a SELECT to a replica,
+// unless it is in a transaction, or its context was made with WithPrimary. All
+// other statements, and all statements in transactions, go to the primary,
+// whether the transaction was begun with BeginTx, in SQL, or by turning
+// autocommit off.
+// Whenever no replica is available, reads go to the primary as well.
+//
+// A SELECT that takes locks, or that depends on the session, such as one that
+// reads LAST_INSERT_ID() or a user variable, also goes to the primary. A SELECT
+// from a temporary table can't be recognized, and must be run with WithPrimary.
+type SplitConnector struct {
+	primary *connector
+	replica *connector
+
+	maxReplicaLag time.Duration
+	checkInterval time.Duration
+}
+
+// NewSplitConnector returns a SplitConnector with the settings in cfg, for use
+// with sql.OpenDB.
+func NewSplitConnector(cfg SplitConfig) (*SplitConnector, error) {
+	if cfg.Primary == nil || cfg.Replica == nil {
+		return nil, errors.New("SplitConfig needs both a primary and a replica")
+	}
+
+	primary, err := NewConnector(cfg.Primary)
+	if err != nil {
+		return nil, err
+	}
+
+	replica, err := NewConnector(cfg.Replica)
+	if err != nil {
+		return nil, err
+	}
+
+	checkInterval := cfg.CheckInterval
+	if checkInterval <= 0 {
+		checkInterval = time.Second
+	}
+
+	return &SplitConnector{
+		primary:       primary.(*connector),
+		replica:       replica.(*connector),
+		maxReplicaLag: cfg.MaxReplicaLag,
+		checkInterval: checkInterval,
+	}, nil
+}
+
+// Connect opens a connection to the primary. A connection to a replica is only
+// opened once there is something to read from it.
+func (sc *SplitConnector) Connect(ctx context.Context) (drv.Conn, error) {
+	primary, err := sc.primary.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &splitConn{sc: sc, primary: primary.(*conn)}, nil
+}
+
+func (sc *SplitConnector) Driver() drv.Driver {
+	return &driver{}
+}
+
+type primaryKey struct{}
+
+// WithPrimary returns a copy of ctx that sends all statements run with it to
+// the primary, when used with a SplitConnector. This is useful to read one's
+// own writes, which a replica may not have yet.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// splitConn is a connection opened by a SplitConnector.
+type splitConn struct {
+	sc *SplitConnector
+
+	primary *conn
+
+	// The connection to a replica, or nil if there isn't one yet.
+	replica *conn
+
+	// If true, a transaction begun with BeginTx is in progress on the
+	// primary. One begun in SQL, or by turning autocommit off, is only known
+	// from the primary's status flags.
+	inTx bool
+
+	// Until replicaCheck, the replica is used if and only if replicaOK is
+	// true. After that, we try to connect to it again if we had failed to,
+	// and check its lag again.
+	replicaCheck time.Time
+	replicaOK    bool
+}
+
+// route returns the connection that query should be sent to.
+func (s *splitConn) route(ctx context.Context, query string) *conn {
+	if s.inTx || s.primary.status&statusInTrans != 0 || ctx.Value(primaryKey{}) != nil || !isSelect(query) {
+		return s.primary
+	}
+
+	if r := s.replicaConn(ctx); r != nil {
+		return r
+	}
+	return s.primary
+}
+
+// replicaConn returns the connection to a replica, or nil if there is no
+// replica that can be read from right now.
+func (s *splitConn) replicaConn(ctx context.Context) *conn {
+	now := time.Now()
+	if now.Before(s.replicaCheck) {
+		if s.replicaOK {
+			return s.replica
+		}
+		return nil
+	}
+	s.replicaCheck = now.Add(s.sc.checkInterval)
+	s.replicaOK = false
+
+	if s.replica == nil {
+		r, err := s.sc.replica.Connect(ctx)
+		if err != nil {
+			return nil
+		}
+		s.replica = r.(*conn)
+	}
+
+	if s.sc.maxReplicaLag > 0 {
+		lag, err := s.replica.replicaLag(ctx)
+		if err != nil {
+			// The connection may be broken, so we start over next time.
+			s.replica.Close()
+			s.replica = nil
+			return nil
+		}
+		if lag < 0 || lag > s.sc.maxReplicaLag {
+			return nil
+		}
+	}
+
+	s.replicaOK = true
+	return s.replica
+}
+
+func (s *splitConn) Prepare(query string) (drv.Stmt, error) {
+	return s.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares query on the connection it would be sent to. A
+// SELECT is returned as a splitStmt, which is prepared again on another
+// connection when one of its executions is routed there.
+func (s *splitConn) PrepareContext(ctx context.Context, query string) (drv.Stmt, error) {
+	c := s.route(ctx, query)
+	st, err := c.PrepareContext(ctx, query)
+	if err != nil || !isSelect(query) {
+		return st, err
+	}
+
+	ss := &splitStmt{s: s, query: query, numInput: st.NumInput()}
+	if c == s.primary {
+		ss.primary = st.(*stmt)
+	} else {
+		ss.replica = st.(*stmt)
+	}
+	return ss, nil
+}
+
+func (s *splitConn) ExecContext(ctx context.Context, query string, args []drv.NamedValue) (drv.Result, error) {
+	return s.route(ctx, query).ExecContext(ctx, query, args)
+}
+
+func (s *splitConn) QueryContext(ctx context.Context, query string, args []drv.NamedValue) (drv.Rows, error) {
+	return s.route(ctx, query).QueryContext(ctx, query, args)
+}
+
+func (s *splitConn) Begin() (drv.Tx, error) {
+	return s.BeginTx(context.Background(), drv.TxOptions{})
+}
+
+func (s *splitConn) BeginTx(ctx context.Context, opts drv.TxOptions) (drv.Tx, error) {
+	t, err := s.primary.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.inTx = true
+	return &splitTx{Tx: t, s: s}, nil
+}
+
+func (s *splitConn) ResetSession(ctx context.Context) error {
+	if s.replica != nil {
+		err := s.replica.ResetSession(ctx)
+		if err != nil {
+			// We can carry on without this replica connection.
+			s.replica.Close()
+			s.replica = nil
+		}
+	}
+	return s.primary.ResetSession(ctx)
+}
+
+// ServerInfo returns the information from the primary's greeting.
+func (s *splitConn) ServerInfo() ServerInfo {
+	return s.primary.ServerInfo()
+}
+
+// LastGTID returns the GTIDs last reported by the primary.
+func (s *splitConn) LastGTID() string {
+	return s.primary.LastGTID()
+}
+
+func (s *splitConn) Close() error {
+	if s.replica != nil {
+		s.replica.Close()
+	}
+	return s.primary.Close()
+}
+
+// splitTx is a transaction on the primary of a splitConn, which sends reads to
+// the primary until it is done.
+type splitTx struct {
+	drv.Tx
+	s *splitConn
+}
+
+func (t *splitTx) Commit() error {
+	t.s.inTx = false
+	return t.Tx.Commit()
+}
+
+func (t *splitTx) Rollback() error {
+	t.s.inTx = false
+	return t.Tx.Rollback()
+}
+
+// splitStmt is a SELECT prepared by a splitConn. Like a query, each execution
+// is routed to the primary or to the replica, where the statement is prepared
+// on first use. In particular, a statement prepared outside of a transaction,
+// and then used in one, runs on the primary.
+type splitStmt struct {
+	s        *splitConn
+	query    string
+	numInput int
+
+	// The statement as prepared on the primary, and on the replica, or nil
+	// if it hasn't been prepared there yet.
+	primary *stmt
+	replica *stmt
+}
+
+// stmt returns the statement prepared on the connection that an execution with
+// ctx should be sent to, preparing it if need be.
+func (ss *splitStmt) stmt(ctx context.Context) (*stmt, error) {
+	c := ss.s.route(ctx, ss.query)
+	if c == ss.s.primary {
+		if ss.primary == nil {
+			st, err := c.PrepareContext(ctx, ss.query)
+			if err != nil {
+				return nil, err
+			}
+			ss.primary = st.(*stmt)
+		}
+		return ss.primary, nil
+	}
+
+	// The replica that the statement was prepared on may have been closed,
+	// and replaced, since. The server freed the statement along with its
+	// connection.
+	if ss.replica == nil || ss.replica.c != c {
+		st, err := c.PrepareContext(ctx, ss.query)
+		if err != nil {
+			return nil, err
+		}
+		ss.replica = st.(*stmt)
+	}
+	return ss.replica, nil
+}
+
+func (ss *splitStmt) Close() error {
+	var err error
+	if ss.replica != nil && ss.replica.c == ss.s.replica {
+		err = ss.replica.Close()
+	}
+	if ss.primary != nil {
+		if perr := ss.primary.Close(); perr != nil {
+			err = perr
+		}
+	}
+	ss.primary, ss.replica = nil, nil
+	return err
+}
+
+func (ss *splitStmt) NumInput() int {
+	return ss.numInput
+}
+
+func (ss *splitStmt) Exec(args []drv.Value) (drv.Result, error) {
+	st, err := ss.stmt(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return st.Exec(args)
+}
+
+func (ss *splitStmt) Query(args []drv.Value) (drv.Rows, error) {
+	st, err := ss.stmt(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return st.Query(args)
+}
+
+func (ss *splitStmt) ExecContext(ctx context.Context, args []drv.NamedValue) (drv.Result, error) {
+	st, err := ss.stmt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.ExecContext(ctx, args)
+}
+
+func (ss *splitStmt) QueryContext(ctx context.Context, args []drv.NamedValue) (drv.Rows, error) {
+	st, err := ss.stmt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.QueryContext(ctx, args)
+}
+
+// replicaLag returns how far the server is behind its source, as reported by
+// SHOW REPLICA STATUS, or SHOW SLAVE STATUS on servers that predate it. A
+// negative lag means that replication is not running. A server that is not a
+// replica is not behind at all.
+func (c *conn) replicaLag(ctx context.Context) (time.Duration, error) {
+	rows, err := c.queryContext(ctx, "SHOW REPLICA STATUS", nil)
+	if _, ok := err.(*serverError); ok {
+		rows, err = c.queryContext(ctx, "SHOW SLAVE STATUS", nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	lagIdx := -1
+	for i, name := range rows.Columns() {
+		name = name[strings.LastIndexByte(name, '.')+1:]
+		if name == "Seconds_Behind_Source" || name == "Seconds_Behind_Master" {
+			lagIdx = i
+		}
+	}
+
+	dest := make([]drv.Value, len(rows.Columns()))
+	err = rows.Next(dest)
+	if err == io.EOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if lagIdx == -1 {
+		return 0, errors.New("replica status has no Seconds_Behind_Source column")
+	}
+
+	switch lag := dest[lagIdx].(type) {
+	case int64:
+		return time.Duration(lag) * time.Second, nil
+	case nil:
+		return -1, nil
+	}
+	return 0, errors.New("unexpected Seconds_Behind_Source value")
+}
+
+// isSelect returns true if query is a SELECT that takes no locks, and depends
+// on nothing in the session, and so may be sent to a replica.
+func isSelect(query string) bool {
+	query = strings.TrimLeft(skipComments(query), " \t\r\n(")
+	if len(query) < len("SELECT") || !strings.EqualFold(query[:len("SELECT")], "SELECT") {
+		return false
+	}
+
+	upper := strings.Join(strings.Fields(strings.ToUpper(withoutQuoted(query))), " ")
+	for _, s := range primaryOnly {
+		if strings.Contains(upper, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// primaryOnly holds the parts of a SELECT that mean it must be sent to the
+// primary: locking clauses, and what reads or changes the state of the
+// session, which a replica's session doesn't share. That includes user and
+// session variables, whose names start with '@', and SELECT ... INTO. Reads of
+// a temporary table can't be told apart from others, and need WithPrimary.
+var primaryOnly = []string{
+	" FOR UPDATE",
+	" FOR SHARE",
+	" LOCK IN SHARE MODE",
+	"@",
+	" INTO ",
+	"LAST_INSERT_ID",
+	"FOUND_ROWS",
+	"ROW_COUNT",
+	"CONNECTION_ID",
+	"GET_LOCK",
+	"RELEASE_LOCK",
+	"RELEASE_ALL_LOCKS",
+	"IS_FREE_LOCK",
+	"IS_USED_LOCK",
+	"NEXTVAL",
+	"LASTVAL",
+	"NEXT VALUE FOR",
+	"PREVIOUS VALUE FOR",
+}
+
+// withoutQuoted returns query with the contents of its quoted strings and
+// identifiers removed, so that they aren't mistaken for SQL.
+func withoutQuoted(query string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if ch == '\'' || ch == '"' || ch == '`' {
+			i = skipQuoted(query, i, ch == '`') - 1
+			b.WriteString("''")
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// skipComments returns query without any leading whitespace and comments.
+func skipComments(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n")
+		switch {
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+		case strings.HasPrefix(query, "#") || strings.HasPrefix(query, "-- "):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		default:
+			return query
+		}
+	}
+}
+
+var (
+	_ drv.Connector          = (*SplitConnector)(nil)
+	_ drv.Conn               = (*splitConn)(nil)
+	_ drv.ConnPrepareContext = (*splitConn)(nil)
+	_ drv.ConnBeginTx        = (*splitConn)(nil)
+	_ drv.ExecerContext      = (*splitConn)(nil)
+	_ drv.QueryerContext     = (*splitConn)(nil)
+	_ drv.SessionResetter    = (*splitConn)(nil)
+	_ drv.StmtExecContext    = (*splitStmt)(nil)
+	_ drv.StmtQueryContext   = (*splitStmt)(nil)
+)
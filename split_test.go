@@ -0,0 +1,234 @@
+package gms
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+)
+
+// receivedQueries returns the text of the statements s has received, and forgets
+// them.
+func receivedQueries(s *gmstest.Server) []string {
+	var queries []string
+	for _, st := range s.Statements() {
+		queries = append(queries, st.Query)
+	}
+	return queries
+}
+
+func TestSplitConnector(t *testing.T) {
+	primary := newTestServer(t)
+	replica := newReadOnlyServer(t)
+	answerAll(primary)
+	answerAll(replica)
+
+	cfg := SplitConfig{Primary: NewConfig(), Replica: NewConfig()}
+	cfg.Primary.Addr = primary.Addr
+	cfg.Replica.Addr = replica.Addr
+
+	sc, err := NewSplitConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewSplitConnector error: %v", err)
+	}
+
+	db := sql.OpenDB(sc)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	queries := []struct {
+		ctx   context.Context
+		query string
+	}{
+		{ctx, "SELECT 1"},
+		{ctx, "/* hint */ select 2"},
+		{ctx, "SELECT 3 FOR UPDATE"},
+		{ctx, "SELECT LAST_INSERT_ID()"},
+		{ctx, "SELECT @v"},
+		{ctx, "SELECT 'a@b' AS `FOUND_ROWS`"},
+		{ctx, "INSERT INTO t VALUES (4)"},
+		{WithPrimary(ctx), "SELECT 5"},
+	}
+	for _, q := range queries {
+		rows, err := db.QueryContext(q.ctx, q.query)
+		if err != nil {
+			t.Fatalf("QueryContext(%q) error: %v", q.query, err)
+		}
+		rows.Close()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+	rows, err := tx.Query("SELECT 6")
+	if err != nil {
+		t.Fatalf("Query in transaction error: %v", err)
+	}
+	rows.Close()
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit error: %v", err)
+	}
+
+	rows, err = db.Query("SELECT 7")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	rows.Close()
+
+	wantPrimary := []string{"SELECT @@max_allowed_packet", "SELECT 3 FOR UPDATE", "SELECT LAST_INSERT_ID()", "SELECT @v", "INSERT INTO t VALUES (4)", "SELECT 5", "START TRANSACTION", "SELECT 6", "COMMIT"}
+	if got := receivedQueries(primary); !reflect.DeepEqual(got, wantPrimary) {
+		t.Errorf("primary got queries %q, want %q", got, wantPrimary)
+	}
+
+	wantReplica := []string{"SELECT @@max_allowed_packet", "SELECT 1", "/* hint */ select 2", "SELECT 'a@b' AS `FOUND_ROWS`", "SELECT 7"}
+	if got := receivedQueries(replica); !reflect.DeepEqual(got, wantReplica) {
+		t.Errorf("replica got queries %q, want %q", got, wantReplica)
+	}
+}
+
+func TestSplitConnectorSQLTx(t *testing.T) {
+	primary := newTestServer(t)
+	replica := newReadOnlyServer(t)
+	answerAll(primary)
+	answerAll(replica)
+
+	cfg := SplitConfig{Primary: NewConfig(), Replica: NewConfig()}
+	cfg.Primary.Addr = primary.Addr
+	cfg.Replica.Addr = replica.Addr
+
+	sc, err := NewSplitConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewSplitConnector error: %v", err)
+	}
+
+	db := sql.OpenDB(sc)
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn error: %v", err)
+	}
+	defer c.Close()
+
+	// The driver only learns of a transaction begun in SQL from the status
+	// flags that the primary sends back.
+	for _, query := range []string{"BEGIN", "SELECT * FROM t", "COMMIT", "SELECT 1"} {
+		_, err := c.ExecContext(ctx, query)
+		if err != nil {
+			t.Fatalf("ExecContext(%q) error: %v", query, err)
+		}
+	}
+
+	wantPrimary := []string{"SELECT @@max_allowed_packet", "BEGIN", "SELECT * FROM t", "COMMIT"}
+	if got := receivedQueries(primary); !reflect.DeepEqual(got, wantPrimary) {
+		t.Errorf("primary got queries %q, want %q", got, wantPrimary)
+	}
+
+	wantReplica := []string{"SELECT @@max_allowed_packet", "SELECT 1"}
+	if got := receivedQueries(replica); !reflect.DeepEqual(got, wantReplica) {
+		t.Errorf("replica got queries %q, want %q", got, wantReplica)
+	}
+}
+
+func TestSplitConnectorStmtInTx(t *testing.T) {
+	primary := newTestServer(t)
+	replica := newReadOnlyServer(t)
+	for _, s := range []*gmstest.Server{primary, replica} {
+		s.Handle("SELECT ?", func(args []any) gmstest.Result {
+			return gmstest.Result{
+				Columns: []gmstest.Column{{Name: "n", Type: gmstest.TypeBigInt}},
+				Rows:    [][]any{args},
+			}
+		})
+	}
+
+	cfg := SplitConfig{Primary: NewConfig(), Replica: NewConfig()}
+	cfg.Primary.Addr = primary.Addr
+	cfg.Replica.Addr = replica.Addr
+
+	sc, err := NewSplitConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewSplitConnector error: %v", err)
+	}
+
+	db := sql.OpenDB(sc)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// The statement is prepared on the replica, but must run on the primary
+	// inside the transaction.
+	st, err := db.Prepare("SELECT ?")
+	if err != nil {
+		t.Fatalf("Prepare error: %v", err)
+	}
+	defer st.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+	var n int64
+	err = tx.Stmt(st).QueryRow(int64(1)).Scan(&n)
+	if err != nil {
+		t.Fatalf("QueryRow in transaction error: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit error: %v", err)
+	}
+
+	err = st.QueryRow(int64(2)).Scan(&n)
+	if err != nil {
+		t.Fatalf("QueryRow error: %v", err)
+	}
+
+	// executions returns the arguments of the prepared executions s received.
+	executions := func(s *gmstest.Server) [][]any {
+		var args [][]any
+		for _, st := range s.Statements() {
+			if st.Prepared {
+				args = append(args, st.Args)
+			}
+		}
+		return args
+	}
+	if got, want := executions(primary), [][]any{{int64(1)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("primary executed with %v, want %v", got, want)
+	}
+	if got, want := executions(replica), [][]any{{int64(2)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replica executed with %v, want %v", got, want)
+	}
+}
+
+func TestSplitConnectorReplicaDown(t *testing.T) {
+	primary := newTestServer(t)
+	answerAll(primary)
+
+	cfg := SplitConfig{Primary: NewConfig(), Replica: NewConfig()}
+	cfg.Primary.Addr = primary.Addr
+	cfg.Replica.Addr = deadAddr(t)
+
+	sc, err := NewSplitConnector(cfg)
+	if err != nil {
+		t.Fatalf("NewSplitConnector error: %v", err)
+	}
+
+	db := sql.OpenDB(sc)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT 1")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	rows.Close()
+
+	if got, want := receivedQueries(primary), []string{"SELECT @@max_allowed_packet", "SELECT 1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("primary got queries %q, want %q", got, want)
+	}
+}
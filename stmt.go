@@ -1,6 +1,7 @@
 package gms
 
 import (
+	"context"
 	drv "database/sql/driver"
 	"encoding/binary"
 	"errors"
@@ -38,6 +39,14 @@ type stmt struct {
 	// The id of this statement, as assigned by the MySQL server
 	id uint32
 
+	// The SQL text the statement was prepared from.
+	sqlStr string
+
+	// The connection's resets when the statement was prepared. Once the
+	// session has been reset, the server no longer knows the statement, and
+	// it must be prepared again.
+	resets int
+
 	// Descriptors for the input and output fields respectively. In MySQL
 	// parlance, these are the params and the columns respectively.
 	inputFields  []inputFieldData
@@ -46,7 +55,17 @@ type stmt struct {
 
 func (s *stmt) Close() error {
 	c := s.c
-	c.seqId = 0
+
+	// A statement that the server freed when the session was reset has
+	// nothing left to close, and its id may have been reused.
+	if s.resets != c.resets {
+		s.c = nil
+		s.inputFields = nil
+		s.outputFields = nil
+		return nil
+	}
+
+	c.resetSeq()
 
 	c.scratch[0] = comStmtClose
 	binary.LittleEndian.PutUint32(c.scratch[1:5], s.id)
@@ -71,59 +90,69 @@ func (s *stmt) Close() error {
 }
 
 func (s *stmt) Exec(params []drv.Value) (drv.Result, error) {
-	err := s.sendQuery(params)
+	return s.exec(context.Background(), params, nil)
+}
+
+// ExecContext is like Exec, except that the query attributes in ctx are sent
+// along with the statement.
+func (s *stmt) ExecContext(ctx context.Context, args []drv.NamedValue) (drv.Result, error) {
+	params, attrs, err := stmtArgs(ctx, args)
 	if err != nil {
 		return nil, err
 	}
+	return s.exec(ctx, params, attrs)
+}
 
+func (s *stmt) exec(ctx context.Context, params []drv.Value, attrs []queryAttribute) (drv.Result, error) {
 	c := s.c
-	err = c.AdvancePacket()
-	if err != nil {
-		return nil, err
-	}
+	h := c.startHook(s.sqlStr, len(params))
+	c.startQueryLog(s.sqlStr, len(params))
 
-	err = readExactly(c, c.scratch[:1])
+	err := s.sendQuery(params, attrs)
 	if err != nil {
+		c.finishExecLog(ctx, nil, err)
+		h.finishExec(ctx, nil, err)
 		return nil, err
 	}
 
-	if c.scratch[0] == 0xff {
-		// This is an error packet
-		return nil, c.ErrorFromErrPacket()
-	} else if c.scratch[0] != 0x00 {
-		// This query has result rows. The user is not interested in these, so
-		// we simply skip over them until we find 2 seperate EOF packets.
-		for i := 0; i < 2; i++ {
-			err = c.SkipPacketsUntilEOFPacket()
-			if err != nil {
-				return nil, err
-			}
-		}
-		return unknownResults(0), nil
-	}
+	result, err := c.readExecResult()
+	c.finishExecLog(ctx, result, err)
+	h.finishExec(ctx, result, err)
+	return result, err
+}
 
-	// Otherwise, this is an OK packet, and we can simply pull out the number
-	// of affected rows and last insert id.
+func (s *stmt) NumInput() int {
+	return len(s.inputFields)
+}
 
-	affRows, err := c.ReadLengthEncodedInt(c)
-	if err != nil {
-		return nil, err
-	}
+func (s *stmt) Query(args []drv.Value) (drv.Rows, error) {
+	return s.query(context.Background(), args, nil)
+}
 
-	lastInsertId, err := c.ReadLengthEncodedInt(c)
+// QueryContext is like Query, except that the query attributes in ctx are
+// sent along with the statement.
+func (s *stmt) QueryContext(ctx context.Context, args []drv.NamedValue) (drv.Rows, error) {
+	params, attrs, err := stmtArgs(ctx, args)
 	if err != nil {
 		return nil, err
 	}
-
-	return results{affectedRows: int64(affRows), lastInsertId: int64(lastInsertId)}, nil
+	return s.query(ctx, params, attrs)
 }
 
-func (s *stmt) NumInput() int {
-	return len(s.inputFields)
+func (s *stmt) query(ctx context.Context, args []drv.Value, attrs []queryAttribute) (drv.Rows, error) {
+	c := s.c
+	h := c.startHook(s.sqlStr, len(args))
+	c.startQueryLog(s.sqlStr, len(args))
+	rows, err := s.readRows(args, attrs)
+	c.finishQueryLog(ctx, rows, err)
+	h.finishQuery(ctx, rows, err)
+	return rows, err
 }
 
-func (s *stmt) Query(args []drv.Value) (drv.Rows, error) {
-	err := s.sendQuery(args)
+// readRows executes the statement with args, and reads the response, up to the
+// first row of its result set.
+func (s *stmt) readRows(args []drv.Value, attrs []queryAttribute) (drv.Rows, error) {
+	err := s.sendQuery(args, attrs)
 	if err != nil {
 		return nil, err
 	}
@@ -144,35 +173,99 @@ func (s *stmt) Query(args []drv.Value) (drv.Rows, error) {
 		return nil, c.ErrorFromErrPacket()
 	} else if c.scratch[0] == 0x00 {
 		// This is an OK packet, meaning no rows were there to be read.
-		err = c.AdvanceToEOF()
+		_, err = c.readOKPacket()
 		if err != nil {
 			return nil, err
 		}
 
 		return &resultIter{
-			atEOF: true,
-			c:     c,
-			s:     s,
+			atEOF:    true,
+			c:        c,
+			fields:   s.outputFields,
+			warnings: c.warnings,
 		}, nil
 	}
 
-	// We don't need to read the column definitions because we parsed them when
-	// we prepared the statement.
-	err = c.SkipPacketsUntilEOFPacket()
+	// We don't need the column definitions, because we parsed them when we
+	// prepared the statement. With MariaDB's metadata caching, the server
+	// only sends them again if they changed, and we keep the new ones.
+	numColumns, metadataFollows, err := c.readColumnCount()
 	if err != nil {
 		return nil, err
 	}
 
-	return &resultIter{c: c, s: s}, nil
+	switch {
+	case !metadataFollows && int(numColumns) != len(s.outputFields):
+		return nil, fmt.Errorf("server omitted the definitions of %d columns, but %d were prepared", numColumns, len(s.outputFields))
+	case metadataFollows && c.clientMariaDBFlags&mariadbFlagCacheMetadata != 0:
+		fields := make([]outputFieldData, numColumns)
+		for i := range fields {
+			err = c.ReadFieldDefinition(&fields[i].field)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.outputFields = fields
+	case metadataFollows:
+		for i := uint64(0); i < numColumns; i++ {
+			err = c.AdvancePacket()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if metadataFollows {
+		err = c.readEndOfDefinitions()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resultIter{c: c, fields: s.outputFields}, nil
+}
+
+// reprepare prepares the statement again if the session has been reset since
+// it was prepared.
+func (s *stmt) reprepare() error {
+	if s.resets == s.c.resets {
+		return nil
+	}
+
+	prepared, err := s.c.prepare(s.sqlStr)
+	if err != nil {
+		return err
+	}
+
+	fresh := prepared.(*stmt)
+	s.id = fresh.id
+	s.inputFields = fresh.inputFields
+	s.outputFields = fresh.outputFields
+	s.resets = fresh.resets
+	return nil
 }
 
-func (s *stmt) sendQuery(params []drv.Value) error {
+func (s *stmt) sendQuery(params []drv.Value, attrs []queryAttribute) error {
+	err := s.reprepare()
+	if err != nil {
+		return err
+	}
+
 	if len(s.inputFields) != len(params) {
 		return errors.New("field count mismatch")
 	}
 
 	c := s.c
-	c.seqId = 0
+	c.resetSeq()
+
+	// With CLIENT_QUERY_ATTRIBUTES, the parameters are named, and the query
+	// attributes follow them as extra parameters. Without it, we drop the
+	// attributes.
+	values, names := params, []string(nil)
+	if c.clientFlags&flagQueryAttributes != 0 {
+		values, names = appendQueryAttributes(params, attrs)
+	}
+	sendCount := names != nil && len(values) > 0
 
 	// First, we need to compute the size of the packet we will need
 	size := int64(1) + // command byte
@@ -180,23 +273,22 @@ func (s *stmt) sendQuery(params []drv.Value) error {
 		1 + // flags
 		4 // iteration count
 
-	if numInputs := int64(len(s.inputFields)); numInputs > 0 {
-		size += (numInputs + 7) / 8 // NULL bitmap
-		size += 1                   // new-params-bound
-		size += numInputs * 2       // types
-
-		for i := range params {
-			if params[i] == nil {
-				continue
-			}
-
-			paramSize, _, err := s.WriteObj(globalCountingWriter, params[i])
-			if err != nil {
-				return err
-			}
+	if sendCount {
+		n, _ := c.WriteLengthEncodedInt(globalCountingWriter, uint64(len(values)))
+		size += int64(n)
+	}
 
-			size += int64(paramSize)
+	if len(values) > 0 {
+		paramsSize, err := c.binaryParamsSize(values, names)
+		if err != nil {
+			return err
 		}
+		size += paramsSize
+	}
+
+	err = c.checkPacketSize(size)
+	if err != nil {
+		return err
 	}
 
 	// Now that we've completed computing the size of the packet, we begin
@@ -211,32 +303,85 @@ func (s *stmt) sendQuery(params []drv.Value) error {
 	c.scratch[8] = 0x00
 	c.scratch[9] = 0x00
 
-	_, err := c.Write(c.scratch[:10])
+	if sendCount {
+		c.scratch[5] |= cursorParameterCountAvailable
+	}
+
+	_, err = c.Write(c.scratch[:10])
 	if err != nil {
 		return err
 	}
 
-	if len(params) <= 0 {
-		err = c.EndPacket(FLUSH)
+	if sendCount {
+		_, err = c.WriteLengthEncodedInt(c, uint64(len(values)))
 		if err != nil {
 			return err
 		}
-		return nil
 	}
 
-	for i := uint(0); i < (uint(len(params))+7)/8; i++ {
+	if len(values) > 0 {
+		err = c.writeBinaryParams(values, names)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.EndPacket(FLUSH)
+	if err != nil {
+		return err
+	}
+
+	c.querySent()
+	return nil
+}
+
+// binaryParamsSize returns the size of the parameter block that
+// writeBinaryParams writes for values and names.
+func (c *conn) binaryParamsSize(values []drv.Value, names []string) (int64, error) {
+	numValues := int64(len(values))
+	size := (numValues+7)/8 + // NULL bitmap
+		1 + // new-params-bound
+		numValues*2 // types
+
+	for _, name := range names {
+		n, _ := c.WriteLengthEncodedInt(globalCountingWriter, uint64(len(name)))
+		size += int64(n + len(name))
+	}
+
+	for i := range values {
+		if values[i] == nil {
+			continue
+		}
+
+		paramSize, _, err := c.WriteObj(globalCountingWriter, values[i])
+		if err != nil {
+			return 0, err
+		}
+
+		size += int64(paramSize)
+	}
+
+	return size, nil
+}
+
+// writeBinaryParams writes the NULL bitmap, types and values of a list of
+// parameters in the binary protocol, as sent with COM_STMT_EXECUTE and, for
+// query attributes, COM_QUERY. If names is non-nil, each type is followed by
+// the parameter's name.
+func (c *conn) writeBinaryParams(values []drv.Value, names []string) error {
+	for i := uint(0); i < (uint(len(values))+7)/8; i++ {
 		c.scratch[0] = 0
 		for j := uint(0); j < 8; j++ {
 			idx := i*8 + j
-			if idx >= uint(len(params)) {
+			if idx >= uint(len(values)) {
 				break
 			}
 
-			if params[idx] == nil {
+			if values[idx] == nil {
 				c.scratch[0] |= 1 << (idx % 8)
 			}
 		}
-		_, err = c.Write(c.scratch[:1])
+		_, err := c.Write(c.scratch[:1])
 		if err != nil {
 			return err
 		}
@@ -244,17 +389,17 @@ func (s *stmt) sendQuery(params []drv.Value) error {
 
 	// new-params-bound == 1
 	c.scratch[0] = 1
-	_, err = c.Write(c.scratch[:1])
+	_, err := c.Write(c.scratch[:1])
 	if err != nil {
 		return err
 	}
 
-	// Types
-	for i := range params {
+	// Types, and names
+	for i := range values {
 		var ftype fieldType
 
-		if params[i] != nil {
-			_, ftype, err = s.WriteObj(ioutil.Discard, params[i])
+		if values[i] != nil {
+			_, ftype, err = c.WriteObj(ioutil.Discard, values[i])
 			if err != nil {
 				return err
 			}
@@ -268,25 +413,32 @@ func (s *stmt) sendQuery(params []drv.Value) error {
 		if err != nil {
 			return err
 		}
+
+		if names != nil {
+			_, err = c.WriteLengthEncodedInt(c, uint64(len(names[i])))
+			if err != nil {
+				return err
+			}
+
+			_, err = io.WriteString(c, names[i])
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	// Values
-	for i := range params {
-		if params[i] == nil {
+	for i := range values {
+		if values[i] == nil {
 			continue
 		}
 
-		_, _, err = s.WriteObj(c, params[i])
+		_, _, err = c.WriteObj(c, values[i])
 		if err != nil {
 			return err
 		}
 	}
 
-	err = c.EndPacket(FLUSH)
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -298,8 +450,7 @@ func (c countingWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func (s *stmt) WriteObj(w io.Writer, arg drv.Value) (int, fieldType, error) {
-	c := s.c
+func (c *conn) WriteObj(w io.Writer, arg drv.Value) (int, fieldType, error) {
 	switch v := arg.(type) {
 	case int64:
 		binary.LittleEndian.PutUint64(c.scratch[0:8], uint64(v))
@@ -341,9 +492,10 @@ func (s *stmt) WriteObj(w io.Writer, arg drv.Value) (int, fieldType, error) {
 		return n + n2, fieldTypeString, nil
 	case time.Time:
 		size := 0
+		v = v.In(c.cfg.Loc)
 
 		binary.LittleEndian.PutUint16(c.scratch[1:3], uint16(v.Year()))
-		c.scratch[3] = byte(v.Month() - 1)
+		c.scratch[3] = byte(v.Month())
 		c.scratch[4] = byte(v.Day())
 		c.scratch[5] = byte(v.Hour())
 		c.scratch[6] = byte(v.Minute())
@@ -370,4 +522,30 @@ func (s *stmt) WriteObj(w io.Writer, arg drv.Value) (int, fieldType, error) {
 	return 0, 0, fmt.Errorf("Can't convert type: %T", arg)
 }
 
-var _ drv.Stmt = (*stmt)(nil)
+// stmtArgs returns the values of args, which must not be named, and the query
+// attributes in ctx.
+func stmtArgs(ctx context.Context, args []drv.NamedValue) ([]drv.Value, []queryAttribute, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	params := make([]drv.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, nil, fmt.Errorf("named parameters are not supported: %q", arg.Name)
+		}
+		params[i] = arg.Value
+	}
+
+	attrs, err := queryAttributes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, attrs, nil
+}
+
+var (
+	_ drv.Stmt             = (*stmt)(nil)
+	_ drv.StmtExecContext  = (*stmt)(nil)
+	_ drv.StmtQueryContext = (*stmt)(nil)
+)
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"strconv"
 	"time"
+
+	"github.com/balasanjay/gms/internal/wire"
 )
 
 // This file implements utilities for reading and writing stream values
@@ -28,12 +31,18 @@ func (c *conn) ReadLengthEncodedInt(r io.Reader) (uint64, error) {
 		return 0, err
 	}
 
-	if c.scratch[0] < 0xfb {
-		return uint64(c.scratch[0]), nil
+	return c.readLengthEncodedIntRest(r, c.scratch[0])
+}
+
+// readLengthEncodedIntRest is like ReadLengthEncodedInt, except that the first
+// byte of the integer has already been read from r.
+func (c *conn) readLengthEncodedIntRest(r io.Reader, first byte) (uint64, error) {
+	if first < 0xfb {
+		return uint64(first), nil
 	}
 
 	intSize := uint64(0)
-	switch c.scratch[0] {
+	switch first {
 	case 0xfc:
 		intSize = 2
 	case 0xfd:
@@ -44,7 +53,7 @@ func (c *conn) ReadLengthEncodedInt(r io.Reader) (uint64, error) {
 		return 0, errors.New("unknown length encoded integer")
 	}
 
-	err = readExactly(r, c.scratch[:intSize])
+	err := readExactly(r, c.scratch[:intSize])
 	if err != nil {
 		return 0, err
 	}
@@ -57,6 +66,44 @@ func (c *conn) ReadLengthEncodedInt(r io.Reader) (uint64, error) {
 	return ret, nil
 }
 
+// maxValueLength is the longest value that a server can send, which is the
+// largest max_allowed_packet that MySQL allows. Longer lengths are refused,
+// rather than trusted.
+const maxValueLength = 1 << 30
+
+// errValueTooLong is returned for a value whose length exceeds maxValueLength,
+// or the packet it is in.
+var errValueTooLong = errors.New("malformed packet: value is longer than the packet holding it")
+
+// readValueLength reads the length-encoded length of a value from the current
+// packet, assuming its first byte is first, and checks that it is no longer
+// than maxValueLength. If inPacket is true, then the value must also fit in the
+// rest of the current packet, which is the case for everything but the values
+// of a row, which may span several packets.
+func (c *conn) readValueLength(first byte, inPacket bool) (int64, error) {
+	length, err := c.readLengthEncodedIntRest(c, first)
+	if err != nil {
+		return 0, err
+	}
+	if length > maxValueLength || (inPacket && length > uint64(c.framer.Remaining())) {
+		return 0, errValueTooLong
+	}
+	return int64(length), nil
+}
+
+// readValueInto reads a value of length bytes from the current packet into
+// c.reuseBuf. The buffer is only grown up front by as much as the current
+// packet holds, so that a bogus length can't make us allocate memory for data
+// that never arrives.
+func (c *conn) readValueInto(length int64) error {
+	c.reuseBuf.Grow(int(min(length, c.framer.Remaining())))
+	err := c.CopyN(c.reuseBuf, c, length)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 func (c *conn) SkipLengthEncodedString() error {
 	strSize, err := c.ReadLengthEncodedInt(c)
 	if err != nil {
@@ -71,30 +118,12 @@ func (c *conn) SkipLengthEncodedString() error {
 }
 
 func (c *conn) WriteLengthEncodedInt(w io.Writer, n uint64) (int, error) {
-	size := 0
-	if n < 251 {
-		size = 1
-		c.scratch[0] = byte(n)
-	} else if n < (1 << 16) {
-		size = 3
-		c.scratch[0] = 0xfc
-		binary.LittleEndian.PutUint16(c.scratch[1:3], uint16(n))
-	} else if n < (1 << 24) {
-		size = 4
-		c.scratch[0] = 0xfd
-		c.scratch[1] = byte(n)
-		c.scratch[2] = byte(n >> 8)
-		c.scratch[3] = byte(n >> 16)
-	} else {
-		size = 9
-		c.scratch[0] = 0xfe
-		binary.LittleEndian.PutUint64(c.scratch[1:9], n)
-	}
-
-	nw, err := w.Write(c.scratch[0:size])
+	b := wire.AppendLengthEncodedInt(c.scratch[:0], n)
+
+	nw, err := w.Write(b)
 	if err != nil {
 		return 0, err
-	} else if nw != size {
+	} else if nw != len(b) {
 		return 0, io.ErrShortWrite
 	}
 
@@ -171,20 +200,19 @@ func (c *conn) ReadValue(o *outputFieldData, dst *drv.Value) error {
 		fieldTypeBit, fieldTypeEnum, fieldTypeSet, fieldTypeTinyBLOB,
 		fieldTypeMediumBLOB, fieldTypeLongBLOB, fieldTypeBLOB,
 		fieldTypeVarString, fieldTypeString:
-		length, err := c.ReadLengthEncodedInt(c)
-		// TODO(sanjay): the other client handles a NULL value here, look into
-		// when this would come up.
+		err := readExactly(c, c.scratch[:1])
+		if err != nil {
+			return err
+		}
+
+		// NULLs are marked in the row's bitmap, so a length is never 0xfb.
+		length, err := c.readValueLength(c.scratch[0], false)
 		if err != nil {
 			return err
 		}
 
-		// TODO(sanjay): if this uint64->int[64] conversion results in a negative
-		// number, we will have bad times.
-		c.reuseBuf.Grow(int(length))
-		err = c.CopyN(c.reuseBuf, c, int64(length))
-		if err == io.EOF {
-			return io.ErrUnexpectedEOF
-		} else if err != nil {
+		err = c.readValueInto(length)
+		if err != nil {
 			return err
 		}
 		o.bufEndIdx = c.reuseBuf.Len()
@@ -236,11 +264,66 @@ func (c *conn) ReadValue(o *outputFieldData, dst *drv.Value) error {
 			microsecond = int(binary.LittleEndian.Uint32(c.scratch[:4]))
 		}
 
-		*dst = time.Date(year, time.Month(month+1), day, hour, minute, second, microsecond*1e3, time.UTC)
+		*dst = time.Date(year, time.Month(month), day, hour, minute, second, microsecond*1e3, c.cfg.Loc)
 		o.bufEndIdx = -1
 		return nil
 	default:
 		return fmt.Errorf("Cannot read field type %x", o.ftype)
 	}
-	panic("unreachable")
+}
+
+// ReadTextValue is like ReadValue, but for a column of a row in the text
+// protocol, which is used for the results of COM_QUERY. In the text protocol,
+// every value is sent as a length encoded string, or 0xfb for NULL. The caller
+// must have already read the first byte of the value, and passes it as first.
+func (c *conn) ReadTextValue(o *outputFieldData, first byte, dst *drv.Value) error {
+	if first == 0xfb {
+		*dst = nil
+		o.bufEndIdx = -1
+		return nil
+	}
+
+	length, err := c.readValueLength(first, false)
+	if err != nil {
+		return err
+	}
+
+	bufStartIdx := c.reuseBuf.Len()
+	err = c.readValueInto(length)
+	if err != nil {
+		return err
+	}
+	o.bufEndIdx = c.reuseBuf.Len()
+
+	// Where the binary protocol would have given us a number or a time, we
+	// convert the text to match. If that fails, then we fall back to returning
+	// the raw bytes.
+	text := string(c.reuseBuf.Bytes()[bufStartIdx:])
+	switch o.ftype {
+	case fieldTypeTiny, fieldTypeShort, fieldTypeYear, fieldTypeInt24,
+		fieldTypeLong, fieldTypeLongLong:
+		if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+			*dst = v
+			o.bufEndIdx = -1
+		}
+	case fieldTypeFloat, fieldTypeDouble:
+		if v, err := strconv.ParseFloat(text, 64); err == nil {
+			*dst = v
+			o.bufEndIdx = -1
+		}
+	case fieldTypeDate, fieldTypeDateTime, fieldTypeTimestamp, fieldTypeNewDate:
+		layout := "2006-01-02 15:04:05.999999"
+		if len(text) == len("2006-01-02") {
+			layout = "2006-01-02"
+		}
+		if v, err := time.ParseInLocation(layout, text, c.cfg.Loc); err == nil {
+			*dst = v
+			o.bufEndIdx = -1
+		}
+	}
+
+	if o.bufEndIdx == -1 {
+		c.reuseBuf.Truncate(bufStartIdx)
+	}
+	return nil
 }
@@ -0,0 +1,325 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// maxTracePayload is the most bytes of a packet's payload that are passed to a
+// Tracer.
+const maxTracePayload = 4096
+
+// maxTraceSummary is the most bytes of a query that are included in the
+// summary of a packet.
+const maxTraceSummary = 256
+
+// TracePacket describes a packet sent to or received from the server.
+type TracePacket struct {
+	// Whether the packet was sent to the server, rather than received from
+	// it.
+	Sent bool
+
+	// The packet's sequence id, and the length of its payload.
+	Seq    uint8
+	Length int
+
+	// The name of the command the packet starts, such as COM_QUERY, if it is
+	// the first packet of a command sent to the server, and empty otherwise.
+	Command string
+
+	// A description of the packet, such as the text of a query or the code
+	// and message of an error.
+	Summary string
+
+	// Up to the first 4KB of the payload. Passwords, and the responses to
+	// authentication challenges, are replaced with zeros or asterisks. It is
+	// only valid until TracePacket returns.
+	Payload []byte
+}
+
+// A Tracer is told about every packet sent to and received from the server on
+// the connections of a Config. It is called from the goroutine using the
+// connection, which waits for it to return.
+type Tracer interface {
+	TracePacket(p TracePacket)
+}
+
+// SlogTracer is a Tracer that logs each packet to a slog.Logger.
+type SlogTracer struct {
+	// The logger to log to, and the level to log at. A nil Logger means
+	// slog.Default().
+	Logger *slog.Logger
+	Level  slog.Level
+
+	// If true, each message includes a hex dump of the packet's payload.
+	HexDump bool
+}
+
+// TracePacket implements Tracer.
+func (t *SlogTracer) TracePacket(p TracePacket) {
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	ctx := context.Background()
+	if !logger.Enabled(ctx, t.Level) {
+		return
+	}
+
+	msg := "gms: received packet"
+	if p.Sent {
+		msg = "gms: sent packet"
+	}
+
+	attrs := []slog.Attr{
+		slog.Int("seq", int(p.Seq)),
+		slog.Int("length", p.Length),
+	}
+	if p.Command != "" {
+		attrs = append(attrs, slog.String("command", p.Command))
+	}
+	attrs = append(attrs, slog.String("summary", p.Summary))
+	if t.HexDump {
+		attrs = append(attrs, slog.String("payload", hex.Dump(p.Payload)))
+	}
+	logger.LogAttrs(ctx, t.Level, msg, attrs...)
+}
+
+// packetTrace collects the packets that a framer sends and receives, and
+// passes them on to a Tracer.
+type packetTrace struct {
+	tracer Tracer
+
+	// Whether the connection is in the handshake, where packets are
+	// interpreted differently, and may hold passwords.
+	handshake bool
+
+	// Whether the handshake response has been sent.
+	responded bool
+
+	sent     tracedPacket
+	received tracedPacket
+}
+
+// tracedPacket is a packet that is being sent or received.
+type tracedPacket struct {
+	seq     uint8
+	length  uint32
+	payload []byte
+}
+
+func newPacketTrace(tracer Tracer) *packetTrace {
+	return &packetTrace{tracer: tracer, handshake: true}
+}
+
+// endHandshake marks the end of the handshake. It may be called on a nil
+// packetTrace.
+func (t *packetTrace) endHandshake() {
+	if t != nil {
+		t.handshake = false
+	}
+}
+
+// begin starts a packet, whose payload is passed to add, and which is
+// finished by endSent or endReceived.
+func (p *tracedPacket) begin(seq uint8, length uint32) {
+	p.seq = seq
+	p.length = length
+	p.payload = p.payload[:0]
+}
+
+// add adds b to the payload of the packet.
+func (p *tracedPacket) add(b []byte) {
+	if room := maxTracePayload - len(p.payload); room > 0 {
+		p.payload = append(p.payload, b[:min(len(b), room)]...)
+	}
+}
+
+// endSent passes the packet being sent to the Tracer.
+func (t *packetTrace) endSent() {
+	p := &t.sent
+	tp := TracePacket{Sent: true, Seq: p.seq, Length: int(p.length)}
+
+	switch {
+	case t.handshake && !t.responded && p.length == 32:
+		tp.Summary = "TLS request"
+		tp.Payload = p.payload
+	case t.handshake && !t.responded:
+		t.responded = true
+		tp.Payload = redactHandshakeResponse(p.payload)
+		user, _, _ := bytes.Cut(p.payload[min(32, len(p.payload)):], []byte{0})
+		tp.Summary = fmt.Sprintf("handshake response, user %q", user)
+	case t.handshake:
+		// Anything else the client sends in the handshake answers an
+		// authentication challenge.
+		tp.Payload = make([]byte, len(p.payload))
+		tp.Summary = "authentication response"
+	case p.seq == 0 && len(p.payload) > 0:
+		tp.Command = commandName(p.payload[0])
+		tp.Payload, tp.Summary = summarizeCommand(p.payload)
+	default:
+		tp.Payload = p.payload
+		tp.Summary = "data"
+	}
+
+	t.tracer.TracePacket(tp)
+}
+
+// endReceived passes the packet being received to the Tracer.
+func (t *packetTrace) endReceived() {
+	p := &t.received
+	tp := TracePacket{Seq: p.seq, Length: int(p.length), Payload: p.payload}
+
+	b := p.payload
+	switch {
+	case len(b) == 0:
+		tp.Summary = "empty"
+	case t.handshake && p.seq == 0 && b[0] == 10:
+		version, _, _ := bytes.Cut(b[1:], []byte{0})
+		tp.Summary = fmt.Sprintf("greeting, server version %q", version)
+	case b[0] == 0xff:
+		tp.Summary = summarizeErr(b)
+	case b[0] == 0xfe && p.length < 9:
+		tp.Summary = "EOF"
+	case t.handshake && b[0] == 0xfe:
+		plugin, _, _ := bytes.Cut(b[1:], []byte{0})
+		tp.Summary = fmt.Sprintf("authentication switch to %q", plugin)
+	case b[0] == 0x00 && p.seq == 1 || (t.handshake && b[0] == 0x00):
+		tp.Summary = summarizeOK(b)
+	default:
+		tp.Summary = "data"
+	}
+
+	t.tracer.TracePacket(tp)
+}
+
+// commandNames are the names of the commands the driver sends.
+var commandNames = map[byte]string{
+	comQuit:             "COM_QUIT",
+	comInitDB:           "COM_INIT_DB",
+	comQuery:            "COM_QUERY",
+	comFieldList:        "COM_FIELD_LIST",
+	comStatistics:       "COM_STATISTICS",
+	comPing:             "COM_PING",
+	comChangeUser:       "COM_CHANGE_USER",
+	comStmtPrepare:      "COM_STMT_PREPARE",
+	comStmtExecute:      "COM_STMT_EXECUTE",
+	comStmtSendLongData: "COM_STMT_SEND_LONG_DATA",
+	comStmtClose:        "COM_STMT_CLOSE",
+	comStmtReset:        "COM_STMT_RESET",
+	comSetOption:        "COM_SET_OPTION",
+	comStmtFetch:        "COM_STMT_FETCH",
+	comResetConnection:  "COM_RESET_CONNECTION",
+	comStmtBulkExecute:  "COM_STMT_BULK_EXECUTE",
+}
+
+// commandName returns the name of command.
+func commandName(command byte) string {
+	if name, ok := commandNames[command]; ok {
+		return name
+	}
+	return fmt.Sprintf("COM_0x%02x", command)
+}
+
+// passwordPattern matches the passwords in statements that create users or
+// set passwords, in its second group.
+var passwordPattern = regexp.MustCompile(`(?i)(IDENTIFIED(?:\s+WITH\s+\S+)?\s+(?:BY|AS)\s+(?:PASSWORD\s+)?|PASSWORD\s*(?:FOR\s+\S+\s*)?=\s*(?:PASSWORD\s*\(\s*)?)('(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*")`)
+
+// redactPasswords returns query with the passwords in it replaced by '***'.
+func redactPasswords(query []byte) []byte {
+	return passwordPattern.ReplaceAll(query, []byte("$1'***'"))
+}
+
+// summarizeCommand returns the payload of a command, with any passwords
+// redacted, and a summary of it.
+func summarizeCommand(payload []byte) ([]byte, string) {
+	arg := payload[1:]
+	switch payload[0] {
+	case comQuery, comStmtPrepare:
+		// A query with attributes has them before its text, which we
+		// don't bother finding.
+		redacted := append(payload[:1:1], redactPasswords(arg)...)
+		return redacted, truncate(string(redacted[1:]))
+	case comInitDB:
+		return payload, fmt.Sprintf("database %q", arg)
+	case comStmtExecute, comStmtClose, comStmtReset, comStmtSendLongData, comStmtFetch, comStmtBulkExecute:
+		if len(arg) >= 4 {
+			return payload, fmt.Sprintf("statement %d", binary.LittleEndian.Uint32(arg))
+		}
+	case comChangeUser:
+		user, _, _ := bytes.Cut(arg, []byte{0})
+		return append(payload[:1:1], make([]byte, len(arg))...), fmt.Sprintf("user %q", user)
+	}
+	return payload, ""
+}
+
+// redactHandshakeResponse returns payload, a handshake response, with the
+// response to the authentication challenge zeroed.
+func redactHandshakeResponse(payload []byte) []byte {
+	redacted := append([]byte(nil), payload...)
+	if len(redacted) < 32 {
+		return redacted
+	}
+	flags := connectionFlag(binary.LittleEndian.Uint32(redacted))
+
+	i := bytes.IndexByte(redacted[32:], 0)
+	if i < 0 {
+		return redacted
+	}
+	rest := redacted[32+i+1:]
+
+	var auth []byte
+	if flags&flagPluginAuthLenEncData != 0 {
+		size, after, err := wire.ReadLengthEncodedInt(rest)
+		if err != nil {
+			return redacted
+		}
+		auth = after[:min(uint64(len(after)), size)]
+	} else if len(rest) > 0 {
+		auth = rest[1:min(len(rest), 1+int(rest[0]))]
+	}
+	clear(auth)
+	return redacted
+}
+
+// summarizeOK returns a summary of an OK packet.
+func summarizeOK(b []byte) string {
+	affectedRows, rest, err := wire.ReadLengthEncodedInt(b[1:])
+	if err != nil {
+		return "OK"
+	}
+	lastInsertID, _, err := wire.ReadLengthEncodedInt(rest)
+	if err != nil {
+		return "OK"
+	}
+	return fmt.Sprintf("OK, %d rows affected, last insert id %d", affectedRows, lastInsertID)
+}
+
+// summarizeErr returns a summary of an ERR packet.
+func summarizeErr(b []byte) string {
+	if len(b) < 3 {
+		return "ERR"
+	}
+	code := binary.LittleEndian.Uint16(b[1:3])
+	msg := b[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		msg = msg[6:]
+	}
+	return fmt.Sprintf("ERR %d: %s", code, truncate(string(msg)))
+}
+
+// truncate returns s, cut short if it is longer than maxTraceSummary.
+func truncate(s string) string {
+	if len(s) <= maxTraceSummary {
+		return s
+	}
+	return s[:maxTraceSummary] + "..."
+}
@@ -0,0 +1,140 @@
+package gms
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// traceRecorder is a Tracer that keeps the packets it is told about.
+type traceRecorder struct {
+	mu      sync.Mutex
+	packets []TracePacket
+}
+
+func (r *traceRecorder) TracePacket(p TracePacket) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p.Payload = append([]byte(nil), p.Payload...)
+	r.packets = append(r.packets, p)
+}
+
+// find returns the first packet that was sent, or received, and has a summary
+// containing summary.
+func (r *traceRecorder) find(sent bool, summary string) (TracePacket, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.packets {
+		if p.Sent == sent && strings.Contains(p.Summary, summary) {
+			return p, true
+		}
+	}
+	return TracePacket{}, false
+}
+
+func TestTracer(t *testing.T) {
+	s := newTestServer(t)
+	s.AddResult("CREATE USER 'bob' IDENTIFIED BY 'hunter2'", gmstest.Result{})
+	s.AddResult("INSERT INTO t VALUES (?)", gmstest.Result{AffectedRows: 1})
+
+	tracer := &traceRecorder{}
+	db := openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.User = "root"
+		cfg.Passwd = "secret"
+		cfg.Tracer = tracer
+	})
+
+	_, err := db.Exec("CREATE USER 'bob' IDENTIFIED BY 'hunter2'")
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO t VALUES (?)", 1)
+	if err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+	db.Close()
+
+	greeting, ok := tracer.find(false, "greeting")
+	if !ok || !strings.Contains(greeting.Summary, "8.0.36-gmstest") || greeting.Seq != 0 {
+		t.Fatalf("got greeting %+v, want one for server version 8.0.36-gmstest", greeting)
+	}
+
+	p, ok := tracer.find(true, "handshake response")
+	if !ok || !strings.Contains(p.Summary, `"root"`) {
+		t.Errorf("got handshake response %+v, want one for user root", p)
+	}
+	scramble := wire.ScramblePassword(greetingChallenge(greeting.Payload), "secret")
+	if bytes.Contains(p.Payload, scramble) {
+		t.Errorf("handshake response payload %x holds the scrambled password", p.Payload)
+	}
+
+	p, ok = tracer.find(true, "CREATE USER")
+	if !ok || p.Command != "COM_QUERY" || p.Seq != 0 {
+		t.Errorf("got query %+v, want a COM_QUERY", p)
+	}
+	if strings.Contains(p.Summary, "hunter2") || bytes.Contains(p.Payload, []byte("hunter2")) {
+		t.Errorf("got query %+v, which holds the password", p)
+	}
+	if want := "CREATE USER 'bob' IDENTIFIED BY '***'"; p.Summary != want {
+		t.Errorf("got query summary %q, want %q", p.Summary, want)
+	}
+
+	if p, ok := tracer.find(true, "INSERT INTO t"); !ok || p.Command != "COM_STMT_PREPARE" {
+		t.Errorf("got prepared statement %+v, want a COM_STMT_PREPARE", p)
+	}
+	if p, ok := tracer.find(true, "statement 1"); !ok || p.Command != "COM_STMT_EXECUTE" {
+		t.Errorf("got execution %+v, want a COM_STMT_EXECUTE", p)
+	}
+	if _, ok := tracer.find(false, "OK, 1 rows affected"); !ok {
+		t.Errorf("got no OK packet for the execution")
+	}
+}
+
+// greetingChallenge returns the challenge in a greeting. After the protocol
+// version and the server version, come the connection id, the first 8 bytes
+// of the challenge, and 19 more bytes before the other 12.
+func greetingChallenge(greeting []byte) []byte {
+	rest := greeting[bytes.IndexByte(greeting, 0)+1:]
+	return append(append([]byte(nil), rest[4:12]...), rest[31:43]...)
+}
+
+func TestRedactPasswords(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"SELECT 'secret'", "SELECT 'secret'"},
+		{"CREATE USER u IDENTIFIED BY 'secret'", "CREATE USER u IDENTIFIED BY '***'"},
+		{"ALTER USER u IDENTIFIED WITH mysql_native_password BY \"it\\\"s\"", "ALTER USER u IDENTIFIED WITH mysql_native_password BY '***'"},
+		{"SET PASSWORD = 'secret'", "SET PASSWORD = '***'"},
+		{"set password for u@h = password('secret')", "set password for u@h = password('***')"},
+	}
+	for _, test := range tests {
+		if got := string(redactPasswords([]byte(test.query))); got != test.want {
+			t.Errorf("redactPasswords(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSlogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := &SlogTracer{
+		Logger:  slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		Level:   slog.LevelDebug,
+		HexDump: true,
+	}
+	tracer.TracePacket(TracePacket{Sent: true, Seq: 0, Length: 9, Command: "COM_QUERY", Summary: "SELECT 1", Payload: []byte("\x03SELECT 1")})
+
+	got := buf.String()
+	for _, want := range []string{`msg="gms: sent packet"`, "seq=0", "length=9", "command=COM_QUERY", `summary="SELECT 1"`, "53 45 4c 45 43 54"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log output %q doesn't contain %q", got, want)
+		}
+	}
+}
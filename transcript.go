@@ -0,0 +1,336 @@
+package gms
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// A transcript is a recording of the packets sent over a connection, which
+// can be replayed later to test the driver without a server. It is a text
+// file with a line for each packet:
+//
+//	C 1 8da2bf01000000002d00...
+//	S 2 0700000002000000
+//
+// giving the direction of the packet, C from the client or S from the server,
+// its sequence id, and its payload in hex. Blank lines and lines starting with
+// # are ignored, so that transcripts may be annotated.
+
+// transcriptPacket is a packet in a transcript.
+type transcriptPacket struct {
+	fromClient bool
+	seq        uint8
+	payload    []byte
+}
+
+// splitPackets removes the packets that are complete from the start of buf,
+// which holds data sent in one direction over a connection, and returns them.
+func splitPackets(buf *[]byte, fromClient bool) []transcriptPacket {
+	var packets []transcriptPacket
+	for len(*buf) >= 4 {
+		b := *buf
+		size := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		if len(b) < 4+size {
+			break
+		}
+
+		packets = append(packets, transcriptPacket{
+			fromClient: fromClient,
+			seq:        b[3],
+			payload:    append([]byte(nil), b[4:4+size]...),
+		})
+		*buf = b[4+size:]
+	}
+	return packets
+}
+
+// NewRecorder returns a net.Conn that reads from and writes to nc, and
+// writes a transcript of the packets sent each way to w. It is meant to be
+// returned from Config.Dial, to record a session for NewReplayer to replay:
+//
+//	cfg.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+//		var d net.Dialer
+//		nc, err := d.DialContext(ctx, network, addr)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return gms.NewRecorder(nc, f), nil
+//	}
+//
+// Connections that use TLS or compression can't be recorded, since their
+// packets are encrypted or compressed on the wire.
+func NewRecorder(nc net.Conn, w io.Writer) net.Conn {
+	return &recorder{Conn: nc, w: w}
+}
+
+type recorder struct {
+	net.Conn
+
+	mu       sync.Mutex
+	w        io.Writer
+	sent     []byte
+	received []byte
+}
+
+func (r *recorder) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	if n > 0 {
+		if recErr := r.record(&r.received, b[:n], false); recErr != nil {
+			return n, recErr
+		}
+	}
+	return n, err
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	n, err := r.Conn.Write(b)
+	if n > 0 {
+		if recErr := r.record(&r.sent, b[:n], true); recErr != nil {
+			return n, recErr
+		}
+	}
+	return n, err
+}
+
+// record adds b to the data sent in one direction, and writes the packets
+// that it completes to the transcript.
+func (r *recorder) record(buf *[]byte, b []byte, fromClient bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	*buf = append(*buf, b...)
+	for _, p := range splitPackets(buf, fromClient) {
+		direction := 'S'
+		if p.fromClient {
+			direction = 'C'
+		}
+		_, err := fmt.Fprintf(r.w, "%c %d %x\n", direction, p.seq, p.payload)
+		if err != nil {
+			return fmt.Errorf("recording transcript: %w", err)
+		}
+	}
+	return nil
+}
+
+// NewReplayer returns a net.Conn that plays the server's side of the
+// transcript read from r. Reads return the packets the server sent, and
+// writes are checked against the packets the client sent; any difference
+// fails the read or write, and every one after it. It is meant to be returned
+// from Config.Dial, with a new replayer for each connection.
+//
+// The connection attributes in the handshake response are not compared, as
+// some of them, like _pid, change from one run to the next.
+func NewReplayer(r io.Reader) (net.Conn, error) {
+	var packets []transcriptPacket
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 2*maxPacketSize+64)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 2 {
+			// An empty payload leaves no third field.
+			fields = append(fields, "")
+		}
+		if len(fields) != 3 || (fields[0] != "C" && fields[0] != "S") {
+			return nil, fmt.Errorf("transcript line %d: malformed packet %q", line, text)
+		}
+
+		seq, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("transcript line %d: bad sequence id: %v", line, err)
+		}
+		payload, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("transcript line %d: bad payload: %v", line, err)
+		}
+		if len(payload) > maxPacketSize {
+			return nil, fmt.Errorf("transcript line %d: payload of %d bytes is too large for a packet", line, len(payload))
+		}
+
+		packets = append(packets, transcriptPacket{
+			fromClient: fields[0] == "C",
+			seq:        uint8(seq),
+			payload:    payload,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &replayer{packets: packets}, nil
+}
+
+type replayer struct {
+	mu      sync.Mutex
+	packets []transcriptPacket
+
+	// The index of the next packet in the transcript to be sent or
+	// received.
+	next int
+
+	// The bytes of server packets that are yet to be read, and of client
+	// packets that are yet to be completed.
+	pending []byte
+	written []byte
+
+	// Whether the client's handshake response has been checked yet.
+	sawHandshake bool
+
+	err error
+}
+
+// errReplayClosed is returned by reads and writes on a closed replayer.
+var errReplayClosed = errors.New("replay: connection closed")
+
+func (r *replayer) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	for len(r.pending) == 0 {
+		if r.next == len(r.packets) {
+			return 0, io.EOF
+		}
+
+		p := r.packets[r.next]
+		if p.fromClient {
+			r.err = fmt.Errorf("replay: client read a packet, but transcript packet %d is one it sends: %x", r.next+1, p.payload)
+			return 0, r.err
+		}
+
+		var header [4]byte
+		binary.LittleEndian.PutUint32(header[:], uint32(len(p.payload)))
+		header[3] = p.seq
+		r.pending = append(append(r.pending, header[:]...), p.payload...)
+		r.next++
+	}
+
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *replayer) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	r.written = append(r.written, b...)
+	for _, got := range splitPackets(&r.written, true) {
+		if r.next == len(r.packets) {
+			r.err = fmt.Errorf("replay: client sent a packet past the end of the transcript: %x", got.payload)
+			return 0, r.err
+		}
+
+		want := r.packets[r.next]
+		if !want.fromClient {
+			r.err = fmt.Errorf("replay: client sent a packet, but transcript packet %d is one it receives: %x", r.next+1, got.payload)
+			return 0, r.err
+		}
+
+		gotPayload, wantPayload := got.payload, want.payload
+		if !r.sawHandshake {
+			r.sawHandshake = true
+			gotPayload = withoutConnectAttrs(gotPayload)
+			wantPayload = withoutConnectAttrs(wantPayload)
+		}
+		if got.seq != want.seq || !bytes.Equal(gotPayload, wantPayload) {
+			r.err = fmt.Errorf("replay: transcript packet %d differs: client sent sequence id %d, payload %x; want sequence id %d, payload %x",
+				r.next+1, got.seq, got.payload, want.seq, want.payload)
+			return 0, r.err
+		}
+		r.next++
+	}
+	return len(b), nil
+}
+
+// withoutConnectAttrs returns the handshake response in payload with its
+// connection attributes removed, or payload as it is if it doesn't have any.
+func withoutConnectAttrs(payload []byte) []byte {
+	if len(payload) < 32 {
+		return payload
+	}
+	flags := connectionFlag(binary.LittleEndian.Uint32(payload))
+	if flags&flagConnectAttrs == 0 {
+		return payload
+	}
+
+	// The attributes follow the user name, the auth response, the database
+	// and the auth plugin name.
+	skipNullTerminated := func(b []byte) []byte {
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return nil
+		}
+		return b[i+1:]
+	}
+
+	rest := skipNullTerminated(payload[32:])
+	if flags&flagPluginAuthLenEncData != 0 {
+		if len(rest) == 0 || rest[0] >= 0xfb {
+			return payload
+		}
+		rest = rest[min(len(rest), 1+int(rest[0])):]
+	} else if len(rest) > 0 {
+		rest = rest[min(len(rest), 1+int(rest[0])):]
+	}
+	if flags&flagConnectWithDB != 0 {
+		rest = skipNullTerminated(rest)
+	}
+	if flags&flagPluginAuth != 0 {
+		rest = skipNullTerminated(rest)
+	}
+
+	start := len(payload) - len(rest)
+	size, after, err := wire.ReadLengthEncodedInt(rest)
+	if err != nil || size > uint64(len(after)) {
+		return payload
+	}
+	end := len(payload) - len(after) + int(size)
+	return append(append([]byte(nil), payload[:start]...), payload[end:]...)
+}
+
+func (r *replayer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = errReplayClosed
+	}
+	return nil
+}
+
+// replayAddr is the address of both ends of a replayed connection.
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "transcript" }
+
+func (r *replayer) LocalAddr() net.Addr                { return replayAddr{} }
+func (r *replayer) RemoteAddr() net.Addr               { return replayAddr{} }
+func (r *replayer) SetDeadline(t time.Time) error      { return nil }
+func (r *replayer) SetReadDeadline(t time.Time) error  { return nil }
+func (r *replayer) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,109 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/balasanjay/gms/gmstest"
+	"github.com/balasanjay/gms/internal/wire"
+)
+
+// runTranscriptSession runs the statements of the session that is recorded
+// and replayed.
+func runTranscriptSession(db *sql.DB, query string) error {
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO t VALUES (?, ?)", 1, "one")
+	return err
+}
+
+func TestTranscript(t *testing.T) {
+	s := newTestServer(t)
+	s.AddResult("DELETE FROM t", gmstest.Result{AffectedRows: 3})
+	s.AddResult("INSERT INTO t VALUES (?, ?)", gmstest.Result{AffectedRows: 1})
+
+	var transcript bytes.Buffer
+	db := openTestDB(t, s.Addr, func(cfg *Config) {
+		cfg.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			nc, err := d.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return NewRecorder(nc, &transcript), nil
+		}
+	})
+	db.SetMaxOpenConns(1)
+	err := runTranscriptSession(db, "DELETE FROM t")
+	if err != nil {
+		t.Fatalf("recorded session error: %v", err)
+	}
+	db.Close()
+
+	recorded := transcript.String()
+	for _, want := range []string{"S 0 0a", "C 1 ", "S 2 00"} {
+		if !strings.Contains(recorded, "\n"+want) && !strings.HasPrefix(recorded, want) {
+			t.Errorf("transcript has no packet starting with %q:\n%s", want, recorded)
+		}
+	}
+
+	replay := func(t *testing.T, query string) error {
+		db := openTestDB(t, "", func(cfg *Config) {
+			cfg.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return NewReplayer(strings.NewReader("# annotated\n\n" + recorded))
+			}
+		})
+		db.SetMaxOpenConns(1)
+		return runTranscriptSession(db, query)
+	}
+
+	t.Run("same session", func(t *testing.T) {
+		err := replay(t, "DELETE FROM t")
+		if err != nil {
+			t.Errorf("replayed session error: %v", err)
+		}
+	})
+
+	t.Run("diverging session", func(t *testing.T) {
+		err := replay(t, "DELETE FROM u")
+		if err == nil || !strings.Contains(err.Error(), "differs") {
+			t.Errorf("got error %v, want a divergence", err)
+		}
+	})
+}
+
+func TestWithoutConnectAttrs(t *testing.T) {
+	response := func(attrs map[string]string) []byte {
+		cfg := NewConfig()
+		cfg.ConnectAttrs = attrs
+		encoded, err := cfg.connectAttrs()
+		if err != nil {
+			t.Fatalf("connectAttrs error: %v", err)
+		}
+
+		b := binary.LittleEndian.AppendUint32(nil, uint32(flagProtocol41|flagSecureConn|flagConnectWithDB|flagConnectAttrs))
+		b = append(b, make([]byte, 28)...)
+		b = append(b, "root\x00"...)
+		b = append(b, 2, 0xaa, 0xbb)
+		b = append(b, "test\x00"...)
+		b = wire.AppendLengthEncodedInt(b, uint64(len(encoded)))
+		b = append(b, encoded...)
+		return append(b, 3) // zstd level
+	}
+
+	a := withoutConnectAttrs(response(map[string]string{"app": "a"}))
+	b := withoutConnectAttrs(response(map[string]string{"app": "b", "extra": strings.Repeat("x", 300)}))
+	if !bytes.Equal(a, b) {
+		t.Errorf("handshake responses differ after removing connection attributes: %x and %x", a, b)
+	}
+	if want := 32 + len("root\x00") + 3 + len("test\x00") + 1; len(a) != want {
+		t.Errorf("got %d bytes after removing connection attributes, want %d", len(a), want)
+	}
+}
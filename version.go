@@ -0,0 +1,221 @@
+package gms
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServerFlavor is the kind of server a connection is connected to.
+type ServerFlavor string
+
+const (
+	FlavorMySQL   ServerFlavor = "mysql"
+	FlavorMariaDB ServerFlavor = "mariadb"
+	FlavorTiDB    ServerFlavor = "tidb"
+	FlavorVitess  ServerFlavor = "vitess"
+)
+
+// ServerVersion is the numeric part of a server's version.
+type ServerVersion struct {
+	Major, Minor, Patch int
+}
+
+func (v ServerVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1, depending on whether v is older than, the same
+// as, or newer than w.
+func (v ServerVersion) Compare(w ServerVersion) int {
+	switch {
+	case v.Major != w.Major:
+		return compareInts(v.Major, w.Major)
+	case v.Minor != w.Minor:
+		return compareInts(v.Minor, w.Minor)
+	}
+	return compareInts(v.Patch, w.Patch)
+}
+
+// AtLeast returns true if v is major.minor.patch or newer.
+func (v ServerVersion) AtLeast(major, minor, patch int) bool {
+	return v.Compare(ServerVersion{major, minor, patch}) >= 0
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// ParseServerVersion determines the flavor and version of a server from the
+// version string in its greeting. The version is the product's own version
+// where the string has one, as with MariaDB ("5.5.5-10.11.6-MariaDB"), TiDB
+// ("8.0.11-TiDB-v7.5.0") and Vitess ("8.0.30-vitess-19.0.0"), and otherwise the
+// MySQL version that the server claims.
+func ParseServerVersion(version string) (ServerFlavor, ServerVersion) {
+	mysqlVersion, rest := parseVersionNumber(version)
+	lower := strings.ToLower(rest)
+
+	switch {
+	case strings.Contains(lower, "mariadb"):
+		// MariaDB 10 and later prefix their version with 5.5.5-, so that
+		// old clients don't mistake it for MySQL 10.
+		if strings.HasPrefix(version, "5.5.5-") {
+			v, _ := parseVersionNumber(version[len("5.5.5-"):])
+			return FlavorMariaDB, v
+		}
+		return FlavorMariaDB, mysqlVersion
+	case strings.Contains(lower, "-tidb-"):
+		return FlavorTiDB, productVersion(rest, "-tidb-v", lower, mysqlVersion)
+	case strings.Contains(lower, "vitess"):
+		return FlavorVitess, productVersion(rest, "-vitess-", lower, mysqlVersion)
+	}
+	return FlavorMySQL, mysqlVersion
+}
+
+// productVersion returns the version after marker in rest, or fallback if
+// there isn't one. lower is rest in lower case.
+func productVersion(rest, marker, lower string, fallback ServerVersion) ServerVersion {
+	i := strings.Index(lower, marker)
+	if i < 0 {
+		return fallback
+	}
+
+	v, after := parseVersionNumber(rest[i+len(marker):])
+	if len(after) == len(rest[i+len(marker):]) {
+		return fallback
+	}
+	return v
+}
+
+// parseVersionNumber parses the major.minor.patch at the start of s, any of
+// which may be missing, and returns it along with the rest of s.
+func parseVersionNumber(s string) (ServerVersion, string) {
+	var parts [3]int
+	for i := range parts {
+		end := 0
+		for end < len(s) && '0' <= s[end] && s[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+
+		parts[i], _ = strconv.Atoi(s[:end])
+		s = s[end:]
+
+		if i == len(parts)-1 || len(s) < 2 || s[0] != '.' || s[1] < '0' || s[1] > '9' {
+			break
+		}
+		s = s[1:]
+	}
+	return ServerVersion{parts[0], parts[1], parts[2]}, s
+}
+
+// Feature is a server feature that may be checked for with
+// ServerInfo.Supports.
+type Feature int
+
+const (
+	// The JSON column type.
+	FeatureJSON Feature = iota
+
+	// Common table expressions, that is, WITH queries.
+	FeatureCTE
+
+	// Window functions, such as ROW_NUMBER() OVER (...).
+	FeatureWindowFunctions
+
+	// Query attributes sent along with COM_QUERY and COM_STMT_EXECUTE.
+	FeatureQueryAttributes
+
+	// The caching_sha2_password authentication plugin.
+	FeatureCachingSHA2
+
+	// OK packets in place of EOF packets at the end of result sets.
+	FeatureDeprecateEOF
+
+	// Session state change tracking in OK packets.
+	FeatureSessionTrack
+)
+
+var featureNames = [...]string{
+	FeatureJSON:            "JSON",
+	FeatureCTE:             "CTE",
+	FeatureWindowFunctions: "window functions",
+	FeatureQueryAttributes: "query attributes",
+	FeatureCachingSHA2:     "caching_sha2_password",
+	FeatureDeprecateEOF:    "deprecate EOF",
+	FeatureSessionTrack:    "session tracking",
+}
+
+func (f Feature) String() string {
+	if f < 0 || int(f) >= len(featureNames) {
+		return fmt.Sprintf("Feature(%d)", int(f))
+	}
+	return featureNames[f]
+}
+
+// featureSupport records the first version of a flavor to support a feature.
+// Features of the protocol also need the server to advertise flag.
+type featureSupport struct {
+	since ServerVersion
+	flag  connectionFlag
+}
+
+// features maps each flavor to the features it supports. A feature that is
+// missing for a flavor is not supported by any version of it. Vitess versions
+// that only give the MySQL version they claim to be are too old for features
+// that need a recent Vitess.
+var features = map[ServerFlavor]map[Feature]featureSupport{
+	FlavorMySQL: {
+		FeatureJSON:            {since: ServerVersion{5, 7, 8}},
+		FeatureCTE:             {since: ServerVersion{8, 0, 1}},
+		FeatureWindowFunctions: {since: ServerVersion{8, 0, 2}},
+		FeatureQueryAttributes: {since: ServerVersion{8, 0, 23}, flag: flagQueryAttributes},
+		FeatureCachingSHA2:     {since: ServerVersion{8, 0, 4}},
+		FeatureDeprecateEOF:    {since: ServerVersion{5, 7, 5}, flag: flagDeprecateEOF},
+		FeatureSessionTrack:    {since: ServerVersion{5, 7, 0}, flag: flagSessionTrack},
+	},
+	FlavorMariaDB: {
+		FeatureJSON:            {since: ServerVersion{10, 2, 7}},
+		FeatureCTE:             {since: ServerVersion{10, 2, 1}},
+		FeatureWindowFunctions: {since: ServerVersion{10, 2, 0}},
+		FeatureDeprecateEOF:    {since: ServerVersion{10, 2, 0}, flag: flagDeprecateEOF},
+		FeatureSessionTrack:    {since: ServerVersion{10, 2, 0}, flag: flagSessionTrack},
+	},
+	FlavorTiDB: {
+		FeatureJSON:            {since: ServerVersion{2, 1, 0}},
+		FeatureCTE:             {since: ServerVersion{5, 1, 0}},
+		FeatureWindowFunctions: {since: ServerVersion{3, 0, 0}},
+		FeatureCachingSHA2:     {since: ServerVersion{5, 2, 0}},
+		FeatureDeprecateEOF:    {flag: flagDeprecateEOF},
+		FeatureSessionTrack:    {flag: flagSessionTrack},
+	},
+	FlavorVitess: {
+		FeatureJSON:            {},
+		FeatureCTE:             {since: ServerVersion{14, 0, 0}},
+		FeatureWindowFunctions: {since: ServerVersion{16, 0, 0}},
+		FeatureCachingSHA2:     {},
+		FeatureDeprecateEOF:    {flag: flagDeprecateEOF},
+		FeatureSessionTrack:    {flag: flagSessionTrack},
+	},
+}
+
+// Supports returns true if the server supports f.
+func (info ServerInfo) Supports(f Feature) bool {
+	support, ok := features[info.Flavor][f]
+	if !ok {
+		return false
+	}
+
+	if info.ParsedVersion.Compare(support.since) < 0 {
+		return false
+	}
+
+	return connectionFlag(info.Capabilities)&support.flag == support.flag
+}
@@ -0,0 +1,61 @@
+package gms
+
+import (
+	"testing"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		flavor  ServerFlavor
+		want    ServerVersion
+	}{
+		{"5.7.44-log", FlavorMySQL, ServerVersion{5, 7, 44}},
+		{"8.0.36", FlavorMySQL, ServerVersion{8, 0, 36}},
+		{"8.4.0-commercial", FlavorMySQL, ServerVersion{8, 4, 0}},
+		{"5.5.5-10.11.6-MariaDB-1:10.11.6+maria~ubu2204", FlavorMariaDB, ServerVersion{10, 11, 6}},
+		{"11.4.2-MariaDB", FlavorMariaDB, ServerVersion{11, 4, 2}},
+		{"8.0.11-TiDB-v7.5.0", FlavorTiDB, ServerVersion{7, 5, 0}},
+		{"5.7.25-TiDB-None", FlavorTiDB, ServerVersion{5, 7, 25}},
+		{"8.0.30-Vitess", FlavorVitess, ServerVersion{8, 0, 30}},
+		{"8.0.30-vitess-19.0.0", FlavorVitess, ServerVersion{19, 0, 0}},
+		{"8", FlavorMySQL, ServerVersion{8, 0, 0}},
+		{"", FlavorMySQL, ServerVersion{}},
+	}
+
+	for _, test := range tests {
+		flavor, v := ParseServerVersion(test.version)
+		if flavor != test.flavor || v != test.want {
+			t.Errorf("ParseServerVersion(%q) = (%v, %v), want (%v, %v)", test.version, flavor, v, test.flavor, test.want)
+		}
+	}
+}
+
+func TestServerInfoSupports(t *testing.T) {
+	tests := []struct {
+		version string
+		caps    connectionFlag
+		feature Feature
+		want    bool
+	}{
+		{"5.7.44", 0, FeatureJSON, true},
+		{"5.7.44", 0, FeatureCTE, false},
+		{"8.0.36", 0, FeatureCTE, true},
+		{"8.0.36", 0, FeatureQueryAttributes, false},
+		{"8.0.36", flagQueryAttributes, FeatureQueryAttributes, true},
+		{"8.0.22", flagQueryAttributes, FeatureQueryAttributes, false},
+		{"5.5.5-10.11.6-MariaDB", flagQueryAttributes, FeatureQueryAttributes, false},
+		{"5.5.5-10.11.6-MariaDB", 0, FeatureWindowFunctions, true},
+		{"5.5.5-10.11.6-MariaDB", 0, FeatureCachingSHA2, false},
+		{"8.0.11-TiDB-v7.5.0", flagDeprecateEOF, FeatureDeprecateEOF, true},
+		{"8.0.30-Vitess", 0, FeatureCTE, false},
+	}
+
+	for _, test := range tests {
+		info := ServerInfo{Version: test.version, Capabilities: uint32(test.caps)}
+		info.Flavor, info.ParsedVersion = ParseServerVersion(test.version)
+		if got := info.Supports(test.feature); got != test.want {
+			t.Errorf("%q with capabilities %#x: Supports(%v) = %v, want %v", test.version, test.caps, test.feature, got, test.want)
+		}
+	}
+}